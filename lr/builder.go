@@ -2,6 +2,7 @@ package lr
 
 import (
 	"fmt"
+	"regexp"
 	"text/scanner"
 )
 
@@ -150,6 +151,30 @@ func (rb *RuleBuilder) L(s string) *RuleBuilder {
 	return rb
 }
 
+// R appends a terminal to the builder, which matches a regular expression
+// or a rune class, e.g.
+//
+//     b.LHS("Number").R("[0-9]+").End()   // Number  ->  [0-9]+
+//
+// R is intended for scannerless parsing, where the parser reads runes instead
+// of tokens (see earley.Parser.ParseRunes). A pattern-terminal matches the longest
+// prefix of the remaining input described by the pattern. The symbol created
+// will have the pattern as its name and a token value generated from an internal
+// sequence. The method call will panic if the pattern is not a valid regular
+// expression (see package regexp).
+func (rb *RuleBuilder) R(pattern string) *RuleBuilder {
+	re := regexp.MustCompile("^(?:" + pattern + ")") // anchor at start of remaining input
+	re.Longest()
+	tokval := rb.gb.tokenValueSequence + 1
+	sym := rb.gb.g.resolveOrDefineTerminal(pattern, tokval)
+	if sym.Value == tokval && sym.Pattern == nil { // newly defined
+		rb.gb.tokenValueSequence++
+		sym.Pattern = re
+	}
+	rb.rule.rhs = append(rb.rule.rhs, sym)
+	return rb
+}
+
 // AppendSymbol appends your own symbol objects to the builder to extend the RHS of a rule.
 // Clients will have to make sure no different 2 symbols have the same ID
 // and no symbol ID equals a token value of a non-terminal. This restriction
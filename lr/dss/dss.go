@@ -29,6 +29,14 @@ The main API of this DSS consists of
 	stacks        = stack.Reduce(handle)         // reduce with RHS of a production
 	stack         = stack.Push(state, symbol)    // transition to new parse state, i.e. a shift
 	                stack.Die()                  // end of life for this stack
+	                root.Advance()               // move on to the next input position
+
+Semantic values may be attached to stack nodes. This is useful for parsers which
+perform semantic actions during the parse, e.g. to resolve ambiguities on the fly.
+
+	stack         = stack.PushValue(state, symbol, value, merge)
+	value         = stack.Value()                // semantic value at TOS
+	stacks, vals  = stack.ReduceWithValues(handle)
 
 Additionally, there are some low-level methods, which may help debugging or
 implementing your own add-on functionality.
@@ -37,6 +45,15 @@ implementing your own add-on functionality.
 	DSS2Dot(root, path, writer)                  // output to Graphviz DOT format
 	WalkDAG(root, worker, arg)                   // execute a function on each DSS node
 
+For visualizing the operation of a parser, a Trace records snapshots of the DSS,
+e.g. after each shift and reduce. It may be exported to JSON or to an HTML page,
+which animates the sequence of snapshots.
+
+	trace = NewTrace(name)
+	        trace.Record(root, label, path)      // take a snapshot, highlighting path
+	        trace.WriteJSON(writer)
+	        trace.WriteHTML(writer)
+
 Other methods of the API are rarely used in parsing and exist more or less
 to complete a conventional stack API. Note that a method for determining the
 size of a stack is missing.
@@ -84,6 +101,7 @@ import (
 	"strconv"
 
 	ssl "github.com/emirpasic/gods/lists/singlylinkedlist"
+	"github.com/npillmayer/gorgo"
 	"github.com/npillmayer/gorgo/lr"
 	"github.com/npillmayer/schuko/tracing"
 )
@@ -97,11 +115,14 @@ func tracer() tracing.Trace {
 
 // Node is a type for a node in a DSS stack.
 type Node struct {
-	State   int        // identifier of a parse state
-	Sym     *lr.Symbol // symbol on the stack (between states)
-	preds   []*Node    // predecessors of this node (inverse join)
-	succs   []*Node    // successors of this node (inverse fork)
-	pathcnt int        // count of paths through this node
+	State   int         // identifier of a parse state
+	Sym     *lr.Symbol  // symbol on the stack (between states)
+	Value   interface{} // semantic value of Sym, if any
+	preds   []*Node     // predecessors of this node (inverse join)
+	succs   []*Node     // successors of this node (inverse fork)
+	pathcnt int         // count of paths through this node
+	pos     uint64      // input position at which this node has been pushed
+	serial  int         // number of creation, distinguishes re-used nodes
 }
 
 // create an empty stack node
@@ -120,6 +141,8 @@ func (n *Node) String() string {
 // Make an identical copy of a node
 func (n *Node) clone() *Node {
 	nn := newNode(n.State, n.Sym)
+	nn.Value = n.Value
+	nn.pos = n.pos
 	for _, p := range n.preds {
 		nn.prepend(p)
 	}
@@ -182,8 +205,8 @@ func (n *Node) predecessorCount() int {
 	return 0
 }
 
-func (n *Node) findUplink(sym *lr.Symbol) *Node {
-	s := contains(n.succs, sym)
+func (n *Node) findUplink(sym *lr.Symbol, pos uint64) *Node {
+	s := contains(n.succs, sym, pos)
 	return s
 }
 
@@ -192,6 +215,19 @@ func (n *Node) findDownlink(state int) *Node {
 	return s
 }
 
+// Span returns the span of input covered by the symbol of a node, i.e. from the
+// input position of its predecessor to the position the node has been pushed at.
+// If a node has more than one predecessor, the shortest span is returned.
+func (n *Node) Span() gorgo.Span {
+	start := uint64(0)
+	for _, p := range n.preds {
+		if p.pos > start {
+			start = p.pos
+		}
+	}
+	return gorgo.Span{start, n.pos}
+}
+
 // NodePath is a run within a stack. We often will have to deal with fragments of a stack
 type NodePath []*Node
 
@@ -204,6 +240,9 @@ type Root struct {
 	bottom    *Node     // stopper
 	stacks    []*Stack  // housekeeping
 	reservoir *ssl.List // list of nodes to be re-used
+	frontier  uint64    // current input position
+	tops      []*Node   // nodes pushed at the current input position
+	serials   int       // counter for node creation
 }
 
 // NewRoot creates a named root for a DSS-stack, given a name.
@@ -227,6 +266,19 @@ func (root *Root) ActiveStacks() []*Stack {
 	return dup
 }
 
+// StackCount returns the number of stack-heads currently active in the DSS.
+func (root *Root) StackCount() int {
+	return len(root.stacks)
+}
+
+// Advance signals that the parser moves on to the next input position.
+// Nodes pushed afterwards will not be shared with nodes pushed before, as they
+// cover different spans of the input.
+func (root *Root) Advance() {
+	root.frontier++
+	root.tops = root.tops[:0]
+}
+
 // Remove a stack from the list of stacks
 func (root *Root) removeStack(stack *Stack) {
 	for i, s := range root.stacks {
@@ -244,8 +296,11 @@ func (root *Root) removeStack(stack *Stack) {
 // TODO: migrate this to stdlib's sync/pool.
 func (root *Root) recycleNode(node *Node) {
 	tracer().Debugf("recycling node %v", node)
+	root.tops, _ = remove(root.tops, node)
 	node.State = 0
 	node.Sym = nil
+	node.Value = nil
+	node.pos = 0
 	node.preds = node.preds[0:0]
 	node.succs = node.succs[0:0]
 	root.reservoir.Append(node) // list of nodes free for grab
@@ -263,6 +318,9 @@ func (root *Root) newNode(state int, sym *lr.Symbol) *Node {
 	} else {
 		node = newNode(state, sym)
 	}
+	node.pos = root.frontier
+	root.serials++
+	node.serial = root.serials
 	return node
 }
 
@@ -278,12 +336,21 @@ func (root *Root) makeStackHeadsFrom(nodes []*Node) (ret []*Stack) {
 
 // Find a TOS of any stack in the DSS which carries state and sym.
 // This is needed for suffix merging. Returns nil if no TOS meets the criteria.
+//
+// Nodes pushed at the current input position are considered as well, even if
+// no stack is sitting on them any more (e.g., after a reduce by an ε-rule).
+// Otherwise cyclic ε-derivations would create new nodes endlessly.
 func (root *Root) findTOSofAnyStack(state int, sym *lr.Symbol) *Node {
 	for _, stack := range root.stacks {
-		if stack.tos.is(state, sym) {
+		if stack.tos.is(state, sym) && stack.tos.pos == root.frontier {
 			return stack.tos
 		}
 	}
+	for _, node := range root.tops {
+		if node.is(state, sym) && node.pos == root.frontier {
+			return node
+		}
+	}
 	return nil
 }
 
@@ -378,14 +445,29 @@ The method looks for a TOS in the DSS representing the combination of
 creates a new DAG node.
 */
 func (stack *Stack) Push(state int, sym *lr.Symbol) *Stack {
+	stack, _ = stack.PushJoin(state, sym)
+	return stack
+}
+
+/*
+PushJoin pushes a state and a symbol on the stack, in the same way as Push does.
+Additionally it reports the node the stack has been linked from, if the push
+has joined the stack with the TOS of another stack, i.e. if a new link has been
+added to an already existing node. Otherwise it returns nil.
+
+GLR parsers need this information: if the node joined has already been reduced,
+reductions have to be re-done for paths via the new link.
+*/
+func (stack *Stack) PushJoin(state int, sym *lr.Symbol) (*Stack, *Node) {
 	if sym == nil {
-		return stack
+		return stack, nil
 	}
 	// create or find a node
 	// - create: new and let node.prev be tos
 	// - find: return existing one
 	// update references / pathcnt
-	if succ := stack.tos.findUplink(sym); succ != nil {
+	var joined *Node
+	if succ := stack.tos.findUplink(sym, stack.root.frontier); succ != nil {
 		tracer().Debugf("state already present: %v", succ)
 		stack.tos = succ // pushed state is already there, upchain
 	} else {
@@ -394,17 +476,78 @@ func (stack *Stack) Push(state int, sym *lr.Symbol) *Stack {
 			succ = stack.root.newNode(state, sym)
 			tracer().Debugf("creating state: %v", succ)
 			succ.pathcnt = stack.tos.pathcnt
+			stack.root.tops = append(stack.root.tops, succ)
 		} else {
 			tracer().Debugf("found state on other stack: %v", succ)
 			succ.pathcnt++
+			joined = stack.tos
 		}
 		succ.prepend(stack.tos)
 		stack.tos.append(succ)
 		stack.tos = succ
 	}
+	return stack, joined
+}
+
+// MergeFunc is a type for functions merging two semantic values of a symbol.
+// It is called whenever two stacks push the same symbol covering the same
+// span of input, i.e. for local ambiguities. It returns the merged value.
+type MergeFunc func(sym *lr.Symbol, v1, v2 interface{}) interface{}
+
+// PushValue pushes a state and a symbol on the stack, together with a semantic
+// value for the symbol.
+//
+// Different from Push, a node carrying a value is shared only if it covers the
+// same span of input, i.e. if it has been pushed from the same predecessor node
+// at the same input position. If such a node is found and sym is a non-terminal,
+// merge is called to combine the existing value with the new one. If merge is nil,
+// the existing value is kept.
+func (stack *Stack) PushValue(state int, sym *lr.Symbol, value interface{}, merge MergeFunc) *Stack {
+	if sym == nil {
+		return stack
+	}
+	if succ := stack.tos.findUplink(sym, stack.root.frontier); succ != nil {
+		tracer().Debugf("state already present: %v", succ)
+		if merge != nil && !sym.IsTerminal() {
+			tracer().Debugf("merging values for %v", sym)
+			succ.Value = merge(sym, succ.Value, value)
+		}
+		stack.tos = succ
+		return stack
+	}
+	succ := stack.root.newNode(state, sym)
+	succ.Value = value
+	succ.pathcnt = stack.tos.pathcnt
+	tracer().Debugf("creating state: %v", succ)
+	succ.prepend(stack.tos)
+	stack.tos.append(succ)
+	stack.tos = succ
 	return stack
 }
 
+// TOS returns the top node of the stack. Different stacks may share the same
+// top node.
+func (stack *Stack) TOS() *Node {
+	return stack.tos
+}
+
+// Value returns the semantic value of the TOS.
+func (stack *Stack) Value() interface{} {
+	if stack.tos == nil {
+		return nil
+	}
+	return stack.tos.Value
+}
+
+// Values returns the semantic values of the nodes of a path.
+func (path NodePath) Values() []interface{} {
+	vals := make([]interface{}, len(path))
+	for i, n := range path {
+		vals[i] = n.Value
+	}
+	return vals
+}
+
 // FindHandlePath finds a run within a stack corresponding to a handle, i.e.,
 // a list of parse symbols.
 //
@@ -436,29 +579,30 @@ func (stack *Stack) FindHandlePath(handle []*lr.Symbol, skip int) NodePath {
 // being filled with node links on the way.
 func collectHandleBranch(n *Node, handleRest []*lr.Symbol, handleLen int, skip *int) (NodePath, bool) {
 	l := len(handleRest)
-	if l > 0 {
-		if n.Sym == handleRest[l-1] {
-			tracer().Debugf("handle symbol match at %d = %v", l-1, n.Sym)
-			for _, pred := range n.preds {
-				branch, found := collectHandleBranch(pred, handleRest[:l-1], handleLen, skip)
-				if found {
-					if *skip == 0 {
-						tracer().Debugf("partial branch: %v", branch)
-						if branch != nil { // a-ha, deepest node has created collector
-							branch[l-1] = n // collect n in branch
-						}
-						return branch, true // return with partially filled branch
-					} else {
-						*skip = max(0, *skip-1)
-					}
-				}
-			}
+	if l == 0 {
+		return make([]*Node, handleLen), true
+	}
+	if n.Sym != handleRest[l-1] {
+		return nil, false // abort search, handle-path not found
+	}
+	tracer().Debugf("handle symbol match at %d = %v", l-1, n.Sym)
+	if l == 1 { // bottom-most node of the handle: a path has been found
+		if *skip > 0 { // path has been found previously
+			*skip--
+			return nil, false
 		}
-	} else {
-		branchCreated := make([]*Node, handleLen) // create the handle-path collector
-		return branchCreated, true                // search/recursion terminates
+		branch := make([]*Node, handleLen) // create the handle-path collector
+		branch[0] = n
+		return branch, true
 	}
-	return nil, false // abort search, handle-path not found
+	for _, pred := range n.preds {
+		if branch, found := collectHandleBranch(pred, handleRest[:l-1], handleLen, skip); found {
+			tracer().Debugf("partial branch: %v", branch)
+			branch[l-1] = n     // collect n in branch
+			return branch, true // return with partially filled branch
+		}
+	}
+	return nil, false
 }
 
 // This is probably never used for a real parser
@@ -582,6 +726,15 @@ func (stack *Stack) reduce(path NodePath, destructive bool) (ret []*Stack) {
 // other stacks created during the reduce operation.
 //
 func (stack *Stack) Reduce(handle []*lr.Symbol) (ret []*Stack) {
+	ret, _ = stack.ReduceWithValues(handle)
+	return
+}
+
+// ReduceWithValues performs a reduce operation in the same way as Reduce does.
+// Additionally it returns the semantic values of the handle nodes, one slice of
+// values for each returned stack, in order of the handle symbols.
+// Values have to be collected before reducing, as reduced nodes may be recycled.
+func (stack *Stack) ReduceWithValues(handle []*lr.Symbol) (ret []*Stack, values [][]interface{}) {
 	if len(handle) == 0 {
 		return
 	}
@@ -595,8 +748,12 @@ func (stack *Stack) Reduce(handle []*lr.Symbol) (ret []*Stack) {
 	}
 	destructive := stack.IsAlone() // give general permission to delete reduced nodes?
 	for _, path = range paths {    // now reduce along every path
+		vals := path.Values()
 		stacks := stack.reduce(path, destructive)
 		ret = append(ret, stacks...) // collect returned stack heads
+		for range stacks {
+			values = append(values, vals)
+		}
 	}
 	if len(ret) > 0 { // if avail, replace 1st stack with this
 		stack.tos = ret[0].tos // make us a lookalike of the 1st returned one
@@ -606,6 +763,37 @@ func (stack *Stack) Reduce(handle []*lr.Symbol) (ret []*Stack) {
 	return
 }
 
+// ReduceLink performs a reduce operation for a handle at node tos, like
+// ReduceWithValues does for a stack. Different from ReduceWithValues, only
+// handle paths using the link from node to down to its predecessor from are
+// considered. Nodes are never deleted. The stack heads returned are registered
+// with the root.
+//
+// GLR parsers need this operation whenever a new link is added to a node
+// which has already been reduced (see PushJoin).
+func (root *Root) ReduceLink(tos *Node, handle []*lr.Symbol, to, from *Node) (ret []*Stack, values [][]interface{}) {
+	if len(handle) == 0 {
+		return
+	}
+	top := &Stack{root: root, tos: tos} // not registered with root
+	for skip := 0; ; skip++ {
+		path := top.FindHandlePath(handle, skip)
+		if path == nil {
+			return
+		}
+		uses := false // does path use the link?
+		for i := 1; i < len(path); i++ {
+			uses = uses || (path[i] == to && path[i-1] == from)
+		}
+		for _, pred := range path[0].preds {
+			if uses || (path[0] == to && pred == from) {
+				ret = append(ret, root.makeStackHeadsFrom([]*Node{pred})...)
+				values = append(values, path.Values())
+			}
+		}
+	}
+}
+
 func (stack *Stack) reduceHandle(handle []*lr.Symbol, destructive bool) (ret []*Stack) {
 	haveReduced := true
 	skipCnt := 0
@@ -731,12 +919,21 @@ func DSS2Dot(root *Root, path []*Node, w io.Writer) {
 
 func nodeDotStyles(node *Node, highlight bool) string {
 	s := ",style=filled"
+	s = s + fmt.Sprintf(",fillcolor=\"%s\"", fillcolor(node.pathcnt, highlight))
+	return s
+}
+
+// fillcolor returns a fill color for a node, depending on its path count.
+func fillcolor(pathcnt int, highlight bool) string {
+	if pathcnt < 0 {
+		pathcnt = 0
+	} else if pathcnt >= len(hexcolors) {
+		pathcnt = len(hexcolors) - 1
+	}
 	if highlight {
-		s = s + fmt.Sprintf(",fillcolor=\"%s\"", hexhlcolors[node.pathcnt])
-	} else {
-		s = s + fmt.Sprintf(",fillcolor=\"%s\"", hexcolors[node.pathcnt])
+		return hexhlcolors[pathcnt]
 	}
-	return s
+	return hexcolors[pathcnt]
 }
 
 var hexhlcolors = [...]string{"#FFEEDD", "#FFDDCC", "#FFCCAA", "#FFBB88", "#FFAA66",
@@ -789,10 +986,10 @@ func pathContains(s []*Node, node *Node) bool {
 	return false
 }
 
-func contains(s []*Node, sym *lr.Symbol) *Node {
+func contains(s []*Node, sym *lr.Symbol, pos uint64) *Node {
 	if s != nil {
 		for _, n := range s {
-			if n.Sym == sym {
+			if n.Sym == sym && n.pos == pos {
 				return n
 			}
 		}
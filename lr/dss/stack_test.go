@@ -279,6 +279,32 @@ func TestReduce2(t *testing.T) {
 	//DSS2Dot(r, nil, tmp)
 }
 
+func TestReduceLink(t *testing.T) {
+	A, B, D := pseudosym("A"), pseudosym("B"), pseudosym("D")
+	r := NewRoot("G", -999)
+	s1 := NewStack(r)
+	s2 := NewStack(r)
+	s1.Push(1, A).Push(2, B)
+	d := s2.Push(4, D).TOS()
+	if _, link := s2.PushJoin(2, B); link != d {
+		t.Fatalf("expected push to join TOS of stack 1, linked from %v", d)
+	}
+	top := s1.TOS()
+	if s1.FindHandlePath([]*lr.Symbol{B}, 1) != nil {
+		t.Errorf("expected single handle path for join node")
+	}
+	heads, values := r.ReduceLink(top, []*lr.Symbol{B}, top, d)
+	if len(heads) != 1 || heads[0].TOS() != d || len(values[0]) != 1 {
+		t.Errorf("expected reduce via link to lead to %v, have %v", d, heads)
+	}
+	if heads, _ = r.ReduceLink(top, []*lr.Symbol{A, B}, top, d); len(heads) != 0 {
+		t.Errorf("expected reduce not to use other links, have %v", heads)
+	}
+	if heads, _ = r.ReduceLink(top, []*lr.Symbol{D, B}, top, d); len(heads) != 1 || heads[0].TOS() != r.bottom {
+		t.Errorf("expected reduce via link to lead to bottom, have %v", heads)
+	}
+}
+
 /*
 func TestReduce1(t *testing.T) {
 	traceOn()
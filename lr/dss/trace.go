@@ -0,0 +1,299 @@
+package dss
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"math"
+
+	"github.com/npillmayer/gorgo"
+)
+
+// Trace records a sequence of snapshots of a DSS. It is intended for
+// visualizing the operation of a GLR parser step by step, e.g., taking a snapshot
+// after every shift and every reduce. A trace may be exported to JSON or to a
+// self-contained HTML page which animates the snapshots.
+//
+// Nodes keep their IDs across snapshots, as long as they are alive. Nodes
+// which are recycled by the DSS get a new ID.
+type Trace struct {
+	Name      string      `json:"name"`
+	Snapshots []*Snapshot `json:"snapshots"`
+	ids       map[nodeKey]int
+}
+
+// Snapshot is the state of a DSS at a single step of a trace.
+type Snapshot struct {
+	Step  int         `json:"step"`
+	Label string      `json:"label"` // description of the step, e.g. "shift a"
+	Nodes []TraceNode `json:"nodes"`
+	Edges []TraceEdge `json:"edges"`
+	Path  []int       `json:"path,omitempty"` // IDs of highlighted nodes, e.g. of a handle
+	Dot   string      `json:"dot"`            // GraphViz rendering, see DSS2Dot
+}
+
+// TraceNode is a node of the DSS within a snapshot.
+type TraceNode struct {
+	ID        int        `json:"id"`
+	State     int        `json:"state"`
+	Symbol    string     `json:"symbol"`
+	Span      gorgo.Span `json:"span"`
+	PathCount int        `json:"pathcnt"`
+	TOS       bool       `json:"tos,omitempty"`       // node is top of a stack
+	Highlight bool       `json:"highlight,omitempty"` // node is on the highlighted path
+	column    int        // for layout
+	row       int        // for layout
+}
+
+// TraceEdge links a node to one of its predecessors within a snapshot.
+// The edge is labeled with the symbol of the From-node.
+type TraceEdge struct {
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+	Symbol string `json:"symbol"`
+}
+
+type nodeKey struct {
+	node   *Node
+	serial int
+}
+
+// NewTrace creates an empty trace.
+func NewTrace(name string) *Trace {
+	return &Trace{
+		Name:      name,
+		Snapshots: make([]*Snapshot, 0, 32),
+		ids:       make(map[nodeKey]int),
+	}
+}
+
+func (t *Trace) id(node *Node) int {
+	key := nodeKey{node, node.serial}
+	id, ok := t.ids[key]
+	if !ok {
+		id = len(t.ids) + 1
+		t.ids[key] = id
+	}
+	return id
+}
+
+// Record takes a snapshot of the DSS and appends it to the trace. Nodes on path
+// will be highlighted, as with DSS2Dot. Path may be nil.
+func (t *Trace) Record(root *Root, label string, path []*Node) *Snapshot {
+	snap := &Snapshot{
+		Step:  len(t.Snapshots),
+		Label: label,
+		Nodes: make([]TraceNode, 0, 16),
+		Edges: make([]TraceEdge, 0, 16),
+	}
+	istos := map[*Node]bool{}
+	for _, stack := range root.stacks {
+		istos[stack.tos] = true
+	}
+	rows := map[int]int{} // rows in use per column
+	WalkDAG(root, func(node *Node, arg interface{}) {
+		tn := TraceNode{
+			ID:        t.id(node),
+			State:     node.State,
+			Symbol:    symname(node),
+			Span:      node.Span(),
+			PathCount: node.pathcnt,
+			TOS:       istos[node],
+			Highlight: pathContains(path, node),
+		}
+		if node != root.bottom {
+			tn.column = int(node.pos) + 1
+		}
+		tn.row = rows[tn.column]
+		rows[tn.column]++
+		snap.Nodes = append(snap.Nodes, tn)
+		for _, p := range node.preds {
+			snap.Edges = append(snap.Edges, TraceEdge{
+				From:   tn.ID,
+				To:     t.id(p),
+				Symbol: symname(node),
+			})
+		}
+	}, nil)
+	for _, node := range path {
+		if node != nil {
+			snap.Path = append(snap.Path, t.id(node))
+		}
+	}
+	var dot bytes.Buffer
+	DSS2Dot(root, path, &dot)
+	snap.Dot = dot.String()
+	t.Snapshots = append(t.Snapshots, snap)
+	tracer().Debugf("trace %s: recorded snapshot #%d: %s", t.Name, snap.Step, label)
+	return snap
+}
+
+func symname(node *Node) string {
+	if node.Sym == nil {
+		return ""
+	}
+	return node.Sym.Name
+}
+
+// WriteJSON exports a trace in JSON format.
+func (t *Trace) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(t)
+}
+
+// WriteHTML exports a trace as a self-contained HTML page. Every snapshot is
+// rendered as an SVG image. The page contains controls to step through the
+// snapshots or to play them as an animation. No external resources are required.
+func (t *Trace) WriteHTML(w io.Writer) error {
+	var b bytes.Buffer
+	name := html.EscapeString(t.Name)
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>DSS trace %s</title>\n", name)
+	b.WriteString(traceCSS)
+	b.WriteString("</head>\n<body>\n")
+	fmt.Fprintf(&b, "<h3>DSS trace %s</h3>\n", name)
+	b.WriteString(`<div class="controls">
+<button id="first">&#x23EE;</button>
+<button id="prev">&#x25C0;</button>
+<button id="play">&#x25B6; play</button>
+<button id="next">&#x25B6;</button>
+<button id="last">&#x23ED;</button>
+`)
+	fmt.Fprintf(&b, "<input type=\"range\" id=\"slider\" min=\"0\" max=\"%d\" value=\"0\">\n",
+		max(len(t.Snapshots)-1, 0))
+	b.WriteString("<span id=\"label\"></span>\n</div>\n")
+	for _, snap := range t.Snapshots {
+		fmt.Fprintf(&b, "<div class=\"frame\" data-label=\"%s\">\n", html.EscapeString(snap.Label))
+		snap.writeSVG(&b)
+		b.WriteString("</div>\n")
+	}
+	b.WriteString(traceJS)
+	b.WriteString("</body>\n</html>\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+const (
+	svgColWidth  = 110
+	svgRowHeight = 70
+	svgMargin    = 40
+	svgRadius    = 16
+)
+
+func (snap *Snapshot) writeSVG(b *bytes.Buffer) {
+	cols, rows := 1, 1
+	at := map[int]*TraceNode{}
+	for i := range snap.Nodes {
+		n := &snap.Nodes[i]
+		at[n.ID] = n
+		cols = max(cols, n.column+1)
+		rows = max(rows, n.row+1)
+	}
+	width := 2*svgMargin + (cols-1)*svgColWidth
+	height := 2*svgMargin + (rows-1)*svgRowHeight
+	fmt.Fprintf(b, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\">\n",
+		width, height)
+	b.WriteString(`<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" ` +
+		`markerWidth="6" markerHeight="6" orient="auto-start-reverse">` +
+		`<path d="M 0 0 L 10 5 L 0 10 z"/></marker></defs>` + "\n")
+	for _, e := range snap.Edges {
+		from, to := at[e.From], at[e.To]
+		if from == nil || to == nil {
+			continue
+		}
+		x1, y1 := from.center()
+		x2, y2 := to.center()
+		dx, dy := shorten(x2-x1, y2-y1)
+		fmt.Fprintf(b, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" marker-end=\"url(#arrow)\"/>\n",
+			x1+dx, y1+dy, x2-dx, y2-dy)
+		fmt.Fprintf(b, "<text class=\"sym\" x=\"%d\" y=\"%d\">%s</text>\n",
+			(x1+x2)/2, (y1+y2)/2-4, html.EscapeString(e.Symbol))
+	}
+	for _, n := range snap.Nodes {
+		x, y := n.center()
+		fill := fillcolor(n.PathCount, n.Highlight)
+		fmt.Fprintf(b, "<g><title>state %d, symbol %s, span %s, %d path(s)</title>\n",
+			n.State, html.EscapeString(n.Symbol), n.Span, n.PathCount)
+		if n.TOS {
+			fmt.Fprintf(b, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\"/>\n",
+				x-svgRadius, y-svgRadius, 2*svgRadius, 2*svgRadius, fill)
+		} else {
+			fmt.Fprintf(b, "<circle cx=\"%d\" cy=\"%d\" r=\"%d\" fill=\"%s\"/>\n",
+				x, y, svgRadius, fill)
+		}
+		fmt.Fprintf(b, "<text class=\"state\" x=\"%d\" y=\"%d\">%d</text></g>\n", x, y+4, n.State)
+	}
+	b.WriteString("</svg>\n")
+}
+
+func (n *TraceNode) center() (int, int) {
+	return svgMargin + n.column*svgColWidth, svgMargin + n.row*svgRowHeight
+}
+
+// shorten returns the offset of a line's end points with respect to the
+// node centers, in order to let the line start and end at the node's border.
+func shorten(dx, dy int) (int, int) {
+	d := math.Hypot(float64(dx), float64(dy))
+	if d == 0 {
+		return 0, 0
+	}
+	return int(float64(dx) * svgRadius / d), int(float64(dy) * svgRadius / d)
+}
+
+const traceCSS = `<style>
+body { font-family: sans-serif; }
+.controls { margin-bottom: 1em; }
+.controls #label { margin-left: 1em; font-family: monospace; }
+.frame { display: none; }
+svg line { stroke: #444; stroke-width: 1.2; }
+svg circle, svg rect { stroke: #444; stroke-width: 1.2; }
+svg text { font-size: 12px; text-anchor: middle; }
+svg text.sym { fill: #A33; }
+</style>
+`
+
+const traceJS = `<script>
+(function() {
+  var frames = document.querySelectorAll(".frame");
+  var slider = document.getElementById("slider");
+  var label = document.getElementById("label");
+  var playButton = document.getElementById("play");
+  var cur = 0, timer = null;
+  function show(i) {
+    if (frames.length == 0 || i < 0 || i >= frames.length) return;
+    frames[cur].style.display = "none";
+    cur = i;
+    frames[cur].style.display = "block";
+    slider.value = cur;
+    label.textContent = "step " + cur + " of " + (frames.length-1) + ": " + frames[cur].dataset.label;
+  }
+  function stop() {
+    if (timer) { clearInterval(timer); timer = null; }
+    playButton.innerHTML = "&#x25B6; play";
+  }
+  function play() {
+    if (timer) { stop(); return; }
+    if (cur == frames.length-1) show(0);
+    playButton.innerHTML = "&#x23F8; pause";
+    timer = setInterval(function() {
+      if (cur >= frames.length-1) { stop(); return; }
+      show(cur+1);
+    }, 800);
+  }
+  document.getElementById("first").onclick = function() { stop(); show(0); };
+  document.getElementById("prev").onclick = function() { stop(); show(cur-1); };
+  document.getElementById("next").onclick = function() { stop(); show(cur+1); };
+  document.getElementById("last").onclick = function() { stop(); show(frames.length-1); };
+  playButton.onclick = play;
+  slider.oninput = function() { stop(); show(parseInt(slider.value)); };
+  document.onkeydown = function(e) {
+    if (e.key == "ArrowLeft") { stop(); show(cur-1); }
+    else if (e.key == "ArrowRight") { stop(); show(cur+1); }
+  };
+  show(0);
+})();
+</script>
+`
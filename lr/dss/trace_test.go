@@ -0,0 +1,47 @@
+package dss
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/npillmayer/gorgo/lr"
+)
+
+func TestTrace(t *testing.T) {
+	A, B, C := pseudosym("A"), pseudosym("B"), pseudosym("C")
+	r := NewRoot("G", -999)
+	trace := NewTrace("test")
+	s1 := NewStack(r)
+	s1.Push(1, A)
+	r.Advance()
+	s1.Push(2, B)
+	trace.Record(r, "push B", nil)
+	path := s1.FindHandlePath([]*lr.Symbol{A, B}, 0)
+	trace.Record(r, "handle A B", path)
+	s1.Reduce([]*lr.Symbol{A, B})
+	s1.Push(3, C)
+	trace.Record(r, "reduce", nil)
+	if len(trace.Snapshots) != 3 {
+		t.Fatalf("expected 3 snapshots, have %d", len(trace.Snapshots))
+	}
+	snap := trace.Snapshots[1]
+	if len(snap.Path) != 2 || len(snap.Nodes) != 3 || len(snap.Edges) != 2 {
+		t.Errorf("snapshot incorrect: %d nodes, %d edges, path=%v", len(snap.Nodes),
+			len(snap.Edges), snap.Path)
+	}
+	if snap.Nodes[0].ID != trace.Snapshots[0].Nodes[0].ID {
+		t.Errorf("expected bottom node to keep its ID across snapshots")
+	}
+	var buf bytes.Buffer
+	if err := trace.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var imported Trace
+	if err := json.Unmarshal(buf.Bytes(), &imported); err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Snapshots) != 3 || imported.Snapshots[2].Label != "reduce" {
+		t.Errorf("JSON export incorrect")
+	}
+}
@@ -0,0 +1,197 @@
+package earley
+
+import (
+	"fmt"
+
+	"github.com/npillmayer/gorgo/lr"
+)
+
+/*
+The chart.
+
+Earley items [A→α•β, j] are represented as dense integer IDs: the serial number
+of the rule, the position of the dot and the origin j are packed into a single
+machine word. Comparing, hashing and advancing items therefore are cheap integer
+operations, and sets of items do not need to store interface values.
+
+Every Earley set keeps its items in order of insertion (the list acts as a work
+queue, as suggested by Earley) together with two index tables: one for testing
+membership and one for finding the items [B→…•A…, k] waiting for a non-terminal
+A. The latter is what the completer needs, and without it, every completion
+would have to scan the complete set Sj.
+*/
+
+// itemID is a compact encoding of an Earley item.
+type itemID uint64
+
+// Layout of an itemID: | origin : 32 | rule : 20 | dot : 12 |
+const (
+	dotBits    = 12
+	ruleBits   = 20
+	originBits = 32
+	dotMask    = 1<<dotBits - 1
+	ruleMask   = 1<<ruleBits - 1
+	originMax  = 1<<originBits - 1
+)
+
+// rule returns the serial number of the rule of an item.
+func (id itemID) rule() int {
+	return int(id>>dotBits) & ruleMask
+}
+
+// dot returns the position of the dot of an item.
+func (id itemID) dot() int {
+	return int(id & dotMask)
+}
+
+// origin returns the origin of an item.
+func (id itemID) origin() uint64 {
+	return uint64(id >> (dotBits + ruleBits))
+}
+
+// withOrigin returns an item with its origin replaced.
+func (id itemID) withOrigin(origin uint64) itemID {
+	return id&(1<<(dotBits+ruleBits)-1) | itemID(origin)<<(dotBits+ruleBits)
+}
+
+// chart holds the tables shared by all Earley sets of a parse.
+type chart struct {
+	rules       []*lr.Rule                // rules by serial number, rule 0 being the start rule
+	predictions map[int][]itemID          // start items [B→•α, 0] for every rule of B
+	filtered    map[lookaheadKey][]itemID // predictions filtered by lookahead, see lookahead.go
+}
+
+// newChart creates the item tables for a grammar, given the start rule of a parse.
+func newChart(g *lr.Grammar, start *lr.Rule) *chart {
+	c := &chart{
+		predictions: make(map[int][]itemID),
+		filtered:    make(map[lookaheadKey][]itemID),
+	}
+	for n := 0; g.Rule(n) != nil; n++ {
+		r := g.Rule(n)
+		if len(r.RHS()) > dotMask || n > ruleMask {
+			panic(fmt.Sprintf("grammar too large for Earley items: %v", r))
+		}
+		c.rules = append(c.rules, r)
+	}
+	c.rules[0] = start // start rule may be synthesized, see lr.Grammar.StartRule
+	return c
+}
+
+// id encodes an item.
+func (c *chart) id(item lr.Item) itemID {
+	if item.Origin > originMax {
+		panic(fmt.Sprintf("input too long for Earley items: %d", item.Origin))
+	}
+	return itemID(item.Origin)<<(dotBits+ruleBits) |
+		itemID(item.Rule().Serial)<<dotBits | itemID(item.Dot())
+}
+
+// item decodes an item.
+func (c *chart) item(id itemID) lr.Item {
+	return lr.MakeItem(c.rules[id.rule()], id.dot(), id.origin())
+}
+
+// peek returns the symbol after the dot of an item, if any.
+func (c *chart) peek(id itemID) *lr.Symbol {
+	rhs := c.rules[id.rule()].RHS()
+	if id.dot() >= len(rhs) {
+		return nil
+	}
+	return rhs[id.dot()]
+}
+
+// predict returns the start items [B→•α, 0] for the rules of B.
+func (c *chart) predict(B *lr.Symbol) []itemID {
+	ids, ok := c.predictions[B.Value]
+	if !ok {
+		for n, r := range c.rules {
+			if n > 0 && r.LHS == B { // start rule is never predicted
+				ids = append(ids, itemID(n)<<dotBits)
+			}
+		}
+		c.predictions[B.Value] = ids
+	}
+	return ids
+}
+
+// newSet creates an empty Earley set.
+func (c *chart) newSet() *earleySet {
+	return &earleySet{chart: c}
+}
+
+// earleySet is a set of Earley items, kept in order of insertion.
+type earleySet struct {
+	chart     *chart
+	items     []itemID            // items in order of insertion
+	members   map[itemID]struct{} // index for membership
+	waiting   map[int][]itemID    // items [B→…•A…, k], indexed by A
+	predicted map[int]bool        // non-terminals predicted, true if not filtered by lookahead
+}
+
+// Add adds an item to S and returns true if it has not been in S before.
+func (S *earleySet) Add(item lr.Item) bool {
+	return S.add(S.chart.id(item))
+}
+
+func (S *earleySet) add(id itemID) bool {
+	if S.members == nil {
+		S.members = make(map[itemID]struct{})
+		S.waiting = make(map[int][]itemID)
+		S.predicted = make(map[int]bool)
+	}
+	if _, ok := S.members[id]; ok {
+		return false
+	}
+	S.members[id] = struct{}{}
+	S.items = append(S.items, id)
+	if A := S.chart.peek(id); A != nil && !A.IsTerminal() {
+		S.waiting[A.Value] = append(S.waiting[A.Value], id)
+	}
+	return true
+}
+
+// Contains returns true if item is in S.
+func (S *earleySet) Contains(item lr.Item) bool {
+	_, ok := S.members[S.chart.id(item)]
+	return ok
+}
+
+// Size returns the number of items in S.
+func (S *earleySet) Size() int {
+	return len(S.items)
+}
+
+// Empty returns true if S has no items.
+func (S *earleySet) Empty() bool {
+	return len(S.items) == 0
+}
+
+// Item returns the n-th item of S, in order of insertion.
+func (S *earleySet) Item(n int) lr.Item {
+	return S.chart.item(S.items[n])
+}
+
+// Each calls f for every item of S. Items added by f will be visited as well.
+func (S *earleySet) Each(f func(lr.Item)) {
+	for n := 0; n < len(S.items); n++ {
+		f(S.chart.item(S.items[n]))
+	}
+}
+
+// markPredicted records that the rules of B have been predicted in S, either all
+// of them or filtered by lookahead. It returns false if this has been done before.
+func (S *earleySet) markPredicted(B *lr.Symbol, all bool) bool {
+	done, ok := S.predicted[B.Value]
+	if ok && (done || !all) {
+		return false
+	}
+	S.predicted[B.Value] = all
+	return true
+}
+
+// waitingFor returns the items [B→…•A…, k] of S. Items added to S afterwards
+// are not included.
+func (S *earleySet) waitingFor(A *lr.Symbol) []itemID {
+	return S.waiting[A.Value]
+}
@@ -1,39 +1,15 @@
 package earley
 
 import (
-	"bytes"
-
-	"github.com/npillmayer/gorgo/lr"
-	"github.com/npillmayer/gorgo/lr/iteratable"
+	"github.com/npillmayer/schuko/tracing"
 )
 
-func dumpState(states []*iteratable.Set, stateno uint64) {
-	tracer().Debugf("--- State %04d ------------------------------------", stateno)
-	S := states[stateno]
-	n := 1
-	S.IterateOnce()
-	for S.Next() {
-		item := S.Item().(lr.Item)
-		tracer().Debugf("[%2d] %s", n, item)
-		n++
+func dumpState(states []*earleySet, stateno uint64) {
+	if tracer().GetTraceLevel() < tracing.LevelDebug {
+		return // avoid decoding items for nothing
 	}
-}
-
-func itemSetString(S *iteratable.Set) string {
-	var b bytes.Buffer
-	b.WriteString("{")
-	S.IterateOnce()
-	first := true
-	for S.Next() {
-		item := S.Item().(lr.Item)
-		if first {
-			b.WriteString(" ")
-			first = false
-		} else {
-			b.WriteString(", ")
-		}
-		b.WriteString(item.String())
+	tracer().Debugf("--- State %04d ------------------------------------", stateno)
+	for n := 0; n < states[stateno].Size(); n++ {
+		tracer().Debugf("[%2d] %s", n+1, states[stateno].Item(n))
 	}
-	b.WriteString(" }")
-	return b.String()
 }
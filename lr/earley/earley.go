@@ -60,13 +60,12 @@ Copyright © 2017–2021 Norbert Pillmayer <norbert@pillmayer.com>
 package earley
 
 import (
+	"context"
 	"fmt"
-
-	"github.com/cnf/structhash"
+	"strings"
 
 	"github.com/npillmayer/gorgo"
 	"github.com/npillmayer/gorgo/lr"
-	"github.com/npillmayer/gorgo/lr/iteratable"
 	"github.com/npillmayer/gorgo/lr/scanner"
 	"github.com/npillmayer/gorgo/lr/sppf"
 	"github.com/npillmayer/schuko/tracing"
@@ -79,27 +78,45 @@ func tracer() tracing.Trace {
 
 // Parser is an Earley-parser type. Create and initialize one with earley.NewParser(...)
 type Parser struct {
-	ga        *lr.LRAnalysis              // the analyzed grammar we operate on
-	scanner   scanner.Tokenizer           // scanner deliveres tokens
-	states    []*iteratable.Set           // list of states, each a set of Earley-items
-	tokens    []gorgo.Token               // we remember all input tokens, if requested
-	sc        uint64                      // state counter
-	mode      uint                        // flags controlling some behaviour of the parser
-	Error     func(p *Parser, msg string) // Error is called for each error encountered
-	forest    *sppf.Forest                // parse forest, if generated
-	backlinks map[string]lr.Item          // stores backlinks for parsetree-generation
+	ga           *lr.LRAnalysis              // the analyzed grammar we operate on
+	start        *lr.Rule                    // start rule S′ ➞ S #eof
+	scanner      scanner.Tokenizer           // scanner deliveres tokens
+	states       []*earleySet                // list of states, each a set of Earley-items
+	chart        *chart                      // tables for encoding items
+	tokens       []gorgo.Token               // we remember all input tokens, if requested
+	sc           uint64                      // state counter
+	mode         uint                        // flags controlling some behaviour of the parser
+	Error        func(p *Parser, msg string) // Error is called for each error encountered
+	forest       *sppf.Forest                // parse forest, if generated
+	bforest      *forest                     // binarised parse forest, built during recognition
+	leoItems     map[leoKey]lr.Item          // memoized transitive items
+	leoShortcuts []leoShortcut               // completions abbreviated by transitive items
+	maxCost      int                         // maximum cost for repairing an input
+	repaired     *ritem                      // accepting item of a repaired input
+	input        []gorgo.Token               // original input, if repaired
+	edits        []Edit                      // edit operations of a repair
+	runes        *runeInput                  // input of a scannerless parse
+	lexemes      map[scanKey]gorgo.Token     // tokens scanned, if terminals may span positions
+	slippers     SlipperHook                 // hook for fabricating tokens, if any
+	online       *onlineParse                // state of online semantic actions, if any
+	ctx          context.Context             // context of the current parse
+	limits       limits                      // resource limits, 0 for no limit
+	itemCount    int                         // number of items in the sets processed so far
+	stats        Stats                       // statistics of the last parse
 }
 
 // NewParser creates and initializes an Earley parser.
 func NewParser(ga *lr.LRAnalysis, opts ...Option) *Parser {
 	p := &Parser{
-		ga:        ga,
-		scanner:   nil,
-		states:    make([]*iteratable.Set, 1, 512), // pre-alloc first state
-		tokens:    make([]gorgo.Token, 1, 512),     // pre-alloc first slot
-		backlinks: make(map[string]lr.Item),
-		sc:        0,
-		mode:      optionStoreTokens,
+		ga:       ga,
+		start:    ga.Grammar().Rule(0),
+		scanner:  nil,
+		states:   make([]*earleySet, 1, 512),  // pre-alloc first state
+		tokens:   make([]gorgo.Token, 1, 512), // pre-alloc first slot
+		leoItems: make(map[leoKey]lr.Item),
+		sc:       0,
+		mode:     optionStoreTokens | optionLeo,
+		ctx:      context.Background(),
 	}
 	for _, opt := range opts {
 		opt(p)
@@ -140,39 +157,143 @@ type inputSymbol struct {
 // The parser must have been initialized with an analyzed grammar.
 // It returns true if the input string has been accepted.
 //
-// Clients may provide a Listener to perform semantic actions.
-func (p *Parser) Parse(scan scanner.Tokenizer, listener Listener) (accept bool, err error) {
+// Clients may provide a Listener to perform semantic actions. The listener is
+// called online, as soon as items complete (see Value and AmbiguityListener).
+// Positions within spans are the positions of the Earley sets, levels are 0.
+// Online actions need every completion, therefore Leo's optimization (see
+// OptimizeRightRecursion) is not used for a parse with a listener.
+//
+// If the input is rejected, Parse returns a *SyntaxError, describing the
+// furthest position the parser has been able to reach. If error correction is
+// enabled (see option MaxRepairCost), the parser tries to repair the input first.
+// On success, the input is accepted and the repair is available via Edits.
+//
+// If scan is a scanner.LatticeTokenizer, the parser accepts alternative tokens
+// per input position and lets the grammar resolve lexical ambiguity. Positions
+// are then input positions, as given by the spans of the tokens, and TokenAt
+// returns the tokens chosen by the derivation. Error correction is not supported
+// for token lattices.
+func (p *Parser) Parse(scan scanner.Tokenizer, listener Listener) (bool, error) {
+	return p.ParseContext(context.Background(), scan, listener)
+}
+
+// ParseContext is like Parse, but aborts the parse if ctx is done, returning
+// ctx.Err(). If a resource limit of the parser has been exceeded (see options
+// MaxItemsPerSet, MaxItems and MaxForestNodes), the parse is aborted as well and
+// ParseContext returns false together with an error of type *lr.LimitError.
+func (p *Parser) ParseContext(ctx context.Context, scan scanner.Tokenizer, listener Listener) (accept bool, err error) {
 	if p.scanner = scan; scan == nil {
 		return false, fmt.Errorf("Earley-parser needs a valid scanner, is void")
 	}
+	p.ctx = ctx
+	p.forest, p.lexemes = nil, nil
+	p.repaired, p.edits = nil, nil
+	p.startOnline(listener)
+	if lat, ok := scan.(scanner.LatticeTokenizer); ok {
+		return p.parseLattice(lat)
+	}
 	p.scanner.SetErrorHandler(func(e error) {
 		err = e
 	})
-	p.forest = nil
-	startItem, _ := lr.StartItem(p.ga.Grammar().Rule(0)) // create S′→•S
-	p.states[0] = iteratable.NewSet(0)                   // S0
-	p.states[0].Add(startItem)                           // S0 = { [S′→•S, 0] }
+	p.states[0].Add(p.startChart()) // S0 = { [S′→•S, 0] }
+	var input []gorgo.Token         // tokens read, if we may need a repair
 	token := p.scanner.NextToken()
 	for { // outer loop over Si per input token xi
 		tracer().Debugf("Scanner read '%v|%d' @ %v", token, token.TokType(), token.Span())
 		x := inputSymbol{int(token.TokType()), token, token.Span()}
+		if p.maxCost > 0 {
+			input = append(input, token)
+		}
 		i := p.setupNextState(token)
-		p.innerLoop(i, x)
+		if lerr := p.innerLoop(i, x, p.lookahead(x)); lerr != nil {
+			return false, lerr
+		}
+		if p.states[i+1].Empty() && p.hasmode(optionLookahead) {
+			if lerr := p.innerLoop(i, x, anyToken); lerr != nil { // predict every terminal acceptable at i
+				return false, lerr
+			}
+		}
+		if lerr := p.checkLimits(i); lerr != nil {
+			return false, lerr
+		}
+		if p.states[i+1].Empty() && p.slippers != nil {
+			if t := p.fabricate(i, token); t != nil { // scan t, then retry token
+				if p.maxCost > 0 {
+					input = append(input[:len(input)-1], t) // token will be appended again
+				}
+				continue
+			}
+		}
+		if p.states[i+1].Empty() { // no item has been able to scan x
+			tracer().Infof("Earley set S%d is empty, input rejected", i+1)
+			serr := p.syntaxError(i, token)
+			if p.maxCost > 0 {
+				repaired, lerr := p.repair(p.readAll(input))
+				if lerr != nil {
+					return false, lerr
+				}
+				if accept = repaired; accept {
+					break
+				}
+			}
+			if err == nil {
+				err = serr
+			}
+			return false, err
+		}
 		if x.tokval == scanner.EOF {
+			if lerr := p.checkLimits(i + 1); lerr != nil { // final set
+				return false, lerr
+			}
 			break
 		}
 		token = p.scanner.NextToken()
 	}
-	if accept = p.checkAccept(); accept && p.hasmode(optionGenerateTree) {
-		p.buildTree()
+	if accept = accept || p.checkAccept(); accept && p.hasmode(optionGenerateTree) {
+		if lerr := p.generateTree(); lerr != nil {
+			return false, lerr
+		}
 	}
 	return
 }
 
+// ParseAs parses an input as a non-terminal A, instead of as the start symbol
+// of the grammar. This is helpful for parsing snippets, e.g. a single expression,
+// with a grammar for a complete language. ParseAs returns an error if A is not a
+// non-terminal of the grammar. See also option StartSymbol.
+//
+// A is the start symbol for this parse only, later calls of Parse will again
+// parse inputs as the start symbol configured for the parser.
+func (p *Parser) ParseAs(A *lr.Symbol, scan scanner.Tokenizer, listener Listener) (bool, error) {
+	start := p.ga.Grammar().StartRule(A)
+	if start == nil {
+		return false, fmt.Errorf("cannot parse as %v, not a non-terminal of the grammar", A)
+	}
+	defer func(start *lr.Rule) {
+		p.start = start
+	}(p.start)
+	p.start = start
+	return p.Parse(scan, listener)
+}
+
+// startChart prepares the chart for a new parse, creating an empty set S0 and
+// dropping the sets of a previous parse. It returns the start item [S′→•S, 0].
+func (p *Parser) startChart() lr.Item {
+	p.chart = newChart(p.ga.Grammar(), p.start)
+	p.bforest = newForest(p.chart)
+	p.leoItems = make(map[leoKey]lr.Item)
+	p.leoShortcuts = p.leoShortcuts[:0]
+	p.states, p.tokens = p.states[:1], p.tokens[:1]
+	p.sc, p.itemCount, p.stats = 0, 0, Stats{}
+	p.states[0] = p.chart.newSet()
+	startItem, _ := lr.StartItem(p.start)
+	return startItem
+}
+
 // Invariant: we're in set Si and prepare Si+1
 func (p *Parser) setupNextState(token gorgo.Token) uint64 {
 	// first one has already been created before outer loop
-	p.states = append(p.states, iteratable.NewSet(0))
+	p.states = append(p.states, p.chart.newSet())
 	if p.hasmode(optionStoreTokens) {
 		p.tokens = append(p.tokens, token)
 	}
@@ -182,89 +303,208 @@ func (p *Parser) setupNextState(token gorgo.Token) uint64 {
 }
 
 // The inner loop iterates over Si, applying Scanner, Predictor and Completer.
-// The variable for Si is called S and Si+1 is called S1.
-func (p *Parser) innerLoop(i uint64, x inputSymbol) {
+// The variable for Si is called S and Si+1 is called S1. Predictions may be
+// filtered by a lookahead token, see lookahead.go.
+//
+// Resource limits are checked for every item, as a cascade of completions may
+// make S grow considerably. If a limit has been exceeded, innerLoop stops and
+// returns an error.
+func (p *Parser) innerLoop(i uint64, x inputSymbol, la int) error {
 	S := p.states[i]
 	S1 := p.states[i+1]
-	S.IterateOnce()
-	for S.Next() {
-		item := S.Item().(lr.Item)
-		p.scan(S, S1, item, x.tokval) // may add items to S1
-		p.predict(S, S1, item, i)     // may add items to S
+	for n := 0; n < S.Size(); n++ { // S grows while we iterate
+		if err := p.checkSet(i, S.Size()); err != nil {
+			return err
+		}
+		item := S.Item(n)
+		if p.scan(S, S1, item, x.tokval) { // may add items to S1
+			token := x.token.(gorgo.Token)
+			p.bforest.scanned(item, token, i, i+1)
+			if p.online != nil {
+				p.online.scanned(item, i, token, gorgo.Span{i, i + 1})
+			}
+		}
+		p.predict(S, S1, item, i, la) // may add items to S
 		p.complete(S, S1, item, i)    // may add items to S
 	}
 	dumpState(p.states, i)
+	return nil
 }
 
 // Scanner:
 // If [A→…•a…, j] is in Si and a=xi+1, add [A→…a•…, j] to Si+1
-func (p *Parser) scan(S, S1 *iteratable.Set, item lr.Item, tokval int) {
+func (p *Parser) scan(S, S1 *earleySet, item lr.Item, tokval int) bool {
 	if a := item.PeekSymbol(); a != nil {
 		if a.Value == tokval {
 			S1.Add(item.Advance())
+			return true
 		}
 	}
+	return false
 }
 
 // Predictor:
 // If [A→…•B…, j] is in Si, add [B→•α, i] to Si for all rules B→α.
 // If B is nullable, also add [A→…B•…, j] to Si.
-func (p *Parser) predict(S, S1 *iteratable.Set, item lr.Item, i uint64) {
+//
+// Unless the lookahead la is anyToken, rules B→α which cannot derive a sentence
+// starting with la are skipped.
+func (p *Parser) predict(S, S1 *earleySet, item lr.Item, i uint64, la int) {
 	B := item.PeekSymbol()
-	startitemsForB := p.ga.Grammar().FindNonTermRules(B, true)
-	startitemsForB.Each(func(e interface{}) { // e is a start item
-		startitem := e.(lr.Item)
-		startitem.Origin = i
-		S.Add(startitem)
-	})
+	if B == nil || B.IsTerminal() {
+		return
+	}
+	if S.markPredicted(B, la == anyToken) { // rules for B have not yet been predicted in S
+		predictions := p.predictFor(B, la)
+		if la != anyToken {
+			p.stats.Filtered += len(p.chart.predict(B)) - len(predictions)
+		}
+		for _, startitem := range predictions {
+			if S.add(startitem.withOrigin(i)) {
+				p.stats.Predicted++
+			}
+		}
+	}
 	if p.ga.DerivesEpsilon(B) { // B is nullable?
+		p.bforest.nulled(item, i)
+		if p.online != nil {
+			p.online.nulled(item, i)
+		}
 		S.Add(item.Advance())
 	}
 }
 
 // Completer:
 // If [A→…•, j] is in Si, add [B→…A•…, k] to Si for all items [B→…•A…, k] in Sj.
-func (p *Parser) complete(S, S1 *iteratable.Set, item lr.Item, i uint64) {
+//
+// With Leo's optimization enabled, a cascade of deterministic completions is
+// abbreviated by adding the completed item at the top of the cascade (see leo.go).
+func (p *Parser) complete(S, S1 *earleySet, item lr.Item, i uint64) {
 	if item.PeekSymbol() == nil { // dot is behind RHS
-		A, j := item.Rule().LHS, item.Origin
-		Sj := p.states[j]
-		R := Sj.Copy().Subset(func(e interface{}) bool { // find all [B→…•A…, k]
-			jtem := e.(lr.Item)
-			return jtem.PeekSymbol() == A
-		})
-		R.Each(func(e interface{}) { // now add [B→…A•…, k]
-			jtem := e.(lr.Item)
-			if jadv := jtem.Advance(); jadv != lr.NullItem {
-				if jadv.PeekSymbol() == nil {
-					// store this backlink for later parsetree generation
-					h := hash(jadv, i)
-					p.backlinks[h] = item
-				}
-				S.Add(jadv)
+		if item.Dot() == 0 { // ε-rule
+			p.bforest.epsilon(item, i)
+		}
+		if p.online != nil {
+			p.online.reduce(item, i)
+		}
+		if A, j := item.Rule().LHS, item.Origin; p.hasmode(optionLeo) && p.online == nil && j < i {
+			if t, ok := p.leoItem(j, A); ok {
+				tracer().Debugf("completing %v by transitive item %v", item, t)
+				p.leoShortcuts = append(p.leoShortcuts, leoShortcut{item: item, pos: i})
+				S.Add(t)
+				return
 			}
+		}
+		p.completeItem(item, i, func(jadv lr.Item) {
+			S.Add(jadv)
 		})
 	}
 }
 
+// completeItem finds all items [B→…•A…, k] in Sj for a completed item [A→…•, j]
+// and calls add for [B→…A•…, k].
+func (p *Parser) completeItem(item lr.Item, i uint64, add func(lr.Item)) {
+	A, j := item.Rule().LHS, item.Origin
+	for _, id := range p.states[j].waitingFor(A) { // find all [B→…•A…, k]
+		jtem := p.chart.item(id)
+		jadv := jtem.Advance() // now add [B→…A•…, k]
+		p.bforest.completed(jtem, j, item, i)
+		if p.online != nil {
+			p.online.advance(jtem, j, i, p.online.reduce(item, i))
+		}
+		add(jadv)
+	}
+}
+
 // checkAccepts searches the final state for items with a dot after #eof
 // and a LHS of the start rule.
 // It returns true if an accepting item has been found, indicating that the
 // input has been recognized.
 func (p *Parser) checkAccept() bool {
 	dumpState(p.states, p.sc)
-	S := p.states[p.sc] // last state should contain accept item
-	S.IterateOnce()
 	acc := false
-	for S.Next() {
-		item := S.Item().(lr.Item)
-		if item.PeekSymbol() == nil && item.Rule().LHS == p.ga.Grammar().Rule(0).LHS {
+	p.states[p.sc].Each(func(item lr.Item) { // last state should contain accept item
+		if item.PeekSymbol() == nil && item.Rule().LHS == p.start.LHS {
 			tracer().Debugf("ACCEPT: %s", item)
 			acc = true
 		}
-	}
+	})
 	return acc
 }
 
+// --- Syntax errors ---------------------------------------------------------
+
+// SyntaxError is returned by Parse if the input has been rejected. It reports the
+// last position where the chart has been non-empty, i.e. the furthest position
+// the parser has been able to reach, together with the terminals which items at
+// this position had been waiting for. This enables clients to produce messages
+// like "unexpected X, expected one of: …".
+//
+// Completions holds the partial results found up to the point of failure: rules
+// which have been recognized for a span of the input.
+type SyntaxError struct {
+	Position    uint64       // last position with a non-empty Earley set (token count)
+	Token       gorgo.Token  // offending token following Position
+	Expected    []*lr.Symbol // terminals acceptable at Position
+	Completions []Completion // rules completed up to Position
+}
+
+// Completion is a rule which has been recognized for a span of the input.
+// Spans are in units of tokens.
+type Completion struct {
+	Rule *lr.Rule
+	Span gorgo.Span
+}
+
+func (e *SyntaxError) Error() string {
+	var b strings.Builder
+	for i, t := range e.Expected {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(t.Name)
+	}
+	unexpected := "end of input"
+	if e.Token != nil && e.Token.TokType() != scanner.EOF {
+		unexpected = fmt.Sprintf("%q", e.Token.Lexeme())
+	}
+	return fmt.Sprintf("syntax error at %d: unexpected %s, expected one of: %s",
+		e.Position, unexpected, b.String())
+}
+
+// syntaxError collects information for a SyntaxError from the chart. Set Si is the
+// last non-empty Earley set.
+func (p *Parser) syntaxError(i uint64, token gorgo.Token) *SyntaxError {
+	p.expandLeoItems() // partial results should include every completion
+	serr := &SyntaxError{Position: i, Token: token, Expected: p.expected(i)}
+	for k := uint64(0); k <= i; k++ {
+		p.states[k].Each(func(item lr.Item) {
+			if item.PeekSymbol() == nil {
+				serr.Completions = append(serr.Completions, Completion{
+					Rule: item.Rule(),
+					Span: gorgo.Span{item.Origin, k},
+				})
+			}
+		})
+	}
+	return serr
+}
+
+// expected returns the terminals which items of set Si are waiting for,
+// sorted by name.
+func (p *Parser) expected(i uint64) []*lr.Symbol {
+	var terminals []*lr.Symbol
+	seen := map[int]bool{}
+	p.states[i].Each(func(item lr.Item) {
+		if a := item.PeekSymbol(); a != nil && a.IsTerminal() && !seen[a.Value] {
+			seen[a.Value] = true
+			terminals = append(terminals, a)
+		}
+	})
+	sortSymbols(terminals)
+	return terminals
+}
+
 // ParseForest returns the parse forest for the last Parse-run, if any.
 // Parser option GenerateTree must have been set to true at parser-creation time.
 // In case of serious parsing errors, generation of a forest may have been abandoned
@@ -273,20 +513,34 @@ func (p *Parser) ParseForest() *sppf.Forest {
 	return p.forest
 }
 
-// Build a parse forest from the derivation produced during a parse run.
-// We use a special derivation walker TreeBuilder, which creates an SPPF.
+// Build a parse forest from the binarised forest produced during a parse run.
+// For repaired input, we use a special derivation walker TreeBuilder, which
+// creates an SPPF for the cheapest derivation.
 func (p *Parser) buildTree() error {
-	builder := NewTreeBuilder(p.ga.Grammar())
-	root := p.WalkDerivation(builder)
-	_, ok := root.Value.(*sppf.SymbolNode)
-	if !ok || root.Symbol().Name != "S'" { // should have reduced top level rule
-		p.forest = nil
+	if p.repaired != nil {
+		builder := NewTreeBuilder(p.ga.Grammar())
+		builder.maxNodes = p.limits.forestNodes
+		root := p.WalkDerivation(builder)
+		if builder.exceeded {
+			p.forest = nil
+			return p.limitError(lr.ForestNodes, p.limits.forestNodes, p.sc)
+		}
 		if root == nil {
 			return fmt.Errorf("returned parse forest is empty")
 		}
-		return fmt.Errorf("expected root node of forest to be start symbol, is %v", root.Symbol())
+		p.forest = builder.Forest()
+		return nil
+	}
+	p.expandLeoItems() // forest needs all completed items
+	if err := p.checkForest(p.sc); err != nil {
+		p.forest = nil
+		return err
+	}
+	root := p.rootNode()
+	if root == nil {
+		return fmt.Errorf("returned parse forest is empty")
 	}
-	p.forest = builder.Forest()
+	p.forest = p.toSPPF(root)
 	return nil
 }
 
@@ -313,6 +567,8 @@ type Option func(p *Parser)
 const (
 	optionStoreTokens  uint = 1 << 1 // store all input tokens, defaults to true
 	optionGenerateTree uint = 1 << 2 // if parse was successful, generate a parse forest (default false)
+	optionLeo          uint = 1 << 3 // use Leo's optimization for right recursion (default true)
+	optionLookahead    uint = 1 << 4 // filter predictions by the next token (default false)
 )
 
 // StoreTokens configures the parser to remember all input tokens. This is
@@ -338,22 +594,49 @@ func GenerateTree(b bool) Option {
 	}
 }
 
-func (p *Parser) hasmode(m uint) bool {
-	return p.mode&m > 0
+// OptimizeRightRecursion configures the parser to use Leo's optimization for
+// right recursive rules. With it, the parser runs in linear time for every
+// LR-regular grammar, at the expense of some additional work for walking the
+// derivation. Defaults to true. Parses with a Listener do not use the
+// optimization, see Parse.
+func OptimizeRightRecursion(b bool) Option {
+	return func(p *Parser) {
+		if b {
+			p.mode |= optionLeo
+		} else {
+			p.mode &^= optionLeo
+		}
+	}
 }
 
-// --- Helpers ---------------------------------------------------------------
-
-func hash(i lr.Item, stateno uint64) string {
-	hash, err := structhash.Hash(struct {
-		item  lr.Item
-		state uint64
-	}{ // put it in an anonymous struct
-		item:  i,
-		state: stateno,
-	}, 1)
-	if err != nil { // no reason for this to happend, but API demands it
-		panic(err)
+// MaxRepairCost configures the parser to repair erroneous input by inserting,
+// deleting or substituting terminals. Each edit operation has a cost of 1, and
+// the parser searches for the cheapest repair with a total cost not greater than
+// n. Error correction is disabled for n ≤ 0 (default).
+//
+// After a repaired parse, the edit operations are available via Edits. The parse
+// forest reflects the repaired input.
+func MaxRepairCost(n int) Option {
+	return func(p *Parser) {
+		p.maxCost = n
 	}
-	return hash
+}
+
+// StartSymbol configures the parser to parse inputs as non-terminal A, instead
+// of as the start symbol of the grammar. The parser synthesizes a start rule
+// S′ ➞ A #eof, thus no second grammar is needed. See also ParseAs.
+//
+// StartSymbol panics if A is not a non-terminal of the grammar.
+func StartSymbol(A *lr.Symbol) Option {
+	return func(p *Parser) {
+		start := p.ga.Grammar().StartRule(A)
+		if start == nil {
+			panic(fmt.Sprintf("cannot parse as %v, not a non-terminal of the grammar", A))
+		}
+		p.start = start
+	}
+}
+
+func (p *Parser) hasmode(m uint) bool {
+	return p.mode&m > 0
 }
@@ -1,6 +1,7 @@
 package earley
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
@@ -31,7 +32,7 @@ import (
 //
 // 'number' is a terminal symbol recognizing Go integers.
 //
-func makeGrammar(t *testing.T) *lr.LRAnalysis {
+func makeGrammar(t testing.TB) *lr.LRAnalysis {
 	b := lr.NewGrammarBuilder("Expressions")
 	b.LHS("Sum").N("Sum").T("+", '+').N("Product").End()
 	b.LHS("Sum").N("Product").End()
@@ -84,6 +85,609 @@ func TestParser1(t *testing.T) {
 	}
 }
 
+func TestSyntaxError(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	input := "1+*2"
+	parser, scanner := makeParser(t, "SyntaxError", input)
+	tracer().SetTraceLevel(tracing.LevelInfo)
+	accept, err := parser.Parse(scanner, nil)
+	if accept {
+		t.Fatalf("Invalid input string accepted: '%s'", input)
+	}
+	serr, ok := err.(*SyntaxError)
+	if !ok {
+		t.Fatalf("Expected parser to return a syntax error, got %v", err)
+	}
+	t.Logf("error = %v", serr)
+	if serr.Position != 2 || serr.Token.Lexeme() != "*" {
+		t.Errorf("Expected error at position 2 for token '*', is %d", serr.Position)
+	}
+	if len(serr.Expected) != 2 || serr.Expected[0].Name != "(" || serr.Expected[1].Name != "number" {
+		t.Errorf("Expected '(' and number to be expected, have %v", serr.Expected)
+	}
+	sum := false
+	for _, c := range serr.Completions {
+		if c.Rule.LHS.Name == "Sum" && c.Span == (gorgo.Span{0, 1}) {
+			sum = true
+		}
+	}
+	if !sum {
+		t.Errorf("Expected partial result Sum for '1', have %v", serr.Completions)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	names := func(syms []*lr.Symbol) string {
+		var s []string
+		for _, A := range syms {
+			s = append(s, A.Name)
+		}
+		return strings.Join(s, " ")
+	}
+	for _, x := range []struct {
+		input, terminals, nonterminals string
+		complete                       bool
+	}{
+		{"", "( number", "Factor Product Sum", false},
+		{"1+", "( number", "Factor Product", false},
+		{"1+2", "* +", "", true},
+		{"(1", ") * +", "", false},
+	} {
+		parser, scanner := makeParser(t, "Prefix", x.input)
+		tracer().SetTraceLevel(tracing.LevelInfo)
+		pred, err := parser.ParsePrefix(scanner)
+		if err != nil {
+			t.Fatalf("Expected '%s' to be a valid prefix, have %v", x.input, err)
+		}
+		if names(pred.Terminals) != x.terminals || names(pred.NonTerminals) != x.nonterminals ||
+			pred.Complete != x.complete {
+			t.Errorf("Unexpected prediction for '%s': %v | %v | %v", x.input,
+				pred.Terminals, pred.NonTerminals, pred.Complete)
+		}
+	}
+	parser, scanner := makeParser(t, "Prefix", "1+)")
+	if _, err := parser.ParsePrefix(scanner); err == nil {
+		t.Errorf("Expected '1+)' not to be a valid prefix")
+	}
+}
+
+func TestRepair(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	ga := makeGrammar(t)
+	tracer().SetTraceLevel(tracing.LevelInfo)
+	for _, x := range []struct {
+		input string
+		value int // value of the repaired expression, if unique
+	}{
+		{"()", 0},
+		{"(1+2", 3},
+		{"1+2+(3", 6},
+		{"1+2(3", 0},
+	} {
+		sc := scanner.GoTokenizer(x.input, strings.NewReader(x.input))
+		parser := NewParser(ga, MaxRepairCost(2), GenerateTree(true))
+		accept, err := parser.Parse(sc, nil)
+		if err != nil || !accept {
+			t.Fatalf("Expected input '%s' to be repaired, have %v", x.input, err)
+		}
+		edits := parser.Edits()
+		t.Logf("repaired '%s' with edits %v", x.input, edits)
+		if len(edits) != 1 {
+			t.Errorf("Expected '%s' to be repaired by 1 edit, have %v", x.input, edits)
+		}
+		if parser.ParseForest() == nil {
+			t.Errorf("Expected repaired forest for '%s'", x.input)
+		}
+		if x.value > 0 {
+			tracer().SetTraceLevel(tracing.LevelError)
+			v := parser.WalkDerivation(NewExprListener(t))
+			tracer().SetTraceLevel(tracing.LevelInfo)
+			if v.Value != x.value {
+				t.Errorf("Expected repaired '%s' to be %d, is %v", x.input, x.value, v.Value)
+			}
+		}
+	}
+	input := "()" // the only repair with cost 1 is inserting a number
+	sc := scanner.GoTokenizer(input, strings.NewReader(input))
+	parser := NewParser(ga, MaxRepairCost(1))
+	parser.Parse(sc, nil)
+	if e := parser.Edits(); len(e) != 1 || e[0].Op != Insert || e[0].Position != 1 ||
+		e[0].Symbol.Name != "number" {
+		t.Errorf("Expected '%s' to be repaired by inserting a number, have %v", input, e)
+	}
+	if tok := parser.TokenAt(1); tok == nil || tok.TokType() != scanner.Int {
+		t.Errorf("Expected inserted number to be present in repaired input, have %v", tok)
+	}
+	input = "1 2 3 4 5 6" // needs 3 edits
+	sc = scanner.GoTokenizer(input, strings.NewReader(input))
+	parser = NewParser(ga, MaxRepairCost(2))
+	if accept, err := parser.Parse(sc, nil); accept || err == nil {
+		t.Errorf("Expected '%s' not to be repairable with cost 2, have %v", input, parser.Edits())
+	}
+	input = "1+2+(3" // repair needs considerably more items than the regular parse
+	sc = scanner.GoTokenizer(input, strings.NewReader(input))
+	parser = NewParser(ga, MaxRepairCost(2), MaxItems(100))
+	accept, err := parser.Parse(sc, nil)
+	if lerr, ok := err.(*lr.LimitError); accept || !ok || lerr.Limit != lr.TotalItems {
+		t.Errorf("Expected repair of '%s' to exceed limit of %s, have %v", input, lr.TotalItems, err)
+	}
+}
+
+func TestScannerless(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("Scannerless")
+	b.LHS("Sum").N("Sum").L("+").N("Product").End()
+	b.LHS("Sum").N("Product").End()
+	b.LHS("Product").N("Product").L("*").N("Factor").End()
+	b.LHS("Product").N("Factor").End()
+	b.LHS("Factor").N("_").L("(").N("Sum").L(")").N("_").End()
+	b.LHS("Factor").N("_").R("[0-9]+").N("_").End()
+	b.LHS("_").R("[ \t]*").End()
+	g, err := b.Grammar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ga := lr.Analysis(g)
+	input := "12 + 3*( 456 )"
+	parser := NewParser(ga, GenerateTree(true))
+	tracer().SetTraceLevel(tracing.LevelInfo)
+	accept, err := parser.ParseRunes(strings.NewReader(input), nil)
+	if err != nil || !accept {
+		t.Fatalf("Valid input string not accepted: '%s', %v", input, err)
+	}
+	if tok := parser.TokenAt(9); tok == nil || tok.Lexeme() != "456" {
+		t.Errorf("Expected lexeme at 9 to be 456, is %v", tok)
+	}
+	spans := lexemeSpans{}
+	parser.WalkDerivation(spans)
+	for lexeme, span := range map[string]gorgo.Span{"12": {0, 2}, "3": {5, 6}, "456": {9, 12}, ")": {13, 14}} {
+		if spans[lexeme] != span {
+			t.Errorf("Expected lexeme %q to span %v, is %v", lexeme, span, spans[lexeme])
+		}
+	}
+	root := parser.ParseForest().Root()
+	if root == nil || root.Span() != (gorgo.Span{0, 15}) { // including #eof
+		t.Errorf("Expected forest root to span (0…15)")
+	}
+	checkWrappedBuilder(t, parser)
+	parser = NewParser(ga)
+	_, err = parser.ParseRunes(strings.NewReader("12 + * 3"), nil)
+	if serr, ok := err.(*SyntaxError); !ok || serr.Position != 5 || serr.Token.Lexeme() != "*" {
+		t.Errorf("Expected syntax error at position 5, have %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = parser.ParseRunesContext(ctx, strings.NewReader(input), nil)
+	if err != context.Canceled {
+		t.Errorf("Expected parse to be cancelled, have %v", err)
+	}
+}
+
+// wrappedBuilder is a listener forwarding to a TreeBuilder, as clients may do
+// for observing a walk.
+type wrappedBuilder struct {
+	*TreeBuilder
+	terminals int
+}
+
+func (wb *wrappedBuilder) TerminalAt(token gorgo.Token, span gorgo.Span, level int) interface{} {
+	wb.terminals++
+	return wb.TreeBuilder.TerminalAt(token, span, level)
+}
+
+// checkWrappedBuilder checks that terminals of a forest built by a wrapped
+// TreeBuilder are placed as in the parse forest.
+func checkWrappedBuilder(t *testing.T, parser *Parser) {
+	wb := &wrappedBuilder{TreeBuilder: NewTreeBuilder(parser.ga.Grammar())}
+	parser.WalkDerivation(wb)
+	terminals := make(map[string]bool)
+	for _, sd := range parser.ParseForest().Export().Symbols {
+		if sd.Terminal {
+			terminals[fmt.Sprintf("%s %v", sd.Name, sd.Span)] = true
+		}
+	}
+	n := 0
+	for _, sd := range wb.Forest().Export().Symbols {
+		if sd.Terminal {
+			n++
+			if !terminals[fmt.Sprintf("%s %v", sd.Name, sd.Span)] {
+				t.Errorf("Terminal %s %v of wrapped tree builder not in parse forest", sd.Name, sd.Span)
+			}
+		}
+	}
+	if n == 0 || wb.terminals == 0 {
+		t.Errorf("Expected wrapped tree builder to be called for terminals")
+	}
+}
+
+// lexemeSpans is a listener collecting the spans of non-empty lexemes
+type lexemeSpans map[string]gorgo.Span
+
+func (lexemeSpans) Reduce(lhs *lr.Symbol, rule int, children []*RuleNode, extent gorgo.Span,
+	level int) interface{} {
+	return nil
+}
+
+func (ls lexemeSpans) Terminal(token gorgo.Token, level int) interface{} {
+	if token.Lexeme() != "" {
+		ls[token.Lexeme()] = token.Span()
+	}
+	return nil
+}
+
+func TestLattice(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	const shr = -50 // token value for '>>'
+	b := lr.NewGrammarBuilder("Lattice")
+	b.LHS("Start").N("Type").End()
+	b.LHS("Start").N("Shift").End()
+	b.LHS("Type").T("id", scanner.Ident).T("<", '<').N("Type").T(">", '>').End()
+	b.LHS("Type").T("id", scanner.Ident).End()
+	b.LHS("Shift").T("id", scanner.Ident).T(">>", shr).T("id", scanner.Ident).End()
+	g, err := b.Grammar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ga := lr.Analysis(g)
+	tok := func(typ int, lexeme string, start uint64) gorgo.Token {
+		return scanner.MakeDefaultToken(gorgo.TokType(typ), lexeme,
+			gorgo.Span{start, start + uint64(len(lexeme))})
+	}
+	// A<B<C>>
+	lattice := scanner.NewTokenLattice([]gorgo.Token{
+		tok(scanner.Ident, "A", 0), tok('<', "<", 1), tok(scanner.Ident, "B", 2), tok('<', "<", 3),
+		tok(scanner.Ident, "C", 4), tok(shr, ">>", 5), tok('>', ">", 5), tok('>', ">", 6),
+	})
+	parser := NewParser(ga, GenerateTree(true))
+	tracer().SetTraceLevel(tracing.LevelInfo)
+	accept, err := parser.Parse(lattice, nil)
+	if err != nil || !accept {
+		t.Fatalf("Valid token lattice not accepted: %v", err)
+	}
+	if parser.TokenAt(5).Lexeme() != ">" || parser.TokenAt(6).Lexeme() != ">" {
+		t.Errorf("Expected tokens '>' '>' to be chosen, have %v %v", parser.TokenAt(5), parser.TokenAt(6))
+	}
+	if root := parser.ParseForest().Root(); root == nil || root.Span() != (gorgo.Span{0, 7}) {
+		t.Errorf("Expected forest root to span (0…7)")
+	}
+	// x >> y, with gaps between tokens
+	lattice = scanner.NewTokenLattice([]gorgo.Token{
+		tok(scanner.Ident, "x", 0), tok(shr, ">>", 2), tok('>', ">", 2), tok('>', ">", 3),
+		tok(scanner.Ident, "y", 5),
+	})
+	parser = NewParser(ga, GenerateTree(true))
+	accept, err = parser.Parse(lattice, nil)
+	if err != nil || !accept {
+		t.Fatalf("Valid token lattice not accepted: %v", err)
+	}
+	if parser.TokenAt(2).Lexeme() != ">>" || parser.TokenAt(3) != nil {
+		t.Errorf("Expected token '>>' to be chosen, have %v %v", parser.TokenAt(2), parser.TokenAt(3))
+	}
+	// x > y
+	lattice = scanner.NewTokenLattice([]gorgo.Token{
+		tok(scanner.Ident, "x", 0), tok('>', ">", 1), tok(scanner.Ident, "y", 2),
+	})
+	parser = NewParser(ga)
+	_, err = parser.Parse(lattice, nil)
+	if serr, ok := err.(*SyntaxError); !ok || serr.Position != 1 {
+		t.Errorf("Expected syntax error at position 1, have %v", err)
+	}
+	// lattices ending without EOF
+	for n, pos := range []uint64{0, 0, 1} {
+		lattice := &truncatedLattice{n: n, TokenLattice: scanner.NewTokenLattice([]gorgo.Token{
+			tok(scanner.Ident, "x", 0), tok(shr, ">>", 1), tok(scanner.Ident, "y", 3),
+		})}
+		_, err = NewParser(ga).Parse(lattice, nil)
+		if serr, ok := err.(*SyntaxError); !ok || serr.Position != pos {
+			t.Errorf("Expected syntax error at position %d for lattice truncated after %d, have %v",
+				pos, n, err)
+		}
+	}
+}
+
+// truncatedLattice is a token lattice which ends after n positions, without
+// delivering an EOF token.
+type truncatedLattice struct {
+	*scanner.TokenLattice
+	n int
+}
+
+func (tl *truncatedLattice) NextTokens() []gorgo.Token {
+	if tl.n == 0 {
+		return nil
+	}
+	tl.n--
+	return tl.TokenLattice.NextTokens()
+}
+
+func TestRubySlippers(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("Statements")
+	b.LHS("Prog").N("Prog").N("Stmt").End()
+	b.LHS("Prog").N("Stmt").End()
+	b.LHS("Stmt").T("id", scanner.Ident).T("=", '=').T("number", scanner.Int).T(";", ';').End()
+	g, err := b.Grammar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ga := lr.Analysis(g)
+	semicolon := func(pos uint64, expected []*lr.Symbol, token gorgo.Token) gorgo.Token {
+		for _, a := range expected {
+			if a.Value == ';' { // virtual semicolon
+				start := token.Span().Start()
+				return scanner.MakeDefaultToken(';', ";", gorgo.Span{start, start})
+			}
+		}
+		return nil
+	}
+	input := "a = 1 b = 2; c = 3"
+	parser := NewParser(ga, GenerateTree(true), RubySlippers(semicolon))
+	tracer().SetTraceLevel(tracing.LevelInfo)
+	accept, err := parser.Parse(scanner.GoTokenizer("slippers", strings.NewReader(input)), nil)
+	if err != nil || !accept {
+		t.Fatalf("Expected input to be accepted with virtual semicolons, have %v", err)
+	}
+	for pos, fabricated := range map[uint64]bool{3: true, 7: false, 11: true} {
+		if token := parser.TokenAt(pos); token.Lexeme() != ";" || IsFabricated(token) != fabricated {
+			t.Errorf("Expected token at %d to be ';' with fabricated=%v, is %v", pos, fabricated, token)
+		}
+	}
+	count := 0
+	root := parser.ParseForest().Root()
+	c := parser.ParseForest().SetCursor(root, nil)
+	var visit func(node *sppf.RuleNode)
+	visit = func(node *sppf.RuleNode) {
+		if node.IsFabricated() {
+			count++
+		}
+		if child, ok := c.Down(sppf.LtoR); ok {
+			for ; ok; child, ok = c.Sibling() {
+				visit(child)
+			}
+			c.Up()
+		}
+	}
+	visit(root)
+	if count != 2 {
+		t.Errorf("Expected forest to contain 2 fabricated terminals, has %d", count)
+	}
+	parser = NewParser(ga, RubySlippers(semicolon))
+	_, err = parser.Parse(scanner.GoTokenizer("slippers", strings.NewReader("a = b")), nil)
+	if serr, ok := err.(*SyntaxError); !ok || serr.Position != 2 {
+		t.Errorf("Expected syntax error at position 2, have %v", err)
+	}
+}
+
+func TestOnlineListener(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	input := "1+2*3+(4*5)"
+	parser, scan := makeParser(t, "Online", input)
+	tracing.Select("gorgo.lr").SetTraceLevel(tracing.LevelInfo)
+	accept, err := parser.Parse(scan, NewExprListener(t))
+	if err != nil || !accept {
+		t.Fatalf("Valid input string not accepted: '%s', %v", input, err)
+	}
+	for _, x := range []struct {
+		sym   string
+		span  gorgo.Span
+		value int
+	}{
+		{"Sum", gorgo.Span{0, 11}, 27},
+		{"Sum", gorgo.Span{0, 5}, 7},
+		{"Product", gorgo.Span{2, 5}, 6},
+		{"Sum", gorgo.Span{7, 10}, 20},
+	} {
+		A := parser.ga.Grammar().SymbolByName(x.sym)
+		if v, ok := parser.Value(A, x.span); !ok || v.(int) != x.value {
+			t.Errorf("Expected value of %s %v to be %d, is %v", x.sym, x.span, x.value, v)
+		}
+	}
+	//
+	b := lr.NewGrammarBuilder("Ambiguous")
+	b.LHS("E").N("E").T("+", '+').N("E").End()
+	b.LHS("E").T("number", scanner.Int).End()
+	g, _ := b.Grammar()
+	parser = NewParser(lr.Analysis(g))
+	recorder := &ambiguityRecorder{}
+	input = "1+2+3"
+	accept, err = parser.Parse(scanner.GoTokenizer("ambiguous", strings.NewReader(input)), recorder)
+	if err != nil || !accept {
+		t.Fatalf("Valid input string not accepted: '%s', %v", input, err)
+	}
+	if len(recorder.spans) != 1 || recorder.spans[0] != (gorgo.Span{0, 5}) {
+		t.Errorf("Expected ambiguity to be reported for E (0…5), have %v", recorder.spans)
+	}
+	//
+	for _, x := range []struct {
+		nulled int    // number of As after x
+		value  string // value of S
+	}{
+		{1, "S(x A(B()))"},
+		{2, "S(x A(B()) A(B()))"},
+	} {
+		b = lr.NewGrammarBuilder("Nullable")
+		S := b.LHS("S").T("x", scanner.Ident)
+		for n := 0; n < x.nulled; n++ {
+			S = S.N("A")
+		}
+		S.End()
+		b.LHS("A").N("B").End()
+		b.LHS("B").Epsilon()
+		g, _ = b.Grammar()
+		parser = NewParser(lr.Analysis(g))
+		terms := &termListener{reduced: make(map[string]int)}
+		accept, err = parser.Parse(scanner.GoTokenizer("nullable", strings.NewReader("x")), terms)
+		if err != nil || !accept {
+			t.Fatalf("Valid input string not accepted: 'x', %v", err)
+		}
+		if v, _ := parser.Value(g.SymbolByName("S"), gorgo.Span{0, 1}); v != x.value {
+			t.Errorf("Expected value of S (0…1) to be %s, is %v", x.value, v)
+		}
+		if terms.reduced["B"] != 1 || terms.reduced["A"] != 1 {
+			t.Errorf("Expected A and B to be reduced once, have %v", terms.reduced)
+		}
+	}
+}
+
+// termListener is a listener building terms from symbol names and lexemes
+type termListener struct {
+	reduced map[string]int
+}
+
+func (tl *termListener) Reduce(lhs *lr.Symbol, rule int, children []*RuleNode, extent gorgo.Span,
+	level int) interface{} {
+	tl.reduced[lhs.Name]++
+	args := make([]string, len(children))
+	for i, child := range children {
+		args[i] = fmt.Sprint(child.Value)
+	}
+	return lhs.Name + "(" + strings.Join(args, " ") + ")"
+}
+
+func (tl *termListener) Terminal(token gorgo.Token, level int) interface{} {
+	return token.Lexeme()
+}
+
+// ambiguityRecorder is a listener recording ambiguous completions
+type ambiguityRecorder struct {
+	spans []gorgo.Span
+}
+
+func (ar *ambiguityRecorder) Reduce(lhs *lr.Symbol, rule int, children []*RuleNode, extent gorgo.Span,
+	level int) interface{} {
+	return nil
+}
+
+func (ar *ambiguityRecorder) Terminal(token gorgo.Token, level int) interface{} {
+	return nil
+}
+
+func (ar *ambiguityRecorder) Ambiguity(sym *lr.Symbol, span gorgo.Span, rules []int) {
+	ar.spans = append(ar.spans, span)
+}
+
+func TestParseAs(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	parser, scan := makeParser(t, "ParseAs", "2*(3+4)")
+	Product := parser.ga.Grammar().SymbolByName("Product")
+	tracing.Select("gorgo.lr").SetTraceLevel(tracing.LevelInfo)
+	accept, err := parser.ParseAs(Product, scan, nil)
+	if err != nil || !accept {
+		t.Fatalf("Expected '2*(3+4)' to be accepted as a Product, have %v", err)
+	}
+	v := parser.WalkDerivation(NewExprListener(t))
+	if value, ok := v.Value.(int); !ok || value != 14 {
+		t.Errorf("Expected 2*(3+4) to be 14, is %v", v.Value)
+	}
+	parser, scan = makeParser(t, "ParseAs", "1+2")
+	if accept, _ = parser.ParseAs(Product, scan, nil); accept {
+		t.Errorf("Expected '1+2' not to be accepted as a Product")
+	}
+	accept, err = parser.Parse(scanner.GoTokenizer("sum", strings.NewReader("1+2")), nil)
+	if err != nil || !accept {
+		t.Errorf("Expected '1+2' to be accepted as a Sum after ParseAs, have %v", err)
+	}
+	Factor := parser.ga.Grammar().SymbolByName("Factor")
+	parser = NewParser(parser.ga, StartSymbol(Factor), GenerateTree(true))
+	accept, err = parser.Parse(scanner.GoTokenizer("factor", strings.NewReader("(1)")), nil)
+	if err != nil || !accept || parser.ParseForest() == nil {
+		t.Errorf("Expected '(1)' to be accepted as a Factor, have %v", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected option StartSymbol to panic for a terminal")
+		}
+	}()
+	NewParser(parser.ga, StartSymbol(parser.ga.Grammar().Terminal('+')))
+}
+
+func TestLookaheadPrediction(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	parser, _ := makeParser(t, "Lookahead", "")
+	ga := parser.ga
+	tracer().SetTraceLevel(tracing.LevelInfo)
+	for _, input := range inputStrings {
+		var stats [2]Stats
+		for k, filter := range []bool{false, true} {
+			parser = NewParser(ga, LookaheadPrediction(filter), GenerateTree(true))
+			scan := scanner.GoTokenizer("lookahead", strings.NewReader(input))
+			if accept, err := parser.Parse(scan, nil); !accept || err != nil {
+				t.Fatalf("input %q not accepted with lookahead=%v: %v", input, filter, err)
+			}
+			if parser.ParseForest() == nil {
+				t.Errorf("no parse forest for %q with lookahead=%v", input, filter)
+			}
+			stats[k] = parser.Stats()
+		}
+		t.Logf("%-10s items %3d → %3d, %d predictions filtered", input,
+			stats[0].Items, stats[1].Items, stats[1].Filtered)
+		if stats[1].Items >= stats[0].Items || stats[1].Filtered == 0 {
+			t.Errorf("expected lookahead to reduce chart for %q, have %v and %v", input, stats[0], stats[1])
+		}
+	}
+	parser = NewParser(ga, LookaheadPrediction(true))
+	_, err := parser.Parse(scanner.GoTokenizer("lookahead", strings.NewReader("1+*2")), nil)
+	if serr, ok := err.(*SyntaxError); !ok || len(serr.Expected) != 2 {
+		t.Errorf("expected syntax error with '(' and number expected, have %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	parser, _ := makeParser(t, "Limits", "")
+	ga := parser.ga
+	tracing.Select("gorgo.lr").SetTraceLevel(tracing.LevelInfo)
+	for _, test := range []struct {
+		opt   Option
+		limit lr.Limit
+	}{
+		{MaxItemsPerSet(5), lr.ItemsPerSet},
+		{MaxItems(30), lr.TotalItems},
+		{MaxForestNodes(5), lr.ForestNodes},
+	} {
+		parser = NewParser(ga, GenerateTree(true), test.opt)
+		scan := scanner.GoTokenizer("limits", strings.NewReader("1+2*(3+4)"))
+		accept, err := parser.Parse(scan, nil)
+		lerr, ok := err.(*lr.LimitError)
+		if accept || !ok || lerr.Limit != test.limit {
+			t.Errorf("expected parse to exceed limit of %s, have %v", test.limit, err)
+		}
+	}
+	parser = NewParser(ga, MaxItemsPerSet(50), MaxItems(500), MaxForestNodes(100))
+	scan := scanner.GoTokenizer("limits", strings.NewReader("1+2*(3+4)"))
+	if accept, err := parser.Parse(scan, nil); !accept || err != nil {
+		t.Errorf("expected input to be accepted within limits, have %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	parser = NewParser(ga)
+	scan = scanner.GoTokenizer("limits", strings.NewReader("1+2"))
+	if accept, err := parser.ParseContext(ctx, scan, nil); accept || err != context.Canceled {
+		t.Errorf("expected parse to be cancelled, have %v", err)
+	}
+}
+
 func TestTree1(t *testing.T) {
 	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
 	defer teardown()
@@ -133,6 +737,132 @@ func TestSPPF1(t *testing.T) {
 	}
 }
 
+func TestTerminalPositions(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	input := "12 + 3*45"
+	parser := NewParser(makeGrammar(t), GenerateTree(true), StoreTokens(true))
+	accept, err := parser.Parse(scanner.GoTokenizer("positions", strings.NewReader(input)), nil)
+	if err != nil || !accept {
+		t.Fatalf("Valid input string not accepted: '%s'", input)
+	}
+	tree, err := parser.ParseForest().Materialize(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lexemes []string
+	for _, n := range tree.Leaves() {
+		tok := parser.TokenAt(n.Span.Start())
+		if tok == nil || int(tok.TokType()) != n.Symbol.Value {
+			t.Fatalf("Expected token for %v to be found at its start position, have %v", n, tok)
+		}
+		lexemes = append(lexemes, tok.Lexeme())
+	}
+	if l := strings.Join(lexemes, " "); l != "12 + 3 * 45 " { // #eof has an empty lexeme
+		t.Errorf("Expected tokens of terminals to be '12 + 3 * 45 ', have '%s'", l)
+	}
+	checkWrappedBuilder(t, parser)
+}
+
+func TestSPPFCycle(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("Cycle")
+	b.LHS("S").N("S").End()
+	b.LHS("S").T("a", scanner.Ident).End()
+	G, _ := b.Grammar()
+	parser := NewParser(lr.Analysis(G), GenerateTree(true))
+	accept, err := parser.Parse(scanner.GoTokenizer("cycle", strings.NewReader("a")), nil)
+	if err != nil || !accept {
+		t.Fatalf("Valid input string not accepted: 'a'")
+	}
+	data := parser.ParseForest().Export()
+	S := -1
+	for _, sd := range data.Symbols {
+		if sd.Name == "S" {
+			S = sd.ID
+		}
+	}
+	alts, cycle := 0, false
+	for _, or := range data.Or {
+		if or.From != S {
+			continue
+		}
+		alts++
+		for _, and := range data.And {
+			cycle = cycle || and.From == or.To && and.To == S
+		}
+	}
+	if alts != 2 || !cycle {
+		t.Errorf("Expected S to have 2 derivations, one of them S ➞ S, have %d, cycle=%v", alts, cycle)
+	}
+}
+
+func TestCSTRoundTrip(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("CST")
+	b.LHS("E").N("E").T("+", '+').N("E").End()
+	b.LHS("E").T("a", scanner.Ident).End()
+	G, _ := b.Grammar()
+	input := "// head\na  +\ta // tail\n\n  /* x */ + a  \n"
+	parser := NewParser(lr.Analysis(G), GenerateTree(true), StoreTokens(true))
+	accept, err := parser.Parse(scanner.GoTokenizer("CST", strings.NewReader(input)), nil)
+	if err != nil || !accept {
+		t.Fatalf("Valid input string not accepted: '%s'", input)
+	}
+	cst, err := sppf.NewCST(parser.ParseForest(), sppf.LeftAssociative(1), parser.TokenAt, []byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cst.String() != input {
+		t.Errorf("Expected CST to print as %q, is %q", input, cst.String())
+	}
+	leaves := cst.Leaves()
+	if len(leaves) != 6 { // a + a + a #eof
+		t.Fatalf("Expected CST to have 6 tokens, has %d", len(leaves))
+	}
+	if string(leaves[0].Leading) != "// head\n" || string(leaves[2].Trailing) != " // tail\n" ||
+		string(leaves[3].Leading) != "\n  /* x */ " {
+		t.Errorf("Trivia not attached as expected: %q, %q, %q",
+			leaves[0].Leading, leaves[2].Trailing, leaves[3].Leading)
+	}
+	E := cst.Tree.Root.Children[0]
+	if text := string(cst.Text(E.Children[0])); text != "a  +\ta" {
+		t.Errorf("Expected left operand to read %q, is %q", "a  +\ta", text)
+	}
+	// repaired input: the inserted '*' has no source text
+	input = "/* product */ (1 +\t2) // open\n(3)\n"
+	parser = NewParser(makeGrammar(t), MaxRepairCost(1), GenerateTree(true), StoreTokens(true))
+	accept, err = parser.Parse(scanner.GoTokenizer("CST", strings.NewReader(input)), nil)
+	if err != nil || !accept {
+		t.Fatalf("Expected input '%s' to be repaired, have %v", input, err)
+	}
+	cst, err = sppf.NewCST(parser.ParseForest(), nil, parser.TokenAt, []byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cst.String() != input {
+		t.Errorf("Expected repaired CST to print as %q, is %q", input, cst.String())
+	}
+	if leaves := cst.Leaves(); len(leaves) != 9 { // ( 1 + 2 ) ( 3 ) #eof
+		t.Errorf("Expected repaired CST to have 9 tokens, has %d", len(leaves))
+	}
+	fabricated := 0
+	cst.Tree.Root.Walk(func(n *sppf.Node) bool {
+		if n.Fabricated {
+			fabricated++
+		}
+		return true
+	})
+	if fabricated != 1 {
+		t.Errorf("Expected repaired CST to contain 1 fabricated '*', has %d", fabricated)
+	}
+}
+
 func TestAmbiguity1(t *testing.T) {
 	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
 	defer teardown()
@@ -178,6 +908,205 @@ func TestAmbiguity1(t *testing.T) {
 	}
 }
 
+// The parse forest has to contain every derivation of an ambiguous input. For
+//
+//     E = E '+' E | a
+//
+// the number of derivations of a+a+…+a are the Catalan numbers.
+func TestForestComplete(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("Ambiguous")
+	b.LHS("E").N("E").T("+", '+').N("E").End()
+	b.LHS("E").T("a", scanner.Ident).End()
+	g, err := b.Grammar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ga := lr.Analysis(g)
+	var trees func(node *bnode) int
+	trees = func(node *bnode) int {
+		if node == nil || node.isTerminal() {
+			return 1
+		}
+		n := 0
+		for _, fam := range node.families {
+			n += trees(fam.left) * trees(fam.right)
+		}
+		return n
+	}
+	catalan := []int{1, 1, 2, 5, 14, 42}
+	for n := 1; n < len(catalan); n++ {
+		input := "a" + strings.Repeat("+a", n)
+		parser := NewParser(ga, GenerateTree(true))
+		sc := scanner.GoTokenizer("expr", strings.NewReader(input))
+		if accept, err := parser.Parse(sc, nil); err != nil || !accept {
+			t.Fatalf("Valid input string not accepted: '%s'", input)
+		}
+		if parser.ParseForest() == nil {
+			t.Fatalf("Expected parse forest for '%s'", input)
+		}
+		if c := trees(parser.rootNode()); c != catalan[n] {
+			t.Errorf("Expected %d derivations for '%s', have %d", catalan[n], input, c)
+		}
+		forest := parser.ParseForest()
+		if c := forest.CountTrees(); c == nil || c.Int64() != int64(catalan[n]) {
+			t.Errorf("Expected forest for '%s' to contain %d trees, has %v", input, catalan[n], c)
+		}
+		if n > 1 && len(forest.Ambiguities()) == 0 {
+			t.Errorf("Expected forest for '%s' to be ambiguous", input)
+		}
+		c := 0
+		for trees := forest.Trees(); trees.Next(); c++ {
+			if trees.Tree() == nil {
+				t.Errorf("Expected tree #%d", c)
+			}
+		}
+		if c != catalan[n] {
+			t.Errorf("Expected to enumerate %d trees for '%s', have %d", catalan[n], input, c)
+		}
+		if v := parser.WalkDerivation(NewTreeBuilder(g)); v == nil || v.Extent != (gorgo.Span{0, uint64(2*n + 2)}) {
+			t.Errorf("Expected derivation for '%s' to span the input, have %v", input, v)
+		}
+	}
+}
+
+// Right recursive lists should produce a linear number of items with Leo's
+// optimization, and still produce a correct derivation.
+//
+//     L = a L | a
+//
+func TestLeoRightRecursion(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	ga := makeListGrammar(t)
+	input := strings.Repeat("a ", 20)
+	itemCount := func(p *Parser) (n int) {
+		for _, S := range p.states {
+			n += S.Size()
+		}
+		return
+	}
+	sizes := make([]int, 2)
+	for i, leo := range []bool{false, true} {
+		parser := NewParser(ga, OptimizeRightRecursion(leo))
+		sc := scanner.GoTokenizer("list", strings.NewReader(input))
+		accept, err := parser.Parse(sc, nil)
+		if err != nil || !accept {
+			t.Fatalf("Valid input string not accepted, leo=%v", leo)
+		}
+		sizes[i] = itemCount(parser)
+		v := parser.WalkDerivation(listLength{})
+		if v == nil || v.Value != 20 || v.Extent != (gorgo.Span{0, 21}) { // incl. #eof
+			t.Errorf("Expected list of length 20 for leo=%v, have %v", leo, v)
+		}
+	}
+	t.Logf("# of items without/with Leo's optimization: %d / %d", sizes[0], sizes[1])
+	if sizes[1] >= sizes[0]/2 {
+		t.Errorf("Expected Leo's optimization to reduce the number of items considerably")
+	}
+	parser := NewParser(ga) // online parses must not switch off the optimization for later parses
+	for _, listener := range []Listener{listLength{}, nil} {
+		sc := scanner.GoTokenizer("list", strings.NewReader(input))
+		if accept, err := parser.Parse(sc, listener); err != nil || !accept {
+			t.Fatalf("Valid input string not accepted, listener=%v", listener)
+		}
+	}
+	if n := itemCount(parser); n != sizes[1] {
+		t.Errorf("Expected %d items for a parse after an online parse, have %d", sizes[1], n)
+	}
+}
+
+func BenchmarkRightRecursion(b *testing.B) {
+	tracer().SetTraceLevel(tracing.LevelError)
+	ga := makeListGrammar(b)
+	for _, n := range []int{100, 300} {
+		input := strings.Repeat("a ", n)
+		for _, leo := range []bool{false, true} {
+			b.Run(fmt.Sprintf("n=%d/leo=%v", n, leo), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					parser := NewParser(ga, OptimizeRightRecursion(leo))
+					sc := scanner.GoTokenizer("list", strings.NewReader(input))
+					if accept, _ := parser.Parse(sc, nil); !accept {
+						b.Fatal("input not accepted")
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkLongInput(b *testing.B) {
+	tracer().SetTraceLevel(tracing.LevelError)
+	ga := makeGrammar(b)
+	for _, n := range []int{100, 1000} {
+		input := "1" + strings.Repeat("+(2*3)", n)
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				parser := NewParser(ga)
+				sc := scanner.GoTokenizer("expr", strings.NewReader(input))
+				if accept, _ := parser.Parse(sc, nil); !accept {
+					b.Fatal("input not accepted")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAmbiguous(b *testing.B) {
+	tracer().SetTraceLevel(tracing.LevelError)
+	g := lr.NewGrammarBuilder("Ambiguous")
+	g.LHS("E").N("E").T("+", '+').N("E").End()
+	g.LHS("E").T("a", scanner.Ident).End()
+	G, err := g.Grammar()
+	if err != nil {
+		b.Fatal(err)
+	}
+	ga := lr.Analysis(G)
+	for _, n := range []int{20, 60} {
+		input := "a" + strings.Repeat("+a", n)
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				parser := NewParser(ga)
+				sc := scanner.GoTokenizer("expr", strings.NewReader(input))
+				if accept, _ := parser.Parse(sc, nil); !accept {
+					b.Fatal("input not accepted")
+				}
+			}
+		})
+	}
+}
+
+func makeListGrammar(t testing.TB) *lr.LRAnalysis {
+	b := lr.NewGrammarBuilder("List")
+	b.LHS("L").T("a", scanner.Ident).N("L").End()
+	b.LHS("L").T("a", scanner.Ident).End()
+	g, err := b.Grammar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lr.Analysis(g)
+}
+
+// listLength is a listener which calculates the length of a list.
+type listLength struct{}
+
+func (listLength) Reduce(lhs *lr.Symbol, rule int, children []*RuleNode, extent gorgo.Span,
+	level int) interface{} {
+	if lhs.Name == "L" && len(children) > 1 {
+		return children[1].Value.(int) + 1
+	} else if lhs.Name == "L" {
+		return 1
+	}
+	return children[0].Value
+}
+
+func (listLength) Terminal(token gorgo.Token, level int) interface{} {
+	return nil
+}
+
 // --- Expression Listener for testing ---------------------------------------
 
 type reducer func(*lr.Symbol, int, []*RuleNode, int) interface{}
@@ -0,0 +1,375 @@
+package earley
+
+import (
+	"sort"
+
+	"github.com/npillmayer/gorgo"
+	"github.com/npillmayer/gorgo/lr"
+	"github.com/npillmayer/gorgo/lr/sppf"
+)
+
+/*
+Building the parse forest during recognition.
+
+We follow Elizabeth Scott: "SPPF-Style Parsing From Earley Recognisers"
+(https://www.sciencedirect.com/science/article/pii/S1571066108001497).
+Whenever the recognizer advances an item [A→αX•β, j] into set Si, it creates
+(or finds) a node for the item and adds a family of children to it: the node
+for the item before advancing and the node for X. Nodes are labeled with
+a symbol or an item and with the span (j…i) they cover, and are shared by every
+derivation with the same label. The resulting forest is binarised: every family
+has at most two children. It holds every derivation of the input, for ambiguous
+grammars as well, in space O(n³).
+
+There are three kinds of nodes:
+
+  - symbol nodes (A, j, i) for non-terminals A and terminals a,
+  - intermediate nodes (A→αX•β, j, i) for items with |αX| ≥ 2 and β ≠ ε,
+  - families, which we do not represent as nodes of their own. In Scott's paper
+    they are called packed nodes.
+
+Items [A→X•β, j] do not get an intermediate node of their own, but are
+represented by the node for X.
+
+With Leo's optimization, completions abbreviated by transitive items do not
+create nodes. These are added when the forest is requested (see expandLeoItems).
+Clients walking the derivation or asking for the parse forest will have to
+pay for this; pure recognition stays linear for right recursion.
+*/
+
+// bnode is a node of the binarised parse forest.
+type bnode struct {
+	sym      *lr.Symbol  // symbol of a symbol node, nil for intermediate nodes
+	item     lr.Item     // item of an intermediate node
+	span     gorgo.Span  // span (j…i) of input covered by this node
+	token    gorgo.Token // token of a terminal node
+	families []family    // derivations of this node
+}
+
+// family is a derivation of a node (called packed node by Scott).
+// For an item [A→αX•β], left is the node for α and right is the node for X.
+// left is nil if α is empty, and right is nil for ε-rules.
+type family struct {
+	rule  int
+	left  *bnode
+	right *bnode
+}
+
+func (node *bnode) isTerminal() bool {
+	return node.sym != nil && node.sym.IsTerminal()
+}
+
+// pivot returns the position where the right child of a family starts.
+func (fam family) pivot() uint64 {
+	if fam.right == nil {
+		return 0
+	}
+	return fam.right.span.Start()
+}
+
+// symKey identifies a symbol node.
+type symKey struct {
+	sym        int
+	start, end uint64
+}
+
+// interKey identifies an intermediate node [A→α•β, j] in set Si.
+type interKey struct {
+	item itemID
+	end  uint64
+}
+
+// forest is a binarised shared packed parse forest, as built by the recognizer.
+type forest struct {
+	chart   *chart
+	symbols map[symKey]*bnode
+	inter   map[interKey]*bnode
+	count   int // number of nodes
+}
+
+func newForest(c *chart) *forest {
+	return &forest{
+		chart:   c,
+		symbols: make(map[symKey]*bnode),
+		inter:   make(map[interKey]*bnode),
+	}
+}
+
+// symbolNode finds or creates the node (A, j, i).
+func (f *forest) symbolNode(A *lr.Symbol, j, i uint64) *bnode {
+	key := symKey{A.Value, j, i}
+	node, ok := f.symbols[key]
+	if !ok {
+		node = &bnode{sym: A, span: gorgo.Span{j, i}}
+		f.symbols[key] = node
+		f.count++
+	}
+	return node
+}
+
+// intermediateNode finds or creates the node (A→α•β, j, i).
+func (f *forest) intermediateNode(item lr.Item, i uint64) *bnode {
+	key := interKey{f.chart.id(item), i}
+	node, ok := f.inter[key]
+	if !ok {
+		node = &bnode{item: item, span: gorgo.Span{item.Origin, i}}
+		f.inter[key] = node
+		f.count++
+	}
+	return node
+}
+
+// nodeOf returns the node for item [A→α•β, j] in Si, or nil if α is empty.
+func (f *forest) nodeOf(item lr.Item, i uint64) *bnode {
+	dot, rhs := item.Dot(), item.Rule().RHS()
+	switch {
+	case dot == 0:
+		return nil
+	case dot == len(rhs):
+		return f.symbolNode(item.Rule().LHS, item.Origin, i)
+	case dot == 1:
+		return f.symbolNode(rhs[0], item.Origin, i)
+	}
+	return f.intermediateNode(item, i)
+}
+
+// advance creates the node for item [A→αX•β, j] in Si, given the node w for α
+// and the node v for X (Scott's MAKE_NODE).
+func (f *forest) advance(item lr.Item, i uint64, w, v *bnode) {
+	dot, rhs := item.Dot(), item.Rule().RHS()
+	if dot == 1 && dot < len(rhs) { // node for [A→X•β, j] is the node for X
+		return
+	}
+	f.nodeOf(item, i).addFamily(family{rule: item.Rule().Serial, left: w, right: v})
+}
+
+// scanned creates nodes for [A→…a•…, j], after a has been scanned from a token
+// spanning (k…i).
+func (f *forest) scanned(item lr.Item, token gorgo.Token, k, i uint64) {
+	a := f.symbolNode(item.PeekSymbol(), k, i)
+	if a.token == nil {
+		a.token = token
+	}
+	f.advance(item.Advance(), i, f.nodeOf(item, k), a)
+}
+
+// completed creates nodes for [A→…B•…, k] in Si, after [B→…•, j] has been
+// completed in Si.
+func (f *forest) completed(jtem lr.Item, j uint64, child lr.Item, i uint64) {
+	f.advance(jtem.Advance(), i, f.nodeOf(jtem, j), f.nodeOf(child, i))
+}
+
+// nulled creates nodes for [A→…B•…, j] in Si, where B is nullable. The node for
+// (B, i, i) will receive its families as soon as B is completed in Si.
+func (f *forest) nulled(item lr.Item, i uint64) {
+	f.advance(item.Advance(), i, f.nodeOf(item, i), f.symbolNode(item.PeekSymbol(), i, i))
+}
+
+// epsilon adds a family for an ε-rule [B→•, i] in Si.
+func (f *forest) epsilon(item lr.Item, i uint64) {
+	f.symbolNode(item.Rule().LHS, i, i).addFamily(family{rule: item.Rule().Serial})
+}
+
+func (node *bnode) addFamily(fam family) {
+	for _, other := range node.families {
+		if other == fam {
+			return
+		}
+	}
+	node.families = append(node.families, fam)
+}
+
+// root returns the node for the accepting item, if any.
+func (p *Parser) rootNode() *bnode {
+	var root *bnode
+	p.states[p.sc].Each(func(item lr.Item) {
+		if item.PeekSymbol() == nil && item.Rule().LHS == p.start.LHS {
+			root = p.bforest.nodeOf(item, p.sc)
+		}
+	})
+	return root
+}
+
+// --- Un-binarising ---------------------------------------------------------
+
+// children returns every sequence of children nodes for a family, following
+// the chain of intermediate nodes to the left.
+func children(fam family) [][]*bnode {
+	var prefixes [][]*bnode
+	switch {
+	case fam.left == nil:
+		prefixes = [][]*bnode{nil}
+	case fam.left.sym != nil:
+		prefixes = [][]*bnode{{fam.left}}
+	default:
+		for _, lfam := range fam.left.families {
+			prefixes = append(prefixes, children(lfam)...)
+		}
+	}
+	if fam.right == nil {
+		return prefixes
+	}
+	seqs := make([][]*bnode, len(prefixes))
+	for k, prefix := range prefixes {
+		seqs[k] = append(append([]*bnode(nil), prefix...), fam.right)
+	}
+	return seqs
+}
+
+// toSPPF converts the binarised forest into an sppf.Forest, where every
+// derivation of a symbol node has a RHS-node with all the children of the rule.
+// Nodes without any complete derivation are left out, together with the
+// derivations referring to them.
+func (p *Parser) toSPPF(root *bnode) *sppf.Forest {
+	forest := sppf.NewForest()
+	symnodes := make(map[*bnode]*sppf.SymbolNode)
+	building := make(map[*bnode]bool) // symbol nodes currently under construction
+	var add func(node *bnode) *sppf.SymbolNode
+	add = func(node *bnode) *sppf.SymbolNode {
+		if sn, ok := symnodes[node]; ok {
+			return sn
+		}
+		if building[node] { // node is part of a cycle
+			return forest.AddSymbol(node.sym, node.span)
+		}
+		if node.isTerminal() {
+			var sn *sppf.SymbolNode
+			token := p.terminalToken(node)
+			if p.lexemes != nil { // scannerless or lattice parse: terminals may span positions
+				sn = forest.AddTerminalSpan(node.sym, token.Span())
+			} else { // positions are token positions, as expected by TokenAt
+				sn = forest.AddTerminal(node.sym, node.span.Start())
+			}
+			sn.Fabricated = IsFabricated(token)
+			symnodes[node] = sn
+			return sn
+		}
+		building[node] = true
+		var sn *sppf.SymbolNode
+		for _, fam := range node.families {
+			for _, seq := range children(fam) {
+				if len(seq) == 0 {
+					sn = forest.AddEpsilonReduction(node.sym, fam.rule, node.span.Start())
+					continue
+				}
+				rhs := make([]*sppf.SymbolNode, len(seq))
+				for k, child := range seq {
+					if rhs[k] = add(child); rhs[k] == nil { // child without derivation
+						rhs = nil
+						break
+					}
+				}
+				if rhs != nil {
+					sn = forest.AddReduction(node.sym, fam.rule, rhs)
+				}
+			}
+		}
+		delete(building, node)
+		symnodes[node] = sn
+		return sn
+	}
+	if sn := add(root); sn != nil {
+		forest.SetRoot(sn)
+	}
+	return forest
+}
+
+// --- Walking the derivation ------------------------------------------------
+
+// derivationWalker walks a single derivation of the binarised forest,
+// calling a listener.
+type derivationWalker struct {
+	p        *Parser
+	listener Listener
+	onPath   map[*bnode]bool // symbol nodes on the path from the root
+}
+
+// walk walks the derivation below a symbol node.
+func (w *derivationWalker) walk(node *bnode, level int) *RuleNode {
+	if node.isTerminal() {
+		token := w.p.terminalToken(node)
+		tracer().Infof("Tree node    %d: %s", node.span.Start(), node.sym)
+		return &RuleNode{sym: node.sym, Extent: node.span, Value: listenTerminal(w.listener, token, node.span, level+1)}
+	}
+	w.onPath[node] = true
+	rule, seq := w.choose(node)
+	ruleNodes := make([]*RuleNode, len(seq))
+	for k, child := range seq {
+		ruleNodes[k] = w.walk(child, level+1)
+	}
+	delete(w.onPath, node)
+	value := w.listener.Reduce(node.sym, rule, ruleNodes, node.span, level)
+	tracer().Infof("Tree node    %d|-----%s-----|%d", node.span.Start(), node.sym.Name, node.span.End())
+	return &RuleNode{sym: node.sym, Extent: node.span, Value: value}
+}
+
+// choose selects a derivation of an ambiguous symbol node. We prefer rules with
+// lower numbers, and for a rule the derivation where the rightmost child covers
+// the longest span. Derivations leading into cycles are skipped.
+func (w *derivationWalker) choose(node *bnode) (int, []*bnode) {
+	families := append([]family(nil), node.families...)
+	sort.SliceStable(families, func(x, y int) bool {
+		if families[x].rule != families[y].rule {
+			return families[x].rule < families[y].rule
+		}
+		return families[x].pivot() < families[y].pivot()
+	})
+	for _, fam := range families {
+		if seq, ok := w.sequence(fam); ok {
+			return fam.rule, seq
+		}
+	}
+	tracer().Errorf("no acyclic derivation found for %v", node.sym)
+	return families[0].rule, nil
+}
+
+// sequence collects the children of a family, choosing among the derivations
+// of intermediate nodes. It returns false if no derivation avoids cycles.
+func (w *derivationWalker) sequence(fam family) ([]*bnode, bool) {
+	if fam.right != nil && w.onPath[fam.right] {
+		return nil, false
+	}
+	var seq []*bnode
+	switch {
+	case fam.left == nil:
+	case fam.left.sym != nil:
+		if w.onPath[fam.left] {
+			return nil, false
+		}
+		seq = []*bnode{fam.left}
+	default:
+		lfams := append([]family(nil), fam.left.families...)
+		sort.SliceStable(lfams, func(x, y int) bool {
+			return lfams[x].pivot() < lfams[y].pivot()
+		})
+		ok := false
+		for _, lfam := range lfams {
+			if seq, ok = w.sequence(lfam); ok {
+				break
+			}
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	if fam.right != nil {
+		seq = append(seq, fam.right)
+	}
+	return seq, true
+}
+
+// terminalToken returns the token for a terminal node. For parses where tokens
+// may span positions, the token is remembered for TokenAt.
+func (p *Parser) terminalToken(node *bnode) gorgo.Token {
+	if node.token == nil {
+		return ersatzToken{
+			kind:   gorgo.TokType(node.sym.Value),
+			lexeme: "⟨⟩",
+			span:   node.span,
+		}
+	}
+	if p.lexemes != nil && p.hasmode(optionStoreTokens) && node.span.Len() > 0 {
+		p.tokens[node.span.Start()+1] = node.token // remember the token chosen by the derivation
+	}
+	return node.token
+}
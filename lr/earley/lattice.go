@@ -0,0 +1,160 @@
+package earley
+
+import (
+	"fmt"
+
+	"github.com/npillmayer/gorgo"
+	"github.com/npillmayer/gorgo/lr"
+	"github.com/npillmayer/gorgo/lr/scanner"
+)
+
+/*
+Token lattices.
+
+If the tokenizer is a scanner.LatticeTokenizer, it may deliver more than one
+token per input position, and tokens may overlap. The parser then scans every
+alternative an item is waiting for, leaving lexical ambiguity to be resolved
+by the grammar.
+
+Earley sets are indexed by input positions, as given by the spans of the tokens.
+A token starting at position i and ending at e advances an item of Si to the set
+of the next lattice position ≥ e. As this position is known only when the
+tokenizer gets there, such scans are pending until then.
+*/
+
+// pendingScan is a scan of a token whose lattice position has not yet been reached.
+type pendingScan struct {
+	key   scanKey     // key for the lexeme, missing the end position
+	item  lr.Item     // item before advancing
+	token gorgo.Token // token scanned
+	end   uint64      // end position of token
+}
+
+// parseLattice is the variant of Parse for tokenizers delivering a lattice of
+// alternative tokens.
+func (p *Parser) parseLattice(lat scanner.LatticeTokenizer) (accept bool, err error) {
+	lat.SetErrorHandler(func(e error) {
+		err = e
+	})
+	p.lexemes = make(map[scanKey]gorgo.Token)
+	var pending []pendingScan
+	var alternatives []gorgo.Token // tokens starting at position i
+	scan := func(item lr.Item, i uint64) {
+		a := item.PeekSymbol()
+		if a == nil || !a.IsTerminal() {
+			return
+		}
+		for _, token := range alternatives {
+			if int(token.TokType()) != a.Value {
+				continue
+			}
+			key := scanKey{rule: item.Rule(), dot: len(item.Prefix()), origin: item.Origin}
+			end := token.Span().End()
+			if token.TokType() == scanner.EOF {
+				end = i + 1 // #eof leads to a final position of its own
+			}
+			if end <= i { // empty token
+				p.flushScan(pendingScan{key: key, item: item, token: token}, i)
+				continue
+			}
+			pending = append(pending, pendingScan{key: key, item: item, token: token, end: end})
+		}
+	}
+	if alternatives = lat.NextTokens(); len(alternatives) == 0 { // treat as empty input
+		alternatives = []gorgo.Token{scanner.MakeDefaultToken(scanner.EOF, "", gorgo.Span{})}
+	}
+	i := alternatives[0].Span().Start()
+	startItem := p.startChart() // create S′→•S
+	startItem.Origin = i
+	p.ensureState(i)
+	p.states[i].Add(startItem) // Si = { [S′→•S, i] }
+	last, lastToken := i, alternatives[0]
+	for { // outer loop over lattice positions i
+		tracer().Debugf("Lattice delivered %d token(s) @ %d", len(alternatives), i)
+		if !p.states[i].Empty() {
+			last, lastToken = i, alternatives[0]
+			if lerr := p.positionLoop(i, scan); lerr != nil {
+				return false, lerr
+			}
+			if lerr := p.checkLimits(i); lerr != nil {
+				return false, lerr
+			}
+		}
+		if alternatives[0].TokType() == scanner.EOF {
+			break
+		}
+		if len(pending) == 0 { // no item has been able to scan any token
+			tracer().Infof("no pending scans after S%d, input rejected", last)
+			if err == nil {
+				err = p.syntaxError(last, lastToken)
+			}
+			return false, err
+		}
+		if alternatives = lat.NextTokens(); len(alternatives) == 0 {
+			tracer().Infof("lattice ended without EOF after S%d, input rejected", last)
+			if err == nil {
+				err = p.syntaxError(last, lastToken)
+			}
+			return false, err
+		}
+		next := alternatives[0].Span().Start()
+		if next <= i {
+			return false, fmt.Errorf("token lattice positions not increasing: %d after %d", next, i)
+		}
+		i = next
+		pending = p.flushPending(pending, i)
+	}
+	p.sc = i + 1
+	p.flushPending(pending, p.sc)
+	if p.states[p.sc].Empty() { // no item has been waiting for the end of input
+		tracer().Infof("Earley set S%d is empty, input rejected", p.sc)
+		if err == nil {
+			err = p.syntaxError(last, lastToken)
+		}
+		return false, err
+	}
+	alternatives = nil
+	if lerr := p.positionLoop(p.sc, scan); lerr != nil { // completes S′
+		return false, lerr
+	}
+	if lerr := p.checkLimits(p.sc); lerr != nil {
+		return false, lerr
+	}
+	if accept = p.checkAccept(); accept && p.hasmode(optionGenerateTree) {
+		if lerr := p.generateTree(); lerr != nil {
+			return false, lerr
+		}
+	}
+	return
+}
+
+// flushPending adds the items of pending scans to Si, if their tokens end at
+// or before position i. It returns the scans still pending.
+func (p *Parser) flushPending(pending []pendingScan, i uint64) []pendingScan {
+	p.ensureState(i)
+	k := 0
+	for _, ps := range pending {
+		if ps.end <= i {
+			p.flushScan(ps, i)
+		} else {
+			pending[k] = ps
+			k++
+		}
+	}
+	return pending[:k]
+}
+
+// flushScan adds the item of a scan to Si and records the token scanned.
+func (p *Parser) flushScan(ps pendingScan, i uint64) {
+	ps.key.end = i
+	if _, ok := p.lexemes[ps.key]; !ok {
+		p.lexemes[ps.key] = ps.token
+	}
+	tracer().Debugf("scanned %q @ %v into S%d", ps.token.Lexeme(), ps.token.Span(), i)
+	start := ps.token.Span().Start()
+	p.bforest.scanned(ps.item, ps.token, start, i)
+	if p.online != nil {
+		p.online.scanned(ps.item, start, ps.token, gorgo.Span{start, i})
+	}
+	p.states[i].Add(ps.item.Advance())
+}
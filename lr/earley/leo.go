@@ -0,0 +1,95 @@
+package earley
+
+import (
+	"github.com/npillmayer/gorgo/lr"
+)
+
+/*
+Leo's optimization for right recursion.
+
+Joop Leo showed in 1991 how to make Earley parsing run in linear time for every
+LR-regular grammar ("A general context-free parsing algorithm running in linear
+time on every LR(k) grammar without using lookahead", Theoretical Computer
+Science 82). Without his optimization, right recursive rules, e.g.
+
+    L ➞ a L | a
+
+lead to a quadratic number of items: every completion of L at position i
+triggers a cascade of completions of all the L's "waiting" in earlier sets.
+
+The remedy is to memoize the top of such a cascade. If a set Sj contains
+exactly one item with the dot before B, and this item is of the form
+[A→…•B, k] (B is the last symbol of the RHS), completing B will deterministically
+complete A in turn. The completed item at the top of this chain is called
+a transitive item (or Leo item) for B in Sj. Instead of walking the chain, the
+completer adds the transitive item to Si right away.
+
+Tree construction needs the items elided this way. We keep track of every
+completion which took a short-cut and re-insert the chains of completed items
+before walking the derivation. This keeps the recognizer linear for right
+recursion and restricts the quadratic overhead to clients requesting a tree.
+*/
+
+// leoKey identifies a transitive item for symbol B in set Sj.
+type leoKey struct {
+	set uint64
+	sym int
+}
+
+// leoShortcut remembers a completion which has been abbreviated by a transitive item.
+type leoShortcut struct {
+	item lr.Item // completed item [B→…•, j]
+	pos  uint64  // set in which item has been completed
+}
+
+// leoItem returns the transitive item for symbol B in set Sj, if any.
+// Transitive items are memoized per set and symbol. Set Sj must be complete,
+// i.e., j has to be less than the current set number.
+func (p *Parser) leoItem(j uint64, B *lr.Symbol) (lr.Item, bool) {
+	key := leoKey{set: j, sym: B.Value}
+	if t, ok := p.leoItems[key]; ok {
+		return t, t != lr.NullItem
+	}
+	p.leoItems[key] = lr.NullItem        // guard against cycles of unit rules
+	waiting := p.states[j].waitingFor(B) // find the unique item [A→…•B, k]
+	if len(waiting) != 1 {
+		return lr.NullItem, false
+	}
+	parent := p.chart.item(waiting[0])
+	t := parent.Advance()
+	if t.PeekSymbol() != nil { // B is not the last symbol of the RHS
+		return lr.NullItem, false
+	}
+	if top, ok := p.leoItem(parent.Origin, parent.Rule().LHS); ok {
+		t = top // A will be completed by a transitive item in turn
+	}
+	p.leoItems[key] = t
+	tracer().Debugf("transitive item for %v in S%d: %v", B, j, t)
+	return t, true
+}
+
+// expandLeoItems re-inserts the completed items which have been skipped by
+// transitive items. It is called before walking the derivation and does
+// nothing if the optimization has not been used or if items have already
+// been expanded.
+func (p *Parser) expandLeoItems() {
+	if len(p.leoShortcuts) == 0 {
+		return
+	}
+	tracer().Debugf("expanding %d transitive completions", len(p.leoShortcuts))
+	for _, sc := range p.leoShortcuts {
+		S := p.states[sc.pos]
+		worklist := []lr.Item{sc.item}
+		for len(worklist) > 0 {
+			item := worklist[len(worklist)-1]
+			worklist = worklist[:len(worklist)-1]
+			p.completeItem(item, sc.pos, func(jadv lr.Item) {
+				if jadv.PeekSymbol() == nil && !S.Contains(jadv) {
+					worklist = append(worklist, jadv)
+				}
+				S.Add(jadv)
+			})
+		}
+	}
+	p.leoShortcuts = p.leoShortcuts[:0]
+}
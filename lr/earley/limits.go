@@ -0,0 +1,105 @@
+package earley
+
+import (
+	"github.com/npillmayer/gorgo/lr"
+)
+
+/*
+Resource limits.
+
+For highly ambiguous grammars, the number of Earley items per set may grow
+linearly with the input, resulting in cubic time and quadratic space for a
+parse. The parse forest, built during recognition, may grow to cubic size.
+Applications parsing untrusted input may wish to protect themselves against this
+by limiting the resources a parse may consume. Limits are checked for every item
+the parser processes, including the items of a repair (see MaxRepairCost).
+*/
+
+// limits holds the resource limits of a parser. Zero values mean "no limit".
+type limits struct {
+	itemsPerSet int // maximum number of items per Earley set
+	items       int // maximum number of items in total
+	forestNodes int // maximum number of nodes of a parse forest
+}
+
+// MaxItemsPerSet configures the parser to abort a parse if an Earley set grows
+// beyond n items. n ≤ 0 means no limit (default).
+func MaxItemsPerSet(n int) Option {
+	return func(p *Parser) {
+		p.limits.itemsPerSet = n
+	}
+}
+
+// MaxItems configures the parser to abort a parse if the Earley sets contain
+// more than n items in total. n ≤ 0 means no limit (default).
+func MaxItems(n int) Option {
+	return func(p *Parser) {
+		p.limits.items = n
+	}
+}
+
+// MaxForestNodes configures the parser to abort a parse if the parse forest
+// grows beyond n nodes. Nodes are counted in the binarised forest the parser
+// builds during recognition. n ≤ 0 means no limit (default).
+func MaxForestNodes(n int) Option {
+	return func(p *Parser) {
+		p.limits.forestNodes = n
+	}
+}
+
+// checkLimits is called after set Si has been completed. It returns an error if
+// the context of the parse is done or if a limit has been exceeded. Otherwise
+// the items of Si are added to the total count of items.
+func (p *Parser) checkLimits(i uint64) error {
+	size := p.states[i].Size()
+	if err := p.checkSet(i, size); err != nil {
+		return err
+	}
+	p.itemCount += size
+	return nil
+}
+
+// checkSet is called while set Si is being processed, with size being the number
+// of items of Si so far. It returns an error if the context of the parse is done
+// or if a limit has been exceeded.
+func (p *Parser) checkSet(i uint64, size int) error {
+	if err := p.ctx.Err(); err != nil {
+		tracer().Infof("parse aborted @ %d: %v", i, err)
+		return err
+	}
+	if p.limits.itemsPerSet > 0 && size > p.limits.itemsPerSet {
+		return p.limitError(lr.ItemsPerSet, p.limits.itemsPerSet, i)
+	}
+	if p.limits.items > 0 && p.itemCount+size > p.limits.items {
+		return p.limitError(lr.TotalItems, p.limits.items, i)
+	}
+	return p.checkForest(i)
+}
+
+// checkForest returns an error if the parse forest has grown beyond the limit
+// for forest nodes.
+func (p *Parser) checkForest(i uint64) error {
+	if p.limits.forestNodes > 0 && p.bforest.count > p.limits.forestNodes {
+		return p.limitError(lr.ForestNodes, p.limits.forestNodes, i)
+	}
+	return nil
+}
+
+func (p *Parser) limitError(limit lr.Limit, max int, i uint64) error {
+	err := &lr.LimitError{Limit: limit, Max: max, Position: i}
+	tracer().Infof(err.Error())
+	return err
+}
+
+// generateTree builds the parse forest after a successful parse. Errors other
+// than exceeding the limit for forest nodes leave the forest empty, but are not
+// reported to the client.
+func (p *Parser) generateTree() error {
+	if err := p.buildTree(); err != nil {
+		if _, ok := err.(*lr.LimitError); ok {
+			return err
+		}
+		tracer().Errorf(err.Error())
+	}
+	return nil
+}
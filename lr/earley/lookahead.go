@@ -0,0 +1,112 @@
+package earley
+
+import (
+	"github.com/npillmayer/gorgo/lr"
+)
+
+/*
+Lookahead for the predictor.
+
+The predictor adds an item [B→•α, i] to Si for every rule of B, whatever the
+next input token is. Most of these items are dead on arrival: if FIRST(α) does
+not contain the next token and α cannot derive ε, the item will never be
+advanced. With lookahead filtering enabled, the predictor skips these rules.
+This cuts down the size of the chart considerably for grammars with many
+alternatives per non-terminal.
+
+Filtering is applied to token-based parses only. For scannerless parses and for
+token lattices, the next token is not known in advance. If the input is
+rejected, the last set is predicted again without filtering, so that syntax
+errors (and ruby-slippers hooks) report every terminal the grammar would accept.
+*/
+
+// anyToken denotes an unknown lookahead, disabling filtering.
+const anyToken = 0
+
+// Stats holds statistics about the last parse run. They are helpful for
+// measuring the effect of options like LookaheadPrediction.
+type Stats struct {
+	Sets      int // number of Earley sets
+	Items     int // number of items in all sets
+	Predicted int // number of items added by the predictor
+	Filtered  int // number of predictions skipped by lookahead filtering
+}
+
+// Stats returns statistics about the last parse run.
+func (p *Parser) Stats() Stats {
+	st := p.stats
+	st.Sets, st.Items = 0, 0
+	for _, S := range p.states {
+		if S != nil && !S.Empty() {
+			st.Sets++
+			st.Items += S.Size()
+		}
+	}
+	return st
+}
+
+// LookaheadPrediction configures the parser to skip predictions which cannot
+// start with the next input token. Defaults to false.
+func LookaheadPrediction(b bool) Option {
+	return func(p *Parser) {
+		if b {
+			p.mode |= optionLookahead
+		} else {
+			p.mode &^= optionLookahead
+		}
+	}
+}
+
+// lookahead returns the lookahead for predictions in set Si, given the next
+// input symbol x, or anyToken if filtering is switched off.
+func (p *Parser) lookahead(x inputSymbol) int {
+	if p.hasmode(optionLookahead) {
+		return x.tokval
+	}
+	return anyToken
+}
+
+// lookaheadKey identifies the predictions for a non-terminal and a lookahead token.
+type lookaheadKey struct {
+	sym    int
+	tokval int
+}
+
+// predictFor returns the start items [B→•α, 0] for rules of B which are able
+// to start with token tokval, either because FIRST(α) contains tokval or
+// because α is nullable.
+func (p *Parser) predictFor(B *lr.Symbol, tokval int) []itemID {
+	all := p.chart.predict(B)
+	if tokval == anyToken {
+		return all
+	}
+	key := lookaheadKey{B.Value, tokval}
+	ids, ok := p.chart.filtered[key]
+	if !ok {
+		ids = make([]itemID, 0, len(all))
+		for _, id := range all {
+			if p.startsWith(p.chart.rules[id.rule()].RHS(), tokval) {
+				ids = append(ids, id)
+			}
+		}
+		p.chart.filtered[key] = ids
+	}
+	return ids
+}
+
+// startsWith is true if a sequence of symbols is able to derive a sentence
+// starting with token tokval, or the empty sentence.
+func (p *Parser) startsWith(syms []*lr.Symbol, tokval int) bool {
+	for _, X := range syms {
+		if X.IsTerminal() {
+			return X.Value == tokval
+		}
+		if p.ga.First(X).Has(tokval) {
+			return true
+		}
+		if !p.ga.DerivesEpsilon(X) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,269 @@
+package earley
+
+import (
+	"github.com/npillmayer/gorgo"
+	"github.com/npillmayer/gorgo/lr"
+)
+
+/*
+Online semantic actions.
+
+If Parse is called with a listener, the listener is called as soon as items
+complete, not just when walking the derivation after the parse. This enables
+streaming clients to act before the whole input has been read.
+
+Every item remembers the children nodes of the first derivation found for it.
+If the item completes, the listener's Reduce is called with these children, and
+the resulting value is attached to the completed item. Items advanced over the
+completed item then carry the value along. Terminals are passed to the
+listener's Terminal once per token and position.
+
+Items are advanced over nullable non-terminals by the predictor, before the
+non-terminal may have been completed. Thus for every nullable non-terminal we
+fix one ε-derivation up front, preferring rules found nullable first and lower
+rule numbers. When an item is advanced over a nullable non-terminal at a
+position for the first time, the listener is called for this ε-derivation, and
+the value is used for every item advanced over it at this position.
+
+Please note that the listener will be called for every completion, including
+completions which will turn out to be dead ends. Leo's optimization is not used
+for parses with a listener, as it elides completions.
+*/
+
+// AmbiguityListener is an optional extension of Listener. During online parsing,
+// listeners implementing it are informed about ambiguous completions: sym has
+// been completed for span by more than one derivation. rules holds the rules of
+// the completions found so far; it may contain a single rule if the ambiguity
+// stems from different derivations of the same rule. Ambiguity is reported
+// at most once per symbol and span.
+type AmbiguityListener interface {
+	Ambiguity(sym *lr.Symbol, span gorgo.Span, rules []int)
+}
+
+// derivKey identifies item [A→…•…, j] in set Si.
+type derivKey struct {
+	item lr.Item
+	pos  uint64
+}
+
+// spanKey identifies completions of a non-terminal for a span of the input.
+type spanKey struct {
+	sym      int
+	from, to uint64
+}
+
+// derivation is the first derivation found for an item.
+type derivation struct {
+	children []*RuleNode // nodes for the symbols before the dot
+	pred     *derivation // derivation of the item before advancing, nil for predicted items
+	count    int         // number of different derivations found
+	node     *RuleNode   // node of a completed item, after reduction
+}
+
+// completions collects the completions of a non-terminal for a span.
+type completions struct {
+	node  *RuleNode // first completion
+	rules []int     // rules of all completions
+}
+
+// onlineParse holds the state of online semantic actions.
+type onlineParse struct {
+	listener    Listener
+	items       map[derivKey]*derivation
+	completions map[spanKey]*completions
+	terminals   map[spanKey]*RuleNode
+	reported    map[spanKey]bool
+	epsRules    map[*lr.Symbol]*lr.Rule // rule of the ε-derivation of nullable symbols
+}
+
+// startOnline prepares a parse for calling listener online, if listener is not nil.
+func (p *Parser) startOnline(listener Listener) {
+	p.online = nil
+	if listener == nil {
+		return
+	}
+	p.online = &onlineParse{
+		listener:    listener,
+		items:       make(map[derivKey]*derivation),
+		completions: make(map[spanKey]*completions),
+		terminals:   make(map[spanKey]*RuleNode),
+		reported:    make(map[spanKey]bool),
+		epsRules:    epsilonRules(p.ga.Grammar()),
+	}
+}
+
+// epsilonRules selects a rule for an ε-derivation of every nullable non-terminal.
+// Rules are selected in rounds, each round selecting rules with a RHS of symbols
+// selected in earlier rounds, which keeps ε-derivations free of cycles.
+func epsilonRules(g *lr.Grammar) map[*lr.Symbol]*lr.Rule {
+	epsRules := make(map[*lr.Symbol]*lr.Rule)
+	for changed := true; changed; {
+		changed = false
+		round := make(map[*lr.Symbol]*lr.Rule)
+		for i := 0; i < g.Size(); i++ {
+			r := g.Rule(i)
+			if epsRules[r.LHS] != nil || round[r.LHS] != nil {
+				continue
+			}
+			nulled := true
+			for _, X := range r.RHS() {
+				nulled = nulled && epsRules[X] != nil
+			}
+			if nulled {
+				round[r.LHS] = r
+			}
+		}
+		for A, r := range round {
+			epsRules[A], changed = r, true
+		}
+	}
+	return epsRules
+}
+
+// Value returns the value which the listener of the last Parse-run has attached
+// to a completion of sym for span. If sym has been completed by more than one
+// rule, the value of the first completion is returned. Values are available only
+// if a listener has been given to Parse.
+func (p *Parser) Value(sym *lr.Symbol, span gorgo.Span) (interface{}, bool) {
+	if p.online == nil {
+		return nil, false
+	}
+	if c, ok := p.online.completions[spanKey{sym.Value, span.Start(), span.End()}]; ok {
+		return c.node.Value, true
+	}
+	return nil, false
+}
+
+// scanned advances item [A→…•a…, j] of Si over a terminal a, matched by token.
+func (o *onlineParse) scanned(item lr.Item, i uint64, token gorgo.Token, span gorgo.Span) {
+	a := item.PeekSymbol()
+	key := spanKey{a.Value, span.Start(), span.End()}
+	node, ok := o.terminals[key]
+	if !ok {
+		node = &RuleNode{sym: a, Extent: span}
+		node.Value = listenTerminal(o.listener, token, span, 0)
+		o.terminals[key] = node
+	}
+	o.advance(item, i, span.End(), node)
+}
+
+// nulled advances item [A→…•B…, j] of Si over a nullable non-terminal B.
+func (o *onlineParse) nulled(item lr.Item, i uint64) {
+	o.advance(item, i, i, o.epsilon(item.PeekSymbol(), i))
+}
+
+// epsilon returns the node for the ε-derivation of a nullable non-terminal B at
+// position i, calling the listener for the derivation if it has not been called
+// yet. The derivation is recorded as for completed items [B→…•, i] of Si, thus
+// the listener is not called again if the parser completes them.
+func (o *onlineParse) epsilon(B *lr.Symbol, i uint64) *RuleNode {
+	r := o.epsRules[B]
+	item := lr.MakeItem(r, len(r.RHS()), i)
+	key := derivKey{item, i}
+	if d, ok := o.items[key]; ok && d.node != nil {
+		return d.node
+	}
+	children := make([]*RuleNode, len(r.RHS()))
+	for n, X := range r.RHS() {
+		children[n] = o.epsilon(X, i)
+	}
+	if _, ok := o.items[key]; !ok {
+		o.items[key] = &derivation{children: children, count: 1}
+	}
+	return o.reduce(item, i)
+}
+
+// advance records the derivation of [A→…X•…, j] in Sk from [A→…•X…, j] in Si,
+// given the node for X.
+func (o *onlineParse) advance(item lr.Item, i, k uint64, child *RuleNode) {
+	pred := o.items[derivKey{item, i}]
+	var children []*RuleNode
+	if pred != nil {
+		children = append(children, pred.children...)
+	}
+	children = append(children, child)
+	key := derivKey{item.Advance(), k}
+	d, ok := o.items[key]
+	if !ok {
+		o.items[key] = &derivation{children: children, pred: pred, count: 1}
+		return
+	}
+	if sameSplit(d.children, children) {
+		return
+	}
+	d.count++
+	if d.node != nil { // item has already been reduced
+		o.reportAmbiguity(key.item, k)
+	}
+}
+
+// reduce calls the listener for completed item [A→…•, j] in Si, if it has not been
+// called yet, and returns the node for A.
+func (o *onlineParse) reduce(item lr.Item, i uint64) *RuleNode {
+	key := derivKey{item, i}
+	d, ok := o.items[key]
+	if !ok { // ε-rule
+		d = &derivation{count: 1}
+		o.items[key] = d
+	}
+	if d.node != nil {
+		return d.node
+	}
+	A, rule := item.Rule().LHS, item.Rule().Serial
+	d.node = &RuleNode{sym: A, Extent: gorgo.Span{item.Origin, i}}
+	d.node.Value = o.listener.Reduce(A, rule, d.children, d.node.Extent, 0)
+	skey := spanKey{A.Value, item.Origin, i}
+	c, ok := o.completions[skey]
+	if !ok {
+		c = &completions{node: d.node}
+		o.completions[skey] = c
+	}
+	c.rules = append(c.rules, rule)
+	if len(c.rules) > 1 || d.ambiguous() {
+		o.reportAmbiguity(item, i)
+	}
+	return d.node
+}
+
+// reportAmbiguity tells the listener about an ambiguous completion, if it
+// implements AmbiguityListener.
+func (o *onlineParse) reportAmbiguity(item lr.Item, i uint64) {
+	A := item.Rule().LHS
+	skey := spanKey{A.Value, item.Origin, i}
+	if o.reported[skey] {
+		return
+	}
+	o.reported[skey] = true
+	tracer().Infof("ambiguous completion of %v for %v", A, gorgo.Span{item.Origin, i})
+	if al, ok := o.listener.(AmbiguityListener); ok {
+		var rules []int
+		if c, ok := o.completions[skey]; ok {
+			rules = c.rules
+		}
+		al.Ambiguity(A, gorgo.Span{item.Origin, i}, rules)
+	}
+}
+
+// ambiguous is true if any item along the derivation has been derived in more
+// than one way.
+func (d *derivation) ambiguous() bool {
+	for ; d != nil; d = d.pred {
+		if d.count > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// sameSplit is true if two lists of children cover the same spans.
+func sameSplit(a, b []*RuleNode) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Extent != b[i].Extent {
+			return false
+		}
+	}
+	return true
+}
@@ -1,13 +1,9 @@
 package earley
 
 import (
-	"fmt"
-
 	"github.com/npillmayer/gorgo"
 	"github.com/npillmayer/gorgo/lr"
-	"github.com/npillmayer/gorgo/lr/iteratable"
 	"github.com/npillmayer/gorgo/lr/sppf"
-	"github.com/npillmayer/schuko/gconf"
 )
 
 // TokenAt returns the input token at position pos.
@@ -20,13 +16,23 @@ func (p *Parser) TokenAt(pos uint64) gorgo.Token {
 
 // --- Derivation listener ---------------------------------------------------
 
-// Listener is a type for walking a parse tree/forest.
+// Listener is a type for walking a parse tree/forest. A Listener given to Parse
+// is called online, during the parse (see AmbiguityListener).
 type Listener interface {
 	Reduce(sym *lr.Symbol, rule int, rhs []*RuleNode, span gorgo.Span, level int) interface{}
 	//Terminal(tokenValue int, token interface{}, span gorgo.Span, level int) interface{}
 	Terminal(token gorgo.Token, level int) interface{}
 }
 
+// PositionListener is a Listener which wants to know the span of input positions
+// a terminal token covers. Walkers of the parser call TerminalAt instead of
+// Terminal for listeners implementing it. Positions are those of the Earley sets,
+// i.e. token positions as expected by TokenAt for tokenized input.
+type PositionListener interface {
+	Listener
+	TerminalAt(token gorgo.Token, span gorgo.Span, level int) interface{}
+}
+
 // RuleNode represents a node occuring during a parse tree/forest walk.
 type RuleNode struct {
 	sym    *lr.Symbol
@@ -65,19 +71,24 @@ func (t ersatzToken) Span() gorgo.Span {
 
 // --- Tree Walker -----------------------------------------------------------
 
-// WalkDerivation walks the grammar items which occured during the parse.
-// It uses a listener, which gets called for every terminal and for every
-// non-terminal reduction.
+// WalkDerivation walks a derivation of the input, as recorded by the parse
+// forest built during the parse. It uses a listener, which gets called for every
+// terminal and for every non-terminal reduction.
+//
+// For ambiguous input, a single derivation is walked. Rules with lower numbers
+// are preferred, then derivations where the rightmost symbol of a rule covers the
+// longest span of input. Clients needing every derivation should use ParseForest.
 func (p *Parser) WalkDerivation(listener Listener) *RuleNode {
 	tracer().Debugf("=== Walk ===============================")
+	if p.repaired != nil { // input has been repaired: walk the cheapest derivation
+		w := &repairWalker{input: p.input, listener: listener}
+		return w.walk(p.repaired, 0)
+	}
+	p.expandLeoItems() // tree walk needs all completed items
 	var root *RuleNode
-	S := p.states[p.sc]
-	S.IterateOnce()
-	for S.Next() {
-		item := S.Item().(lr.Item)
-		if item.PeekSymbol() == nil && item.Rule().LHS == p.ga.Grammar().Rule(0).LHS {
-			root = p.walk(item, p.sc, ruleset{}, listener, 0)
-		}
+	if node := p.rootNode(); node != nil {
+		w := &derivationWalker{p: p, listener: listener, onPath: make(map[*bnode]bool)}
+		root = w.walk(node, 0)
 	}
 	tracer().Debugf("========================================")
 	tracer().Debugf("TOKENS: %d", len(p.tokens))
@@ -87,290 +98,6 @@ func (p *Parser) WalkDerivation(listener Listener) *RuleNode {
 	return root
 }
 
-/*
-Walk backwards over the items of Earley states.
-
-A good overview of how to construct a parse forest from Earley-items may be found in
-"Parsing Techniques" by  Dick Grune and Ceriel J.H. Jacobs
-(https://dickgrune.com/Books/PTAPG_2nd_Edition/), Section 7.2.1.2.
-
-Even more practical, a great tutorial by Loup Vaillant
-(http://loup-vaillant.fr/tutorials/earley-parsing/parser)
-provides a very approachable summary of how to create a parse forest from an Earley-parse.
-As of Dec 2020, the page seems to be down, but here is a relevant excerpt:
-
------------------------------------------
-
-Imagine we have an item like this ('a', 'b', and 'c' are symbols, and 'i' is an integer):
-
-    Foo -> a b c •  (i)
-
-The fact that this item even exist means the following items also exist somewhere:
-
-    Foo ->   a   b • c  (i)
-    Foo ->   a • b   c  (i)
-    Foo -> • a   b   c  (i)
-
-We know the parse was successful: the recogniser said so, by showing us this item:
-
-    === 9 ===
-    Sum -> Sum [+-] Product • (0)
-
-There is a dot at the end, so this is a completed item. It starts at (0) (the beginning),
-and stops at (9) (the very end). There's only one way Earley's algorithm could possibly
-produce such an item: the whole input is a Sum. In our current example this means we can
-find those items:
-
-	Sum ->   Sum   [+-] • Product (0)
-	Sum ->   Sum • [+-]   Product (0)
-	Sum -> • Sum   [+-]   Product (0)
-
-But that's not the end of it. To advance an item one step, you need two things:
-an un-advanced version of the item (which we have here), and a completed something:
-either a completed state, or a successful scan. This has several implications:
-
-   * There is another completed Sum somewhere. It starts at (0), and
-     finishes at… well… let's say (x).
-   * There is a successful scan between (x) and (x+1). Meaning, the input at x matches [+-].
-   * There is a completed Product somewhere. It starts at (x+1), and finishes at… wait
-     a minute this is the last one! it's got to finish wherever the overall Sum finishes!
-     That would be the end of the input, or (9).
-
-The problem now is to search for those states, and determine the value of (x).
-Given how Earley items are stored in the state sets, we need to start at the end.
-
------------------------------------------
-
-In fact, things are a bit more complicated (as explained in Grune et al.), but
-the general drift is well explained.
-
-TODO: pull out handling of trys to use it for all r ∈ R, even if |R| = 1
-*/
-func (p *Parser) walk(item lr.Item, pos uint64, trys ruleset,
-	listener Listener, level int) *RuleNode {
-	//
-	rhs := reverse(item.Rule().RHS()) // we iterate backwards over RHS symbols of item
-	tracer().Debugf("Walk from item=%s (%d…%d)", item, item.Origin, pos)
-	extent := gorgo.Span{item.Origin, pos}
-	l := len(rhs)
-	ruleNodes := make([]*RuleNode, l) // we will collect |RHS| children nodes
-	end := pos
-	leftmost := false
-	for n, B := range rhs {
-		if n+1 == l { // this is the leftmost symbol in RHS ⇒ must match at item.Origin
-			leftmost = true
-		}
-		tracer().Debugf("Still working on item %v (%d…%d)", item, item.Origin, end)
-		tracer().Debugf("Next symbol in rev(RHS) is #%d:%s, pos=%d, end=%d", n, B, pos, end)
-		if B.IsTerminal() { // collect a terminal node
-			tracer().Infof("Tree node    %d: %s", pos-1, B)
-			//value := listener.Terminal(B.Value, p.tokens[pos], gorgo.Span{pos - 1, pos}, level+1)
-			var token gorgo.Token
-			if origToken := p.tokens[pos]; origToken == nil {
-				token = ersatzToken{
-					kind:   gorgo.TokType(B.Value),
-					lexeme: "⟨⟩",
-					span:   gorgo.Span{pos - 1, pos},
-				}
-			} else {
-				token = origToken
-			}
-			//value := listener.Terminal(B.Value, p.tokens[pos], gorgo.Span{pos - 1, pos}, level+1)
-			value := listener.Terminal(token, level+1)
-			ruleNodes[l-n-1] = &RuleNode{
-				sym:    B,
-				Extent: gorgo.Span{pos - 1, pos},
-				Value:  value,
-			}
-			pos--
-			continue
-		}
-		// for each symbol B, find an item [B→…A•, k] which has completed it
-		S := p.states[pos]
-		cleanupState(S)
-		tracer().Debugf("Looking for item which completed %s from %d", B, pos)
-		dumpState(p.states, pos)
-		tracer().Debugf("---------------------------------------------------")
-		R := S.Copy().Subset(func(el interface{}) bool {
-			jtem := el.(lr.Item)
-			return itemCompletes(jtem, B)
-		}) // now R contains all items [B→…A•, k]
-		tracer().Debugf("R=%s", itemSetString(R))
-		switch R.Size() {
-		case 0: // cannot happen
-			if stuck(fmt.Sprintf("predecessor for item missing, parse is stuck: %v", item)) {
-				panic("STUCK")
-				return nil
-			}
-		case 1: // non-ambiguous
-			child := R.First().(lr.Item)
-			if leftmost && child.Origin != item.Origin {
-				if stuck(fmt.Sprintf("leftmost symbol of RHS(%v) does not reach left side of span", child)) {
-					return nil
-				}
-			}
-			ruleNodes[l-n-1] = p.walk(child, pos, try(pos, end, trys), listener, level+1)
-			pos = child.Origin // k
-		default: // ambiguous: resolve by longest rule first, then by lower rule number
-			// debugCnt++
-			// if debugCnt > 2 {
-			// 	panic("STOP AND DEBUG")
-			// }
-			var longest lr.Item
-			var sublong lr.Item
-			var backlink lr.Item
-			if pos == end {
-				h := hash(item, pos)
-				if pred, ok := p.backlinks[h]; ok {
-					tracer().Debugf("backlink  %v  <--  %v", pred, item)
-					backlink = pred
-					if !R.Contains(pred) {
-						tracer().Errorf("Backlink not present in R ?!?")
-						panic("Backlink missing")
-					}
-				}
-			} else {
-				trys = ruleset{}
-			}
-			trys.dump()
-			R.IterateOnce()
-			for R.Next() {
-				rule := R.Item().(lr.Item) // 'rule' is a completed item [B→…A•, k]
-				//T().Debugf("longest = %v, pos = %v, end = %v", longest, pos, end)
-				//T().Debugf("   rule = %v, item = %v, origin = %v", rule, item.Origin, rule.Origin)
-				if rule == backlink {
-					longest = rule
-					tracer().Debugf("backlink rule identified: %v", rule)
-					break
-				}
-				if pos == end {
-					// avoid looping with ancestor-rule = current rule
-					if trys.contains(rule.Rule(), rule.Origin) { // we tried this rule somewhere up in the derivation walk
-						tracer().Infof("skipping rule %v, already visited", rule)
-						continue // skip this rule
-					}
-					//if r, _ := trys.containsSibling(rule.Rule(), rule.Origin); false {
-					if r, found := trys.containsSibling(rule.Rule(), rule.Origin); found {
-						// alternative derivation present
-						tracer().Infof("skipping rule %v, sibling present: %v", rule, r)
-						continue // skip this rule
-					}
-				} else {
-					h := hash(rule, pos)
-					if subpred, ok := p.backlinks[h]; ok {
-						tracer().Debugf("sub-backlink  %v  <--  %v", subpred, rule)
-						if sublong.Rule() == nil {
-							sublong = rule
-						} else if rule.Origin < sublong.Origin {
-							sublong = rule
-						} else if rule.Origin == sublong.Origin && rule.Rule().Serial < sublong.Rule().Serial {
-							sublong = rule
-						}
-					}
-				}
-				//if item.Origin <= rule.Origin && !(item.Origin == rule.Origin && pos == end) {
-				if item.Origin <= rule.Origin {
-					// Now: Resolve by longest rule first, then by lower rule number.
-					// TODO: Let clients decide via option? The default now works for many cases,
-					// but automatically prefers a right-derivation. This may not be what clients want,
-					// e.g. for left-associative operators.
-					//
-					// interval(longest) < interval(item) ?
-					// if longest.Rule() == nil || len(rule.Prefix()) > len(longest.Prefix()) {
-					// 	longest = rule
-					// } else if len(rule.Prefix()) == len(longest.Prefix()) {
-					if longest.Rule() == nil {
-						longest = rule
-						tracer().Debugf("  new longest rule: %v", rule)
-					} else if rule.Origin < longest.Origin {
-						longest = rule
-						tracer().Debugf("subst longest rule: %v", rule)
-					} else if rule.Origin == longest.Origin && rule.Rule().Serial < longest.Rule().Serial {
-						longest = rule
-						tracer().Debugf(" prio longest rule: %v", rule)
-					}
-					// }
-				}
-			}
-			if longest.Rule() == nil {
-				if stuck(fmt.Sprintf("no completed item available to satisfy %v", item)) {
-					return nil
-				}
-			}
-			if leftmost && longest.Origin != item.Origin {
-				if stuck(fmt.Sprintf("leftmost symbol of RHS(%v) does not reach left side of span", longest)) {
-					return nil
-				}
-			}
-			tracer().Debugf("Selected rule %s", longest)
-			tracer().Debugf("Sub-Long rule %v", sublong)
-			if sublong.Rule() != nil && longest != sublong {
-				tracer().Errorf("decision point")
-			}
-			newtrys := try(pos, end, trys)
-			newtrys = newtrys.add(longest.Rule(), longest.Origin) // remember we tried this rule for this span
-			ruleNodes[l-n-1] = p.walk(longest, pos, newtrys, listener, level+1)
-			pos = longest.Origin // k
-		}
-	}
-	if pos > item.Origin {
-		if stuck("did not reach start of rule derivation, parser is stuck") {
-			return nil
-		}
-	}
-	value := listener.Reduce(item.Rule().LHS, item.Rule().Serial, ruleNodes, extent, level)
-	node := &RuleNode{
-		sym:    item.Rule().LHS,
-		Extent: extent,
-		Value:  value,
-	}
-	tracer().Infof("Tree node    %d|-----%s-----|%d", extent.Start(), item.Rule().LHS.Name, extent.End())
-	return node
-}
-
-// var debugCnt int // sometimes it's helpful to restrict recursion depth during tree walk
-
-func try(pos, end uint64, trys ruleset) ruleset {
-	if pos == end {
-		tracer().Debugf("putting in trys:")
-		trys.dump()
-		return trys
-	}
-	return ruleset{}
-}
-
-// Does item complete a rule with LHS B ?
-func itemCompletes(item lr.Item, B *lr.Symbol) bool {
-	return item.PeekSymbol() == nil &&
-		item.Rule().LHS.Value == B.Value
-}
-
-// Throw away non-completing items, as they are not needed for parse tree construction.
-func cleanupState(S *iteratable.Set) {
-	S.IterateOnce()
-	for S.Next() {
-		item := S.Item().(lr.Item)
-		if item.PeekSymbol() != nil {
-			S.Remove(item)
-		}
-	}
-}
-
-func stuck(msg string) bool {
-	tracer().Errorf(msg)
-	if gconf.GetBool("panic-on-parser-stuck") {
-		panic(`Earley-parser is stuck.
-
-Configuration flag panic-on-parser-stuck is set to true. It is aimed at helping 
-to debug a parser and do a post-mortem of why it got stuck. However, if this is
-a production environment and you did not expect this to panic, please unset
-panic-on-parser-stuck to its default (false).
-
-` + msg)
-	}
-	return true
-}
-
 // --- Tree building listener -------------------------------------------
 
 // TreeBuilder is a DerivationListener which is able to create a parse tree/forest
@@ -378,8 +105,12 @@ panic-on-parser-stuck to its default (false).
 // common usage pattern is by setting the option 'GenerateTree' for a parser and
 // retrieving the parse-tree/forest with `parser.Forest()`.
 type TreeBuilder struct {
-	forest  *sppf.Forest
-	grammar *lr.Grammar
+	forest   *sppf.Forest
+	grammar  *lr.Grammar
+	next     uint64 // position behind the last terminal
+	maxNodes int    // maximum number of forest nodes, 0 for no limit
+	nodes    int    // number of forest nodes created
+	exceeded bool   // maximum number of forest nodes has been exceeded
 }
 
 // NewTreeBuilder creates a TreeBuilder given an input grammar. This should obviously
@@ -401,6 +132,9 @@ func (tb *TreeBuilder) Forest() *sppf.Forest {
 
 // Reduce is a listener method, called for Earley-completions.
 func (tb *TreeBuilder) Reduce(sym *lr.Symbol, rule int, rhs []*RuleNode, span gorgo.Span, level int) interface{} {
+	if !tb.count() {
+		return nil
+	}
 	if len(rhs) == 0 {
 		return tb.forest.AddEpsilonReduction(sym, rule, span.Start())
 	}
@@ -412,78 +146,45 @@ func (tb *TreeBuilder) Reduce(sym *lr.Symbol, rule int, rhs []*RuleNode, span go
 }
 
 // Terminal is a listener method, called when matching input tokens.
+//
+// Walkers of the parser call TerminalAt instead, which receives the position of
+// the token. Listeners forwarding to a TreeBuilder should do the same. Without a
+// position, Terminal places the token at the position behind the terminal added
+// before, as terminals of a derivation are reported from left to right.
 func (tb *TreeBuilder) Terminal(token gorgo.Token, level int) interface{} {
-	//func (tb *TreeBuilder) Terminal(tokval int, token interface{}, span gorgo.Span, level int) interface{} {
-	// TODO
-	t := tb.grammar.Terminal(int(token.TokType()))
-	//t := tb.grammar.Terminal(tokval)
-	return tb.forest.AddTerminal(t, token.Span().Start())
+	return tb.TerminalAt(token, gorgo.Span{tb.next, tb.next + 1}, level)
 }
 
-var _ Listener = &TreeBuilder{}
-
-// --- Helpers ----------------------------------------------------------
-
-// Reverse the symbols of a RHS of a rule (i.e., a handle)
-// Creates a new slice.
-func reverse(syms []*lr.Symbol) []*lr.Symbol {
-	r := append([]*lr.Symbol(nil), syms...) // make copy first
-	for i := len(syms)/2 - 1; i >= 0; i-- {
-		opp := len(syms) - 1 - i
-		r[i], r[opp] = r[opp], r[i]
+// TerminalAt is a listener method, called when matching input tokens covering a
+// span of input positions.
+func (tb *TreeBuilder) TerminalAt(token gorgo.Token, span gorgo.Span, level int) interface{} {
+	if !tb.count() {
+		return nil
 	}
-	return r
+	tb.next = span.End()
+	t := tb.grammar.Terminal(int(token.TokType()))
+	node := tb.forest.AddTerminalSpan(t, span)
+	node.Fabricated = IsFabricated(token)
+	return node
 }
 
-/*
-From http://loup-vaillant.fr/tutorials/earley-parsing/parser,
-the author states:
-
-	A completed item only stores its beginning and its rule. Its end is implicit:
-	it's the Earley set it is stored on. We can reverse that. Instead of having this:
-
-		=== 9 ===
-		Product -> Factor (2)
-
-	We could have the beginning be implicit, and store the end. Like that:
-
-		=== 2 ===
-		Product -> Factor (9)
-
-	It is basically the same thing, but now we can perform searches from the beginning.
-
-Unfortunately, there is a complication when searching from the beginning: We would
-need to check for terminals in the input against successors of non-terminals
-of the completion-sets. The reason is, that the completion sets contain dead-ends,
-i.e. completions which did not produce a valid shift on the lookahead.
-When searching from the back, we never see these dead-ends. When searching from the
-beginning, we need backtracking to identify them.
-*/
-
-// reverseStates reverses the states after a successful parse, following the idea
-// of http://loup-vaillant.fr/tutorials/earley-parsing/parser.
-// However, currently it seems not very useful.
-//
-/*
-func (p *Parser) reverseStates() []*iteratable.Set {
-	l := len(p.states)
-	reversed := make([]*iteratable.Set, l)
-	for n, S := range p.states {
-		reversed[n] = iteratable.NewSet(0)
-		R := S.Subset(func(el interface{}) bool {
-			item := el.(lr.Item)
-			return item.PeekSymbol() == nil
-		}) // now R contains only completion-items
-		R.Each(func(el interface{}) {
-			item := el.(lr.Item)
-			o := item.Origin // misuse as span.To
-			item.Origin = uint64(n)
-			reversed[o].Add(item)
-		})
+// listenTerminal calls a listener for a token covering a span of input
+// positions.
+func listenTerminal(listener Listener, token gorgo.Token, span gorgo.Span, level int) interface{} {
+	if pl, ok := listener.(PositionListener); ok {
+		return pl.TerminalAt(token, span, level)
 	}
-	for n := range reversed {
-		dumpState(reversed, uint64(n))
+	return listener.Terminal(token, level)
+}
+
+// count counts a node to be created and returns false if the maximum number of
+// forest nodes has been exceeded.
+func (tb *TreeBuilder) count() bool {
+	tb.nodes++
+	if tb.maxNodes > 0 && tb.nodes > tb.maxNodes {
+		tb.exceeded = true
 	}
-	return reversed
+	return !tb.exceeded
 }
-*/
+
+var _ PositionListener = &TreeBuilder{}
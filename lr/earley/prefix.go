@@ -0,0 +1,105 @@
+package earley
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/npillmayer/gorgo/lr"
+	"github.com/npillmayer/gorgo/lr/scanner"
+)
+
+// Prediction is the result of parsing a prefix of an input. It holds the
+// symbols predicted at the end of the chart, i.e., the symbols which may follow
+// the prefix. This is useful for driving auto-completion.
+type Prediction struct {
+	Position     uint64       // length of the prefix (token count)
+	Complete     bool         // the prefix is a complete sentence
+	Terminals    []*lr.Symbol // terminals which may follow the prefix, without #eof
+	NonTerminals []*lr.Symbol // non-terminals which may follow the prefix
+	Items        []lr.Item    // items of the last Earley set waiting for a symbol
+}
+
+// ParsePrefix parses an input which is not required to be complete. The end of
+// input just marks the position (e.g., the cursor in an editor) where we are
+// interested in the symbols which could come next, according to the grammar.
+//
+// If the input is not a valid prefix of any sentence of the language, ParsePrefix
+// returns a *SyntaxError. Otherwise the symbols predicted at the end of the
+// prefix are returned.
+func (p *Parser) ParsePrefix(scan scanner.Tokenizer) (*Prediction, error) {
+	return p.ParsePrefixContext(context.Background(), scan)
+}
+
+// ParsePrefixContext is like ParsePrefix, but aborts the parse if ctx is done,
+// returning ctx.Err(). Resource limits are handled as for ParseContext.
+func (p *Parser) ParsePrefixContext(ctx context.Context, scan scanner.Tokenizer) (pred *Prediction, err error) {
+	if p.scanner = scan; scan == nil {
+		return nil, fmt.Errorf("Earley-parser needs a valid scanner, is void")
+	}
+	p.ctx = ctx
+	p.scanner.SetErrorHandler(func(e error) {
+		err = e
+	})
+	p.forest, p.lexemes = nil, nil
+	p.repaired, p.edits = nil, nil
+	p.startOnline(nil)
+	p.states[0].Add(p.startChart()) // S0 = { [S′→•S, 0] }
+	token := p.scanner.NextToken()
+	for { // outer loop over Si per input token xi
+		tracer().Debugf("Scanner read '%v|%d' @ %v", token, token.TokType(), token.Span())
+		x := inputSymbol{int(token.TokType()), token, token.Span()}
+		i := p.setupNextState(token)
+		// last token is EOF, which must not filter predictions
+		if lerr := p.innerLoop(i, x, anyToken); lerr != nil {
+			return nil, lerr
+		}
+		if lerr := p.checkLimits(i); lerr != nil {
+			return nil, lerr
+		}
+		if x.tokval == scanner.EOF { // end of prefix
+			return p.prediction(i), err
+		}
+		if p.states[i+1].Empty() { // no item has been able to scan x
+			tracer().Infof("Earley set S%d is empty, input is not a valid prefix", i+1)
+			if serr := p.syntaxError(i, token); err == nil {
+				err = serr
+			}
+			return nil, err
+		}
+		token = p.scanner.NextToken()
+	}
+}
+
+// prediction collects the symbols after the dot for all items of set Si.
+func (p *Parser) prediction(i uint64) *Prediction {
+	pred := &Prediction{Position: i}
+	seen := map[*lr.Symbol]bool{}
+	p.states[i].Each(func(item lr.Item) {
+		A := item.PeekSymbol()
+		if A == nil {
+			return
+		}
+		pred.Items = append(pred.Items, item)
+		if seen[A] {
+			return
+		}
+		seen[A] = true
+		if A.Value == scanner.EOF {
+			pred.Complete = true
+		} else if A.IsTerminal() {
+			pred.Terminals = append(pred.Terminals, A)
+		} else {
+			pred.NonTerminals = append(pred.NonTerminals, A)
+		}
+	})
+	sortSymbols(pred.Terminals)
+	sortSymbols(pred.NonTerminals)
+	return pred
+}
+
+func sortSymbols(syms []*lr.Symbol) {
+	sort.Slice(syms, func(i, j int) bool {
+		return syms[i].Name < syms[j].Name
+	})
+}
@@ -0,0 +1,324 @@
+package earley
+
+import (
+	"fmt"
+
+	"github.com/npillmayer/gorgo"
+	"github.com/npillmayer/gorgo/lr"
+	"github.com/npillmayer/gorgo/lr/scanner"
+)
+
+/*
+Error-correcting Earley parsing.
+
+Aho and Peterson showed in 1972 how to extend Earley's algorithm to find a
+parse for an erroneous input, using a minimum number of edit operations
+("A Minimum Distance Error-Correcting Parser for Context-Free Languages",
+SIAM Journal on Computing 1(4)). The input is repaired by inserting missing
+terminals, deleting spurious input tokens, and substituting tokens by other
+terminals. Each of these operations has a cost of 1.
+
+Instead of transforming the grammar with error productions, as in the paper,
+we let the items carry costs. Sets are processed in order of ascending cost of
+their items (Dijkstra-style), thus every item is processed with its minimum
+cost. Every item remembers how it has been derived, enabling us to reconstruct
+the cheapest derivation afterwards.
+
+The repair is started only if the regular parse fails, as it is considerably
+more expensive. The search space is bounded by the maximum cost configured
+with option MaxRepairCost.
+*/
+
+// EditOp is the type of an edit operation for repairing an input.
+type EditOp int8
+
+// Edit operations for input repair.
+const (
+	Insert     EditOp = iota // insert a missing terminal
+	Delete                   // delete a spurious input token
+	Substitute               // substitute an input token by another terminal
+)
+
+func (op EditOp) String() string {
+	switch op {
+	case Insert:
+		return "insert"
+	case Delete:
+		return "delete"
+	case Substitute:
+		return "substitute"
+	}
+	return "<unknown edit op>"
+}
+
+// Edit is an operation applied to the input for repairing it.
+type Edit struct {
+	Op       EditOp
+	Position uint64      // position in the original input (token count)
+	Token    gorgo.Token // input token deleted or substituted; nil for insertions
+	Symbol   *lr.Symbol  // terminal inserted or substituted for Token; nil for deletions
+}
+
+func (e Edit) String() string {
+	switch e.Op {
+	case Insert:
+		return fmt.Sprintf("insert %s at %d", e.Symbol.Name, e.Position)
+	case Delete:
+		return fmt.Sprintf("delete %q at %d", e.Token.Lexeme(), e.Position)
+	}
+	return fmt.Sprintf("substitute %q by %s at %d", e.Token.Lexeme(), e.Symbol.Name, e.Position)
+}
+
+// Edits returns the edit operations which have been necessary to repair the
+// input of the last Parse-run. It is empty if the input has been accepted without
+// repair. Error correction is enabled with option MaxRepairCost.
+func (p *Parser) Edits() []Edit {
+	return p.edits
+}
+
+// --- Items with costs ------------------------------------------------------
+
+// repairStep denotes how an item has been derived.
+type repairStep int8
+
+const (
+	stepPredict repairStep = iota
+	stepMatch
+	stepSubstitute
+	stepInsert
+	stepDelete
+	stepComplete
+)
+
+// ritem is an Earley item carrying a cost and a link to its derivation.
+type ritem struct {
+	item  lr.Item
+	cost  int
+	step  repairStep
+	prev  *ritem // item this one has been derived from
+	child *ritem // completed item for stepComplete
+}
+
+// rset is an Earley set for error-correcting parsing.
+type rset struct {
+	items     map[lr.Item]*ritem
+	buckets   [][]*ritem              // queue of items to process, by cost
+	waiting   map[*lr.Symbol][]*ritem // processed items with dot before a non-terminal
+	completed map[*lr.Symbol][]*ritem // processed completed items with origin at this set
+	predicted map[*lr.Symbol]bool     // non-terminals already predicted
+}
+
+func newRSet(maxCost int) *rset {
+	return &rset{
+		items:     make(map[lr.Item]*ritem),
+		buckets:   make([][]*ritem, maxCost+1),
+		waiting:   make(map[*lr.Symbol][]*ritem),
+		completed: make(map[*lr.Symbol][]*ritem),
+		predicted: make(map[*lr.Symbol]bool),
+	}
+}
+
+// add puts an item into the set, if it is new or cheaper than an existing one.
+func (S *rset) add(e *ritem) {
+	if e.item == lr.NullItem || e.cost >= len(S.buckets) {
+		return
+	}
+	if existing, ok := S.items[e.item]; ok && existing.cost <= e.cost {
+		return
+	}
+	S.items[e.item] = e
+	S.buckets[e.cost] = append(S.buckets[e.cost], e)
+}
+
+// next returns the cheapest item to process, or nil.
+func (S *rset) next() *ritem {
+	for c := range S.buckets {
+		for len(S.buckets[c]) > 0 {
+			e := S.buckets[c][0]
+			S.buckets[c] = S.buckets[c][1:]
+			if S.items[e.item] == e { // otherwise superseded by a cheaper one
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// --- Repair ----------------------------------------------------------------
+
+// repair tries to find the cheapest repair for an input which has been rejected.
+// input must contain all tokens, including the final EOF token.
+// If a repair within the configured cost limit has been found, repair sets the
+// edits and the repaired token sequence for the parser and returns true.
+//
+// The items of the repair are subject to the resource limits of the parser,
+// counting towards the total number of items in addition to the items of the
+// regular parse. If a limit has been exceeded, repair returns an error.
+func (p *Parser) repair(input []gorgo.Token) (bool, error) {
+	tracer().Infof("trying to repair input of %d tokens, max. cost = %d", len(input), p.maxCost)
+	sets := make([]*rset, len(input)+1)
+	sets[0] = newRSet(p.maxCost)
+	startItem, _ := lr.StartItem(p.start) // S′→•S #eof
+	sets[0].add(&ritem{item: startItem, step: stepPredict})
+	for i, x := range input {
+		sets[i+1] = newRSet(p.maxCost)
+		if err := p.processRSet(sets, uint64(i), int(x.TokType())); err != nil {
+			return false, err
+		}
+		p.itemCount += len(sets[i].items)
+	}
+	final := sets[len(input)]
+	if err := p.checkSet(uint64(len(input)), len(final.items)); err != nil {
+		return false, err
+	}
+	accept, ok := final.items[startItem.Advance().Advance()] // S′→S #eof•
+	if !ok {
+		tracer().Infof("no repair found with cost ≤ %d", p.maxCost)
+		return false, nil
+	}
+	tracer().Infof("repaired input with cost %d", accept.cost)
+	p.repaired = accept
+	p.input = input
+	w := &repairWalker{input: input}
+	w.walk(accept, 0) // dry run to collect edits and tokens
+	p.edits = w.edits
+	p.tokens = append([]gorgo.Token{nil}, w.tokens...)
+	return true, nil
+}
+
+// processRSet processes the items of set Si in order of ascending cost,
+// given the token value of input token x(i+1).
+//
+// Predicted items start with cost 0, regardless of the cost of the item which
+// triggered the prediction. An item may therefore become cheaper after it has been
+// processed. In this case it is processed again, and the improvement propagates
+// to the items derived from it.
+//
+// Resource limits are checked for every item processed. If a limit has been
+// exceeded, processRSet stops and returns an error.
+func (p *Parser) processRSet(sets []*rset, i uint64, tokval int) error {
+	S, S1 := sets[i], sets[i+1]
+	for e := S.next(); e != nil; e = S.next() {
+		if err := p.checkSet(i, len(S.items)); err != nil {
+			return err
+		}
+		B := e.item.PeekSymbol()
+		switch {
+		case B == nil: // completer
+			A, j := e.item.Rule().LHS, e.item.Origin
+			if j == i {
+				S.completed[A] = append(S.completed[A], e)
+			}
+			for _, parent := range sets[j].waiting[A] {
+				S.add(&ritem{item: parent.item.Advance(), cost: parent.cost + e.cost,
+					step: stepComplete, prev: parent, child: e})
+			}
+		case !B.IsTerminal(): // predictor
+			S.waiting[B] = append(S.waiting[B], e)
+			if !S.predicted[B] {
+				S.predicted[B] = true
+				p.ga.Grammar().FindNonTermRules(B, true).Each(func(el interface{}) {
+					startitem := el.(lr.Item)
+					startitem.Origin = i
+					S.add(&ritem{item: startitem, step: stepPredict})
+				})
+			}
+			for _, child := range S.completed[B] { // B has already been completed at i
+				S.add(&ritem{item: e.item.Advance(), cost: e.cost + child.cost,
+					step: stepComplete, prev: e, child: child})
+			}
+		default: // scanner, including edit operations
+			if B.Value == tokval {
+				S1.add(&ritem{item: e.item.Advance(), cost: e.cost, step: stepMatch, prev: e})
+			} else if tokval != scanner.EOF && B.Value != scanner.EOF {
+				S1.add(&ritem{item: e.item.Advance(), cost: e.cost + 1, step: stepSubstitute, prev: e})
+			}
+			if B.Value != scanner.EOF {
+				S.add(&ritem{item: e.item.Advance(), cost: e.cost + 1, step: stepInsert, prev: e})
+			}
+			if tokval != scanner.EOF {
+				S1.add(&ritem{item: e.item, cost: e.cost + 1, step: stepDelete, prev: e})
+			}
+		}
+	}
+	return nil
+}
+
+// readAll reads the remaining tokens from the scanner, up to and including EOF.
+func (p *Parser) readAll(input []gorgo.Token) []gorgo.Token {
+	for len(input) == 0 || input[len(input)-1].TokType() != scanner.EOF {
+		input = append(input, p.scanner.NextToken())
+	}
+	return input
+}
+
+// --- Walking the repaired derivation ---------------------------------------
+
+// repairWalker walks the cheapest derivation found by repair. Positions
+// of RuleNodes refer to the repaired input.
+type repairWalker struct {
+	input    []gorgo.Token // original input
+	listener Listener      // may be nil for a dry run
+	opos     uint64        // position in the original input
+	rpos     uint64        // position in the repaired input
+	edits    []Edit        // collected edit operations
+	tokens   []gorgo.Token // repaired input
+}
+
+func (w *repairWalker) walk(e *ritem, level int) *RuleNode {
+	var steps []*ritem // collect derivation steps of e backwards
+	for s := e; s.step != stepPredict; s = s.prev {
+		steps = append(steps, s)
+	}
+	start := w.rpos
+	children := make([]*RuleNode, 0, len(steps))
+	for k := len(steps) - 1; k >= 0; k-- {
+		s := steps[k]
+		a := s.prev.item.PeekSymbol()
+		switch s.step {
+		case stepComplete:
+			children = append(children, w.walk(s.child, level+1))
+		case stepMatch:
+			children = append(children, w.terminal(a, w.input[w.opos], level+1))
+			w.opos++
+		case stepSubstitute:
+			orig := w.input[w.opos]
+			w.edits = append(w.edits, Edit{Op: Substitute, Position: w.opos, Token: orig, Symbol: a})
+			token := ersatzToken{kind: gorgo.TokType(a.Value), lexeme: orig.Lexeme(), span: orig.Span()}
+			children = append(children, w.terminal(a, token, level+1))
+			w.opos++
+		case stepInsert:
+			w.edits = append(w.edits, Edit{Op: Insert, Position: w.opos, Symbol: a})
+			token := fabricatedToken{ersatzToken{ // inserted tokens have no source text
+				kind:   gorgo.TokType(a.Value),
+				lexeme: "⟨⟩",
+				span:   gorgo.Span{w.rpos, w.rpos + 1},
+			}}
+			children = append(children, w.terminal(a, token, level+1))
+		case stepDelete:
+			w.edits = append(w.edits, Edit{Op: Delete, Position: w.opos, Token: w.input[w.opos]})
+			w.opos++
+		}
+	}
+	node := &RuleNode{
+		sym:    e.item.Rule().LHS,
+		Extent: gorgo.Span{start, w.rpos},
+	}
+	if w.listener != nil {
+		node.Value = w.listener.Reduce(node.sym, e.item.Rule().Serial, children, node.Extent, level)
+	}
+	return node
+}
+
+func (w *repairWalker) terminal(a *lr.Symbol, token gorgo.Token, level int) *RuleNode {
+	w.tokens = append(w.tokens, token)
+	node := &RuleNode{
+		sym:    a,
+		Extent: gorgo.Span{w.rpos, w.rpos + 1},
+	}
+	if w.listener != nil {
+		node.Value = listenTerminal(w.listener, token, node.Extent, level)
+	}
+	w.rpos++
+	return node
+}
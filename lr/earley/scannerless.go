@@ -0,0 +1,327 @@
+package earley
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/npillmayer/gorgo"
+	"github.com/npillmayer/gorgo/lr"
+	"github.com/npillmayer/gorgo/lr/scanner"
+)
+
+/*
+Scannerless parsing.
+
+Instead of reading tokens from a scanner, the parser may read runes directly.
+Terminals then describe sequences of characters:
+
+  - terminals created with lr.RuleBuilder.R match the longest prefix of the
+    remaining input described by a regular expression (or a rune class),
+  - #eof matches the end of the input,
+  - every other terminal matches its name literally, e.g. "+" or "if".
+
+Earley sets are indexed by character positions. As a terminal may span more than
+one character (or none at all), the scanner step adds items to sets further
+ahead, not just to Si+1. Sets left empty are skipped.
+
+Clients will have to take care of whitespace themselves, e.g. by a rule
+  Blank ➞ [ \t\n]*
+*/
+
+// scanKey identifies the scan of a terminal by an item [A→…•a…, j], which ended
+// at a position in the input.
+type scanKey struct {
+	rule   *lr.Rule
+	dot    int    // position of the terminal within the RHS of rule
+	origin uint64 // origin of the item
+	end    uint64 // end position of the matched lexeme
+}
+
+// runeToken is a token for a lexeme of a scannerless parse. Its span is in units
+// of characters.
+type runeToken struct {
+	kind   gorgo.TokType
+	lexeme string
+	span   gorgo.Span
+}
+
+func (t runeToken) TokType() gorgo.TokType {
+	return t.kind
+}
+
+func (t runeToken) Lexeme() string {
+	return t.lexeme
+}
+
+func (t runeToken) Value() interface{} {
+	return nil
+}
+
+func (t runeToken) Span() gorgo.Span {
+	return t.span
+}
+
+// ParseRunes starts a new scannerless parse, reading runes from r.
+// It returns true if the input has been accepted.
+//
+// Terminals match sequences of characters, see lr.RuleBuilder.R. Positions of
+// RuleNodes, tokens and parse forest nodes are in units of characters (runes).
+// TokenAt(pos) returns the lexeme at character position pos. After walking the
+// derivation, this is the lexeme chosen by the derivation.
+//
+// If the input is rejected, ParseRunes returns a *SyntaxError. Error correction
+// is not supported for scannerless parsing.
+func (p *Parser) ParseRunes(r io.RuneReader, listener Listener) (bool, error) {
+	return p.ParseRunesContext(context.Background(), r, listener)
+}
+
+// ParseRunesContext is like ParseRunes, but aborts the parse if ctx is done,
+// returning ctx.Err(). Resource limits are handled as for ParseContext.
+func (p *Parser) ParseRunesContext(ctx context.Context, r io.RuneReader, listener Listener) (accept bool, err error) {
+	if r == nil {
+		return false, fmt.Errorf("Earley-parser needs a valid rune reader, is void")
+	}
+	p.ctx = ctx
+	p.startOnline(listener)
+	p.runes = &runeInput{src: r}
+	p.lexemes = make(map[scanKey]gorgo.Token)
+	p.forest = nil
+	p.repaired, p.edits = nil, nil
+	p.states[0].Add(p.startChart()) // S0 = { [S′→•S, 0] }
+	i := uint64(0)
+	for { // outer loop over non-empty sets Si
+		if lerr := p.positionLoop(i, p.scanRunes); lerr != nil {
+			return false, lerr
+		}
+		if lerr := p.checkLimits(i); lerr != nil {
+			return false, lerr
+		}
+		if _, ok := p.runes.at(i); !ok { // end of input, #eof has been scanned into Si+1
+			break
+		}
+		j := p.nextRuneState(i)
+		if j == 0 { // no item has been able to scan any lexeme
+			tracer().Infof("no Earley set after S%d is non-empty, input rejected", i)
+			return false, p.runeError(i)
+		}
+		i = j
+	}
+	p.sc = i + 1
+	p.ensureState(p.sc)
+	if p.states[p.sc].Empty() { // no item has been waiting for the end of input
+		tracer().Infof("Earley set S%d is empty, input rejected", p.sc)
+		return false, p.runeError(i)
+	}
+	if lerr := p.positionLoop(p.sc, p.scanRunes); lerr != nil { // completes S′
+		return false, lerr
+	}
+	if lerr := p.checkLimits(p.sc); lerr != nil {
+		return false, lerr
+	}
+	if p.runes.err != nil {
+		err = p.runes.err
+	}
+	if accept = p.checkAccept(); accept && p.hasmode(optionGenerateTree) {
+		if lerr := p.generateTree(); lerr != nil {
+			return false, lerr
+		}
+	}
+	return
+}
+
+// positionLoop iterates over Si, applying Scanner, Predictor and Completer,
+// similar to innerLoop. It is used for parses where terminals may span more than
+// one position, with the scanner step provided by the caller. Resource limits
+// are checked for every item, as for innerLoop.
+func (p *Parser) positionLoop(i uint64, scan func(lr.Item, uint64)) error {
+	S := p.states[i]
+	nulled := make(map[int][]lr.Item) // items [B→…•, i] visited so far, indexed by B
+	for n := 0; n < S.Size(); n++ {   // S grows while we iterate
+		if err := p.checkSet(i, S.Size()); err != nil {
+			return err
+		}
+		item := S.Item(n)
+		scan(item, i)                        // may add items to Si or later sets
+		p.predict(S, nil, item, i, anyToken) // may add items to S
+		p.completeNulled(S, item, i, nulled) // may add items to S
+		p.complete(S, nil, item, i)          // may add items to S
+		if item.PeekSymbol() == nil && item.Origin == i {
+			B := item.Rule().LHS
+			nulled[B.Value] = append(nulled[B.Value], item)
+		}
+	}
+	dumpState(p.states, i)
+	return nil
+}
+
+// Scanner for runes:
+// If [A→…•a…, j] is in Si and a matches the lexeme xi+1…xk, add [A→…a•…, j] to Sk.
+// The lexeme may be empty, adding the advanced item to Si.
+func (p *Parser) scanRunes(item lr.Item, i uint64) {
+	a := item.PeekSymbol()
+	if a == nil || !a.IsTerminal() {
+		return
+	}
+	n, ok := p.runes.match(a, i)
+	if !ok {
+		return
+	}
+	k := i + n
+	p.ensureState(k)
+	key := scanKey{rule: item.Rule(), dot: len(item.Prefix()), origin: item.Origin, end: k}
+	if _, ok := p.lexemes[key]; !ok {
+		lexeme := runeToken{kind: gorgo.TokType(a.Value), span: gorgo.Span{i, k}}
+		if a.Value != scanner.EOF {
+			lexeme.lexeme = string(p.runes.runes[i:k])
+		}
+		p.lexemes[key] = lexeme
+		if p.hasmode(optionStoreTokens) && n > 0 && p.tokens[i+1] == nil {
+			p.tokens[i+1] = lexeme
+		}
+	}
+	tracer().Debugf("scanned %v as %q @ %v", a, p.lexemes[key].Lexeme(), gorgo.Span{i, k})
+	p.bforest.scanned(item, p.lexemes[key], i, k)
+	if p.online != nil {
+		p.online.scanned(item, i, p.lexemes[key], gorgo.Span{i, k})
+	}
+	p.states[k].Add(item.Advance())
+}
+
+// completeNulled handles [A→…•B…, j] in Si, where B has already been completed
+// at position i with origin i. This may happen for non-terminals which are not
+// nullable by the grammar, but derive an empty lexeme.
+//
+// Completed items [B→…•, i] visited before [A→…•B…, j] are taken from nulled.
+// Those visited afterwards find [A→…•B…, j] in the index of waiting items of Si,
+// as for any other completion.
+func (p *Parser) completeNulled(S *earleySet, item lr.Item, i uint64, nulled map[int][]lr.Item) {
+	B := item.PeekSymbol()
+	if B == nil || B.IsTerminal() {
+		return
+	}
+	for _, child := range nulled[B.Value] {
+		adv := item.Advance()
+		p.bforest.completed(item, i, child, i)
+		if p.online != nil {
+			p.online.advance(item, i, i, p.online.reduce(child, i))
+		}
+		S.Add(adv)
+	}
+}
+
+// ensureState makes sure that sets S0…Sk exist, as well as token slots.
+func (p *Parser) ensureState(k uint64) {
+	for uint64(len(p.states)) <= k {
+		p.states = append(p.states, p.chart.newSet())
+	}
+	for uint64(len(p.tokens)) <= k {
+		p.tokens = append(p.tokens, nil)
+	}
+}
+
+// nextRuneState returns the number of the next non-empty set after Si,
+// or 0 if there is none.
+func (p *Parser) nextRuneState(i uint64) uint64 {
+	for j := i + 1; j < uint64(len(p.states)); j++ {
+		if !p.states[j].Empty() {
+			return j
+		}
+	}
+	return 0
+}
+
+// runeError creates a syntax error for a scannerless parse, given the furthest
+// position i the parser has been able to reach.
+func (p *Parser) runeError(i uint64) error {
+	if p.runes.err != nil {
+		return p.runes.err
+	}
+	token := runeToken{kind: scanner.EOF, span: gorgo.Span{i, i + 1}}
+	if r, ok := p.runes.at(i); ok {
+		token = runeToken{kind: gorgo.TokType(r), lexeme: string(r), span: gorgo.Span{i, i + 1}}
+	}
+	return p.syntaxError(i, token)
+}
+
+// --- Rune input ------------------------------------------------------------
+
+// runeInput buffers the runes read from a rune reader. Runes are read on demand,
+// but we need random access for matching lexemes in parallel.
+type runeInput struct {
+	src   io.RuneReader
+	runes []rune
+	eof   bool
+	err   error // read error other than io.EOF
+}
+
+// at returns the rune at position i, if the input extends that far.
+func (in *runeInput) at(i uint64) (rune, bool) {
+	for !in.eof && uint64(len(in.runes)) <= i {
+		r, _, err := in.src.ReadRune()
+		if err != nil {
+			if err != io.EOF {
+				in.err = err
+			}
+			in.eof = true
+			break
+		}
+		in.runes = append(in.runes, r)
+	}
+	if i < uint64(len(in.runes)) {
+		return in.runes[i], true
+	}
+	return 0, false
+}
+
+// match returns the length (in runes) of the lexeme matching terminal a at
+// position i.
+func (in *runeInput) match(a *lr.Symbol, i uint64) (uint64, bool) {
+	if a.Value == scanner.EOF {
+		_, ok := in.at(i)
+		return 1, !ok
+	}
+	if a.Pattern != nil {
+		loc := a.Pattern.FindReaderIndex(&runeCursor{in: in, pos: i})
+		if loc == nil {
+			return 0, false
+		}
+		n, width := uint64(0), 0
+		for width < loc[1] { // convert byte length of match to number of runes
+			width += runeWidth(in.runes[i+n])
+			n++
+		}
+		return n, true
+	}
+	n := uint64(0)
+	for _, r := range a.Name {
+		if c, ok := in.at(i + n); !ok || c != r {
+			return 0, false
+		}
+		n++
+	}
+	return n, true
+}
+
+// runeCursor reads runes from a runeInput, starting at a given position.
+type runeCursor struct {
+	in  *runeInput
+	pos uint64
+}
+
+func (rc *runeCursor) ReadRune() (rune, int, error) {
+	r, ok := rc.in.at(rc.pos)
+	if !ok {
+		return 0, 0, io.EOF
+	}
+	rc.pos++
+	return r, runeWidth(r), nil
+}
+
+func runeWidth(r rune) int {
+	if w := utf8.RuneLen(r); w > 0 {
+		return w
+	}
+	return 1
+}
@@ -0,0 +1,78 @@
+package earley
+
+import (
+	"github.com/npillmayer/gorgo"
+	"github.com/npillmayer/gorgo/lr"
+)
+
+/*
+Ruby slippers.
+
+Jeffrey Kegler's Marpa parser popularized a technique called "ruby slippers":
+if the parser is not able to continue with the next input token, it asks the
+application for help. As an Earley parser knows exactly which terminals it
+expects at any time, the application may wish for one of them, e.g. for a
+virtual semicolon or for an end tag omitted in HTML, and the parser continues
+as if this token had been part of the input. This removes the need to let the
+scanner guess about tokens not present in the input.
+*/
+
+// SlipperHook is a ruby-slippers hook, called by the parser if it is not able to
+// scan the next input token after position pos. It receives the terminals expected
+// at pos (sorted by name) and the token which has been rejected.
+//
+// The hook may return a token fabricated for one of the expected terminals, or nil.
+// After scanning the fabricated token, the parser retries the rejected token. Hooks
+// are responsible not to fabricate tokens endlessly.
+type SlipperHook func(pos uint64, expected []*lr.Symbol, token gorgo.Token) gorgo.Token
+
+// RubySlippers configures the parser to call a hook for fabricating tokens if
+// the parser is not able to scan an input token. Fabricated tokens are marked as
+// such, see IsFabricated and sppf.RuleNode.IsFabricated.
+func RubySlippers(hook SlipperHook) Option {
+	return func(p *Parser) {
+		p.slippers = hook
+	}
+}
+
+// fabricatedToken wraps a token fabricated by a ruby-slippers hook or by input
+// repair.
+type fabricatedToken struct {
+	gorgo.Token
+}
+
+// IsFabricated returns true if a token has been fabricated by a ruby-slippers
+// hook or inserted by input repair, instead of having been read from the input.
+func IsFabricated(token gorgo.Token) bool {
+	_, ok := token.(fabricatedToken)
+	return ok
+}
+
+// fabricate calls the ruby-slippers hook after the parser has not been able to
+// scan token, given the items of Si. If the hook fabricates a token which the
+// items of Si are able to scan, fabricate returns it.
+func (p *Parser) fabricate(i uint64, token gorgo.Token) gorgo.Token {
+	t := p.slippers(i, p.expected(i), token)
+	if t == nil {
+		return nil
+	}
+	tracer().Infof("ruby slippers: fabricated token %q|%d @ %d", t.Lexeme(), t.TokType(), i)
+	S, S1 := p.states[i], p.states[i+1]
+	t = fabricatedToken{t}
+	S.Each(func(item lr.Item) {
+		if p.scan(S, S1, item, int(t.TokType())) {
+			p.bforest.scanned(item, t, i, i+1)
+			if p.online != nil {
+				p.online.scanned(item, i, t, gorgo.Span{i, i + 1})
+			}
+		}
+	})
+	if S1.Empty() {
+		tracer().Infof("fabricated token %q not expected @ %d", t.Lexeme(), i)
+		return nil
+	}
+	if p.hasmode(optionStoreTokens) {
+		p.tokens[i+1] = t
+	}
+	return t
+}
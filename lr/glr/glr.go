@@ -32,6 +32,32 @@ The API is still very much in flux! Currently it is something like:
 	p := glr.NewParser(grammar, gotoTable, actionTable)
 	p.Parse(startState, scanner)
 
+Semantic Actions
+
+Clients may attach semantic actions to grammar rules. Values computed by reduce
+actions are carried on the nodes of the DSS. Whenever two stacks reduce the same
+non-terminal over the same span of input, a merge function is called to combine
+the two values (see https://people.eecs.berkeley.edu/~necula/Papers/elkhound_cc04.pdf).
+This enables clients to resolve ambiguities on the fly, without constructing a
+parse forest first.
+
+	p := glr.NewParser(grammar, gotoTable, actionTable,
+	    glr.OnReduce(3, func(rule *lr.Rule, rhs []interface{}) interface{} { … }),
+	    glr.OnMerge(func(sym *lr.Symbol, v1, v2 interface{}) interface{} { … }))
+	accept, err := p.Parse(startState, scanner)
+	value := p.Result()
+
+Tracing
+
+For debugging grammars or for teaching purposes, the parser is able to record
+a snapshot of the DSS after every shift and every reduce. The trace may be
+exported to JSON or to an HTML page animating the run (see package dss).
+
+	trace := dss.NewTrace("my run")
+	p := glr.NewParser(grammar, gotoTable, actionTable, glr.TraceDSS(trace))
+	accept, err := p.Parse(startState, scanner)
+	trace.WriteHTML(w)
+
 ___________________________________________________________________________
 
 License
@@ -45,8 +71,11 @@ Copyright © 2017–2021 Norbert Pillmayer <norbert@pillmayer.com>
 package glr
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
 	"text/scanner"
 
 	"github.com/npillmayer/gorgo"
@@ -63,25 +92,42 @@ func tracer() tracing.Trace {
 // A Parser type for GLR parsing.
 // Create and initialize one with glr.NewParser(...)
 type Parser struct {
-	G       *lr.Grammar // grammar to use; do not alter after initialization
-	dss     *dss.Root   // DSS stack, i.e. multiple parse stacks
-	gotoT   *lr.Table   // GOTO table
-	actionT *lr.Table   // ACTION table
+	G         *lr.Grammar          // grammar to use; do not alter after initialization
+	dss       *dss.Root            // DSS stack, i.e. multiple parse stacks
+	gotoT     *lr.Table            // GOTO table
+	actionT   *lr.Table            // ACTION table
+	reducers  map[int]ReduceAction // semantic actions per rule
+	merge     dss.MergeFunc        // merges values of local ambiguities
+	shiftVal  ShiftAction          // semantic action for terminals
+	semantics bool                 // do we carry semantic values?
+	result    interface{}          // semantic value of the start symbol
+	trace     *dss.Trace           // records snapshots of the DSS, if non-nil
+	maxStacks int                  // maximum number of active stacks, 0 for no limit
+	ctx       context.Context      // context of the current parse
 	//accepting []int             // slice of accepting states
 }
 
 // NewParser creates and initializes a parser object, given information from an
 // lr.LRTableGenerator. Clients have to provide a link to the grammar and the
 // parser tables.
-func NewParser(g *lr.Grammar, gotoTable *lr.Table, actionTable *lr.Table) *Parser {
+func NewParser(g *lr.Grammar, gotoTable *lr.Table, actionTable *lr.Table, opts ...Option) *Parser {
 	parser := &Parser{
 		G:       g,
 		gotoT:   gotoTable,
 		actionT: actionTable,
 	}
+	for _, opt := range opts {
+		opt(parser)
+	}
 	return parser
 }
 
+// Result returns the semantic value of the start symbol after a successful
+// parse, if semantic actions have been configured for the parser.
+func (p *Parser) Result() interface{} {
+	return p.result
+}
+
 // From https://people.eecs.berkeley.edu/~necula/Papers/elkhound_cc04.pdf
 //
 // As with LR-parsing, the GLR algorithm uses a parse stack and a finite control.
@@ -111,17 +157,33 @@ func NewParser(g *lr.Grammar, gotoTable *lr.Table, actionTable *lr.Table) *Parse
 // The parser must have been initialized.
 //
 // Parse returns true, if the input was successfully recognized by the parse, false
-// otherwise.
+// otherwise. If the parse fails because all stacks of the DSS die, Parse returns
+// an error of type *SyntaxError, giving details about the offending token.
 func (p *Parser) Parse(S *lr.CFSMState, scan Scanner) (bool, error) {
+	return p.ParseContext(context.Background(), S, scan)
+}
+
+// ParseContext is like Parse, but aborts the parse if ctx is done, returning
+// ctx.Err(). If the number of active stacks exceeds the limit set with option
+// MaxStacks, the parse is aborted as well and ParseContext returns an error of
+// type *lr.LimitError. Both are checked for every stack the parser processes,
+// including the stacks forked by a cascade of reductions.
+func (p *Parser) ParseContext(ctx context.Context, S *lr.CFSMState, scan Scanner) (bool, error) {
 	if p.G == nil || p.gotoT == nil {
 		tracer().Errorf("GLR parser not initialized")
 		return false, fmt.Errorf("GLR parser not initialized")
 	}
+	p.ctx = ctx
 	p.dss = dss.NewRoot("G", -1)       // drops existing stacks for new run
+	p.result = nil                     // drop result of previous run
 	start := dss.NewStack(p.dss)       // create first stack instance in DSS
 	start.Push(int(S.ID), p.G.Epsilon) // push the start state onto the stack
+	if p.trace != nil {
+		p.trace.Record(p.dss, "start", nil)
+	}
 	accepting := false
 	done := false
+	pos := uint64(0) // position of token in the input
 	tokval, token := scan.NextToken(nil)
 	for !done && !accepting {
 		if token == nil {
@@ -130,20 +192,40 @@ func (p *Parser) Parse(S *lr.CFSMState, scan Scanner) (bool, error) {
 		tracer().Debugf("got token %v from scanner", token)
 		activeStacks := p.dss.ActiveStacks()
 		tracer().P("glr", "parse").Debugf("currently %d active stack(s)", len(activeStacks))
-		for _, stack := range activeStacks {
-			accepting = accepting || p.reducesAndShiftsForToken(stack, tokval)
+		var shifts stackSet
+		var err error
+		seen := make(map[*dss.Node]bool) // TOS nodes processed for this token
+		accepting, shifts, err = p.reducesForToken(activeStacks, tokval, pos, seen)
+		if err != nil {
+			return false, err
+		}
+		if !accepting && shifts.empty() { // all stacks died
+			tracer().Infof("no stack survived token %v", token)
+			return false, p.syntaxError(S.CFSM(), tokval, token, pos, seen)
+		}
+		if !accepting {
+			// all stacks shift the token together, at the next input position
+			p.dss.Advance()
+			tracer().Infof("%d shift operations", len(shifts))
+			for !shifts.empty() {
+				p.shift(tokval, token, shifts.get())
+			}
+			if p.trace != nil {
+				p.trace.Record(p.dss, fmt.Sprintf("shift %s", tokenString(tokval)), nil)
+			}
 		}
 		tracer().Debugf("~~~~~ processed token %v ~~~~~~~~~~~~~~~~~~~~", token)
 		if tokval == scanner.EOF {
 			done = true
 		} else {
 			tokval, token = scan.NextToken(nil)
+			pos++
 		}
 	}
 	return accepting, nil
 }
 
-// With a new lookahead (tokval): execute all possible reduces and shifts,
+// With a new lookahead (tokval): execute all possible reduces,
 // cascading. The general outline is as follows:
 //
 //   1. do until no more reduces:
@@ -153,21 +235,61 @@ func (p *Parser) Parse(S *lr.CFSMState, scan Scanner) (bool, error) {
 //           | conflict: shift/reduce or reduce/reduce
 //              | do reduce(s) and store stack(s) in S or R respectively
 //      1.b iterate again with R
-//   2. shifts are now collected in S => execute
-func (p *Parser) reducesAndShiftsForToken(stack *dss.Stack, tokval int) bool {
+//   2. shifts are now collected in S and returned to the caller
+//
+// Shifts are executed by the caller, after all stacks have performed their
+// reduces. This keeps the stacks synchronized on the input position.
+//
+// Different stacks may share a TOS node. Actions for a node are executed only
+// once per token, as otherwise reductions (and merges of semantic values) would
+// be performed repeatedly. Stacks on a node already processed are discarded.
+// Processed nodes are collected in seen. However, a reduction may push its LHS
+// onto a node already processed, adding a new link to it. Reductions for paths
+// via the new link have not been performed yet. They are carried out for every
+// node processed, restricted to paths using the new link (see J. Rekers:
+// "Parser Generation for Interactive Environments", 1992).
+//
+// Stacks are processed in order of the span their TOS covers, shortest span
+// first. This way every derivation of a symbol over a given span has been merged
+// into its stack node before the node is itself used for a reduction (see
+// section 3.3 of the Elkhound paper). We do not order reductions producing
+// symbols with identical spans, i.e. unit rules.
+//
+// Resource limits are checked for every stack processed. If a limit has been
+// exceeded, reducesForToken stops and returns an error.
+func (p *Parser) reducesForToken(stacks []*dss.Stack, tokval int, pos uint64,
+	seen map[*dss.Node]bool) (bool, []*dss.Stack, error) {
+	//
 	var heads [2]*dss.Stack
 	var actions [2]int32
 	accepting := false
-	S := newStackSet() // will collect shift actions
-	R := newStackSet() // re-consider stack/action for reduce
-	R = R.add(stack)   // start with this active stack
+	S := newStackSet()                         // will collect shift actions
+	R := newStackSet()                         // re-consider stack/action for reduce
+	R = R.add(stacks...)                       // start with all active stacks
+	links := make(map[*dss.Stack][2]*dss.Node) // stacks with new links to processed nodes
 	for !R.empty() {
-		heads[0] = R.get()
+		if err := p.checkLimits(pos); err != nil {
+			return false, nil, err
+		}
+		heads[0] = R.getShortest()
+		if link, ok := links[heads[0]]; ok {
+			delete(links, heads[0])
+			tracer().Debugf("new link to processed TOS of %v", heads[0])
+			R = R.add(p.reduceLink(link[0], link[1], tokval, seen, links)...)
+		}
+		if seen[heads[0].TOS()] {
+			tracer().Debugf("TOS of %v already processed", heads[0])
+			heads[0].Die()
+			continue
+		}
+		seen[heads[0].TOS()] = true
 		stateID, sym := heads[0].Peek()
 		tracer().P("dss", "TOS").Debugf("state = %d, symbol = %v", stateID, sym)
 		actions[0], actions[1] = p.actionT.Values(uint(stateID), gorgo.TokType(tokval))
 		if actions[0] == lr.AcceptAction {
 			accepting = true
+			p.accept(heads[0])
+			continue
 		}
 		if actions[0] == p.actionT.NullValue() {
 			tracer().Infof("no entry in ACTION table found, parser dies")
@@ -179,51 +301,249 @@ func (p *Parser) reducesAndShiftsForToken(stack *dss.Stack, tokval int) bool {
 			conflict := actions[1] != p.actionT.NullValue()
 			if conflict { // shift/reduce or reduce/reduce conflict
 				tracer().Infof("conflict, forking stack")
-				heads[1] = stack.Fork() // must happen before action 1 !
+				heads[1] = heads[0].Fork() // must happen before action 1 !
 				headcnt = 2
 			}
 			for i := 0; i < headcnt; i++ {
 				if actions[i] >= 0 { // reduce action
-					stacks := p.reduce(stateID, p.G.Rule(int(actions[i])), heads[i])
-					R = R.add(stacks...)
+					stacks, joined := p.reduce(p.G.Rule(int(actions[i])), heads[i])
+					R = R.add(collectLinks(stacks, joined, seen, links)...)
 				} else { // shift action
 					S = S.add(heads[i])
 				}
 			}
 		}
-		tracer().Infof("%d shift operations in S", len(S))
-		for !S.empty() {
-			heads[0] = S.get()
-			p.shift(stateID, tokval, heads[0])
-		}
 	}
-	return accepting
+	return accepting, S, nil
 }
 
-func (p *Parser) shift(stateID int, tokval int, stack *dss.Stack) []*dss.Stack {
+// checkLimits returns an error if the context of the parse is done or if the
+// DSS has more active stacks than allowed.
+func (p *Parser) checkLimits(pos uint64) error {
+	if err := p.ctx.Err(); err != nil {
+		tracer().Infof("parse aborted @ %d: %v", pos, err)
+		return err
+	}
+	if n := p.dss.StackCount(); p.maxStacks > 0 && n > p.maxStacks {
+		tracer().Infof("%d active stacks, parse aborted", n)
+		return &lr.LimitError{Limit: lr.ActiveStacks, Max: p.maxStacks, Position: pos}
+	}
+	return nil
+}
+
+// accept is called for a stack which accepts the input. If semantic actions
+// are configured, the value of the start symbol is recorded (and merged, if
+// more than one stack accepts).
+func (p *Parser) accept(stack *dss.Stack) {
+	if !p.semantics {
+		return
+	}
+	v := stack.Value()
+	if p.result != nil && p.merge != nil {
+		_, sym := stack.Peek()
+		v = p.merge(sym, p.result, v)
+	} else if p.result != nil {
+		v = p.result
+	}
+	p.result = v
+}
+
+func (p *Parser) shift(tokval int, token interface{}, stack *dss.Stack) []*dss.Stack {
+	stateID, _ := stack.Peek()
 	nextstate := p.gotoT.Value(uint(stateID), gorgo.TokType(tokval))
 	tracer().Infof("shifting %v to %d", tokenString(tokval), nextstate)
 	terminal := p.G.Terminal(tokval)
-	head := stack.Push(int(nextstate), terminal)
+	var head *dss.Stack
+	if p.semantics {
+		var value interface{} = token
+		if p.shiftVal != nil {
+			value = p.shiftVal(tokval, token)
+		}
+		head = stack.PushValue(int(nextstate), terminal, value, nil)
+	} else {
+		head = stack.Push(int(nextstate), terminal)
+	}
 	return []*dss.Stack{head}
 }
 
-func (p *Parser) reduce(stateID int, rule *lr.Rule, stack *dss.Stack) []*dss.Stack {
+// reduceLink performs the reductions for a new link from node to down to node
+// from, with node to having been processed already. Reductions are re-done for
+// every node processed, for paths using the new link.
+func (p *Parser) reduceLink(to, from *dss.Node, tokval int, seen map[*dss.Node]bool,
+	links map[*dss.Stack][2]*dss.Node) []*dss.Stack {
+	//
+	var R []*dss.Stack
+	for tos := range seen {
+		a1, a2 := p.actionT.Values(uint(tos.State), gorgo.TokType(tokval))
+		for _, a := range []int32{a1, a2} { // shift and accept have been done already
+			if a < 0 || a == p.actionT.NullValue() || p.G.Rule(int(a)).IsEps() {
+				continue
+			}
+			rule := p.G.Rule(int(a))
+			heads, values := p.dss.ReduceLink(tos, rule.RHS(), to, from)
+			if len(heads) > 0 {
+				tracer().Infof("reduce %v via new link", rule)
+				stacks, joined := p.pushLHS(rule, heads, values)
+				R = append(R, collectLinks(stacks, joined, seen, links)...)
+			}
+		}
+	}
+	return R
+}
+
+// collectLinks records stacks which have been linked to a TOS already processed,
+// together with the new link.
+func collectLinks(stacks []*dss.Stack, joined []*dss.Node, seen map[*dss.Node]bool,
+	links map[*dss.Stack][2]*dss.Node) []*dss.Stack {
+	//
+	for i, stack := range stacks {
+		if joined[i] != nil && seen[stack.TOS()] {
+			links[stack] = [2]*dss.Node{stack.TOS(), joined[i]}
+		}
+	}
+	return stacks
+}
+
+// reduce performs a reduction for a stack and returns the new stack heads. For
+// every head joined with the TOS of another stack, the node it has been linked
+// from is returned as well (see dss.Stack.PushJoin).
+func (p *Parser) reduce(rule *lr.Rule, stack *dss.Stack) ([]*dss.Stack, []*dss.Node) {
 	tracer().Infof("reduce %v", rule)
 	handle := rule.RHS()
-	heads := stack.Reduce(handle)
+	var heads []*dss.Stack
+	var values [][]interface{}
+	if rule.IsEps() { // nothing to pop, LHS is pushed onto this stack
+		heads, values = []*dss.Stack{stack}, [][]interface{}{{}}
+	} else {
+		if p.trace != nil {
+			p.trace.Record(p.dss, fmt.Sprintf("reduce %v: handle", rule), handlePaths(stack, handle))
+		}
+		heads, values = stack.ReduceWithValues(handle)
+	}
+	return p.pushLHS(rule, heads, values)
+}
+
+// pushLHS pushes the LHS of a rule onto the stack heads resulting from popping
+// the RHS, with semantic values computed from values.
+func (p *Parser) pushLHS(rule *lr.Rule, heads []*dss.Stack, values [][]interface{}) ([]*dss.Stack, []*dss.Node) {
+	joined := make([]*dss.Node, len(heads))
 	if heads != nil {
 		tracer().Debugf("reduce resulted in %d stacks", len(heads))
 		lhs := rule.LHS
+		var lhsNodes []*dss.Node // for tracing
 		for i, head := range heads {
 			state, _ := head.Peek()
 			tracer().Debugf("state on stack#%d is %d", i, state)
 			nextstate := p.gotoT.Value(uint(state), gorgo.TokType(lhs.Value))
-			newhead := head.Push(int(nextstate), lhs)
+			var newhead *dss.Stack
+			if p.semantics {
+				var value interface{}
+				if action, ok := p.reducers[rule.Serial]; ok {
+					value = action(rule, values[i])
+				}
+				newhead = head.PushValue(int(nextstate), lhs, value, p.merge)
+			} else {
+				newhead, joined[i] = head.PushJoin(int(nextstate), lhs)
+			}
 			tracer().Debugf("new head = %v", newhead)
+			lhsNodes = append(lhsNodes, newhead.TOS())
+		}
+		if p.trace != nil {
+			p.trace.Record(p.dss, fmt.Sprintf("reduce %v", rule), lhsNodes)
+		}
+	}
+	return heads, joined
+}
+
+// handlePaths collects the nodes of all paths for a handle, starting at the
+// TOS of a stack.
+func handlePaths(stack *dss.Stack, handle []*lr.Symbol) []*dss.Node {
+	var nodes []*dss.Node
+	for skip := 0; ; skip++ {
+		path := stack.FindHandlePath(handle, skip)
+		if path == nil {
+			return nodes
+		}
+		nodes = append(nodes, path...)
+	}
+}
+
+// --- Syntax errors ---------------------------------------------------------
+
+// SyntaxError is returned by Parse if no stack of the DSS is able to accept an
+// input token. It gives information about the offending token and about the
+// parser states of the stacks alive just before the token has been read. This
+// enables clients to produce messages like "unexpected X, expected one of: …".
+type SyntaxError struct {
+	TokenValue int          // token value of the offending token
+	Token      interface{}  // offending token, as delivered by the scanner
+	Position   uint64       // index of the offending token in the input (token count)
+	Span       gorgo.Span   // span of the offending token, if it reports one
+	States     []int        // states of the stacks alive before the token has been read
+	Expected   []*lr.Symbol // union of terminals acceptable in States
+	Items      []lr.Item    // configuration items of States
+}
+
+func (e *SyntaxError) Error() string {
+	var b strings.Builder
+	for i, t := range e.Expected {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(t.Name)
+	}
+	at := fmt.Sprintf("token %d", e.Position)
+	if !e.Span.IsNull() {
+		at = e.Span.String()
+	}
+	return fmt.Sprintf("syntax error at %s: unexpected %s, expected one of: %s",
+		at, tokenString(e.TokenValue), b.String())
+}
+
+// syntaxError collects information for a SyntaxError from the TOS nodes which
+// have been processed for the offending token.
+func (p *Parser) syntaxError(cfsm *lr.CFSM, tokval int, token interface{}, pos uint64,
+	seen map[*dss.Node]bool) *SyntaxError {
+	//
+	e := &SyntaxError{
+		TokenValue: tokval,
+		Token:      token,
+		Position:   pos,
+	}
+	if t, ok := token.(interface{ Span() gorgo.Span }); ok {
+		e.Span = t.Span()
+	}
+	states := make(map[int]bool)
+	for node := range seen {
+		if node.State >= 0 && !states[node.State] {
+			states[node.State] = true
+			e.States = append(e.States, node.State)
 		}
 	}
-	return heads
+	sort.Ints(e.States)
+	p.G.EachTerminal(func(A *lr.Symbol) interface{} {
+		if A.Value == tokval {
+			return nil
+		}
+		for _, state := range e.States {
+			if p.actionT.Value(uint(state), A.TokenType()) != p.actionT.NullValue() {
+				e.Expected = append(e.Expected, A)
+				break
+			}
+		}
+		return nil
+	})
+	sort.Slice(e.Expected, func(i, j int) bool {
+		return e.Expected[i].Name < e.Expected[j].Name
+	})
+	if cfsm != nil {
+		for _, state := range e.States {
+			if s := cfsm.State(uint(state)); s != nil {
+				e.Items = append(e.Items, s.Items()...)
+			}
+		}
+	}
+	return e
 }
 
 // --- Sets of Stacks --------------------------------------------------------
@@ -254,6 +574,30 @@ func (sset *stackSet) get() *dss.Stack {
 	return s
 }
 
+// get the stack from the set whose TOS covers the shortest span of input
+func (sset *stackSet) getShortest() *dss.Stack {
+	l := len(*sset)
+	if l == 0 {
+		return nil
+	}
+	k := l - 1
+	for i, s := range *sset {
+		if shorter(s.TOS().Span(), (*sset)[k].TOS().Span()) {
+			k = i
+		}
+	}
+	(*sset)[k], (*sset)[l-1] = (*sset)[l-1], (*sset)[k]
+	return sset.get()
+}
+
+// shorter orders spans by length, spans ending earlier first for equal lengths.
+func shorter(a, b gorgo.Span) bool {
+	if a.Len() != b.Len() {
+		return a.Len() < b.Len()
+	}
+	return a.End() < b.End()
+}
+
 // is this set empty?
 func (sset stackSet) empty() bool {
 	return len(sset) == 0
@@ -267,15 +611,83 @@ func (sset *stackSet) clear() {
 	*sset = (*sset)[:0]
 }
 
+// --- Option handling --------------------------------------------------
+
+// Option configures a parser.
+type Option func(p *Parser)
+
+// ReduceAction is a semantic action for a grammar rule. It receives the semantic
+// values of the RHS symbols and returns the value for the LHS symbol.
+type ReduceAction func(rule *lr.Rule, rhs []interface{}) interface{}
+
+// ShiftAction is a semantic action for terminals. It receives the token value and
+// the token as delivered by the scanner, and returns the semantic value for the
+// terminal symbol. If no ShiftAction is configured, the token is used as value.
+type ShiftAction func(tokval int, token interface{}) interface{}
+
+// OnReduce configures the parser to call a semantic action whenever rule
+// number ruleno is reduced. Reductions of rules without an action will
+// result in a nil value.
+func OnReduce(ruleno int, action ReduceAction) Option {
+	return func(p *Parser) {
+		if p.reducers == nil {
+			p.reducers = make(map[int]ReduceAction)
+		}
+		p.reducers[ruleno] = action
+		p.semantics = true
+	}
+}
+
+// OnShift configures the parser to call a semantic action for every terminal
+// shifted.
+func OnShift(action ShiftAction) Option {
+	return func(p *Parser) {
+		p.shiftVal = action
+		p.semantics = true
+	}
+}
+
+// OnMerge configures the parser to call a merge function whenever two stacks
+// reduce the same non-terminal over the same span of input. The merge function
+// receives the two semantic values and returns a value to use instead.
+// This is the place to resolve ambiguities on the fly, e.g. by selecting one
+// of the values.
+func OnMerge(merge dss.MergeFunc) Option {
+	return func(p *Parser) {
+		p.merge = merge
+		p.semantics = true
+	}
+}
+
+// TraceDSS configures the parser to record a snapshot of the DSS after every
+// shift and every reduce. Before a reduce, an additional snapshot highlights
+// the handle path(s).
+func TraceDSS(trace *dss.Trace) Option {
+	return func(p *Parser) {
+		p.trace = trace
+	}
+}
+
+// MaxStacks configures the parser to abort a parse if more than n stacks of
+// the DSS are active at an input position. Highly ambiguous grammars may fork
+// stacks for every token. n ≤ 0 means no limit (default).
+func MaxStacks(n int) Option {
+	return func(p *Parser) {
+		p.maxStacks = n
+	}
+}
+
 // --- Scanner ----------------------------------------------------------
 
 // A Token type, if you want to use it. Tokens of this type are returned
 // by StdScanner.
 //
-// Clients may provide their own token data type.
+// Clients may provide their own token data type. Tokens providing a method
+// Span() gorgo.Span will have their span reported in syntax errors.
 type Token struct {
 	Value  int
 	Lexeme []byte
+	Pos    gorgo.Span // position in the input (byte offsets)
 }
 
 // Scanner is an interface the parser relies on.
@@ -288,6 +700,11 @@ func tokenString(tok int) string {
 	return scanner.TokenString(rune(tok))
 }
 
+// Span returns the position of a token in the input.
+func (token *Token) Span() gorgo.Span {
+	return token.Pos
+}
+
 func (token *Token) String() string {
 	return fmt.Sprintf("(%s:%d|\"%s\")", tokenString(token.Value), token.Value,
 		string(token.Lexeme))
@@ -324,6 +741,11 @@ func (s *StdScanner) MoveTo(position uint64) {
 func (s *StdScanner) NextToken(expected []int) (int, interface{}) {
 	tokval := int(s.scan.Scan())
 	token := &Token{Value: tokval, Lexeme: []byte(s.scan.TokenText())}
+	start := s.scan.Pos().Offset // position after EOF
+	if s.scan.Position.IsValid() {
+		start = s.scan.Position.Offset
+	}
+	token.Pos = gorgo.Span{uint64(start), uint64(start + len(token.Lexeme))}
 	tracer().P("token", tokenString(tokval)).Debugf("scanned token at %s = \"%s\"",
 		s.scan.Position, s.scan.TokenText())
 	return tokval, token
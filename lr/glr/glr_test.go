@@ -1,14 +1,19 @@
 package glr
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"strconv"
 	"strings"
 	"testing"
 	"text/scanner"
 
+	"github.com/npillmayer/gorgo"
 	"github.com/npillmayer/gorgo/lr"
+	"github.com/npillmayer/gorgo/lr/dss"
 	"github.com/npillmayer/schuko/tracing"
 	"github.com/npillmayer/schuko/tracing/gotestingadapter"
 )
@@ -38,6 +43,246 @@ func TestGLR1(t *testing.T) {
 	parse(t, g, false, "+a-")
 }
 
+func TestGLRSyntaxError(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("G1")
+	b.LHS("S").N("A").T("-", '-').End()
+	b.LHS("S").T("+", '+').N("B").End()
+	b.LHS("A").T("+", '+').T("a", scanner.Ident).End()
+	b.LHS("B").T("a", scanner.Ident).T("-", '-').End()
+	g, err := b.Grammar()
+	if err != nil {
+		t.Error(err)
+	}
+	lrgen := lr.NewTableGenerator(lr.Analysis(g))
+	lrgen.CreateTables()
+	tracer().SetTraceLevel(tracing.LevelInfo)
+	p := NewParser(g, lrgen.GotoTable(), lrgen.ActionTable())
+	ok, err := p.Parse(lrgen.CFSM().S0, NewStdScanner(strings.NewReader("+a +")))
+	if ok {
+		t.Fatalf("parser accepted invalid input")
+	}
+	serr, isSyntaxErr := err.(*SyntaxError)
+	if !isSyntaxErr {
+		t.Fatalf("expected parser to return a syntax error, got %v", err)
+	}
+	t.Logf("error = %v", serr)
+	if serr.Position != 2 || serr.TokenValue != '+' {
+		t.Errorf("expected error at position 2 for token '+', is %d", serr.Position)
+	}
+	if serr.Span != (gorgo.Span{3, 4}) {
+		t.Errorf("expected error to report span (3…4) of token, is %v", serr.Span)
+	}
+	if len(serr.Expected) != 1 || serr.Expected[0].Name != "-" {
+		t.Errorf("expected '-' to be expected, have %v", serr.Expected)
+	}
+	if len(serr.Items) == 0 {
+		t.Errorf("expected error to report items of surviving states")
+	}
+}
+
+// Count the derivations of an ambiguous expression by merging semantic values.
+//
+//   1: E  ::= [E + E]
+//   2: E  ::= [a]
+func TestGLRMerge(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("G2")
+	b.LHS("E").N("E").T("+", '+').N("E").End()
+	b.LHS("E").T("a", scanner.Ident).End()
+	g, err := b.Grammar()
+	if err != nil {
+		t.Error(err)
+	}
+	lrgen := lr.NewTableGenerator(lr.Analysis(g))
+	lrgen.CreateTables()
+	tracer().SetTraceLevel(tracing.LevelInfo)
+	catalan := []int{1, 1, 2, 5, 14}
+	inp := "a"
+	for _, c := range catalan {
+		p := NewParser(g, lrgen.GotoTable(), lrgen.ActionTable(),
+			OnShift(func(int, interface{}) interface{} { return 1 }),
+			OnReduce(1, func(r *lr.Rule, rhs []interface{}) interface{} {
+				return rhs[0].(int) * rhs[2].(int)
+			}),
+			OnReduce(2, func(r *lr.Rule, rhs []interface{}) interface{} { return rhs[0] }),
+			OnMerge(func(sym *lr.Symbol, v1, v2 interface{}) interface{} {
+				return v1.(int) + v2.(int)
+			}))
+		ok, err := p.Parse(lrgen.CFSM().S0, NewStdScanner(strings.NewReader(inp)))
+		if err != nil || !ok {
+			t.Fatalf("parser did not accept input='%s'", inp)
+		}
+		if p.Result() != c {
+			t.Errorf("expected %d derivations for '%s', have %v", c, inp, p.Result())
+		}
+		inp += "+a"
+	}
+}
+
+func TestGLRLimits(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("G2")
+	b.LHS("E").N("E").T("+", '+').N("E").End()
+	b.LHS("E").T("a", scanner.Ident).End()
+	g, err := b.Grammar()
+	if err != nil {
+		t.Error(err)
+	}
+	lrgen := lr.NewTableGenerator(lr.Analysis(g))
+	lrgen.CreateTables()
+	tracer().SetTraceLevel(tracing.LevelInfo)
+	p := NewParser(g, lrgen.GotoTable(), lrgen.ActionTable(), MaxStacks(1))
+	ok, err := p.Parse(lrgen.CFSM().S0, NewStdScanner(strings.NewReader("a+a+a+a")))
+	if lerr, isLimit := err.(*lr.LimitError); ok || !isLimit || lerr.Limit != lr.ActiveStacks {
+		t.Errorf("expected parse to exceed limit of active stacks, have %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p = NewParser(g, lrgen.GotoTable(), lrgen.ActionTable())
+	ok, err = p.ParseContext(ctx, lrgen.CFSM().S0, NewStdScanner(strings.NewReader("a+a")))
+	if ok || err != context.Canceled {
+		t.Errorf("expected parse to be cancelled, have %v", err)
+	}
+	// at most 2 stacks are active between tokens, but a cascade of reductions
+	// forks up to 4 stacks
+	for n, exceeded := range map[int]bool{2: true, 4: false} {
+		p = NewParser(g, lrgen.GotoTable(), lrgen.ActionTable(), MaxStacks(n))
+		ok, err = p.Parse(lrgen.CFSM().S0, NewStdScanner(strings.NewReader("a+a+a+a")))
+		if _, isLimit := err.(*lr.LimitError); isLimit != exceeded || ok == exceeded {
+			t.Errorf("expected limit of %d stacks to be exceeded=%v, have %v", n, exceeded, err)
+		}
+	}
+}
+
+// Resolve an ambiguity on the fly by selecting the left-associative variant.
+func TestGLRMergeSelect(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("G2")
+	b.LHS("E").N("E").T("-", '-').N("E").End()
+	b.LHS("E").T("n", scanner.Int).End()
+	g, err := b.Grammar()
+	if err != nil {
+		t.Error(err)
+	}
+	lrgen := lr.NewTableGenerator(lr.Analysis(g))
+	lrgen.CreateTables()
+	tracer().SetTraceLevel(tracing.LevelInfo)
+	type expr struct {
+		value    int
+		compound bool // is this a compound expression?
+		left     bool // is left operand a compound expression?
+	}
+	p := NewParser(g, lrgen.GotoTable(), lrgen.ActionTable(),
+		OnShift(func(tokval int, token interface{}) interface{} {
+			n, _ := strconv.Atoi(string(token.(*Token).Lexeme))
+			return expr{value: n}
+		}),
+		OnReduce(1, func(r *lr.Rule, rhs []interface{}) interface{} {
+			x, y := rhs[0].(expr), rhs[2].(expr)
+			return expr{value: x.value - y.value, compound: true, left: x.compound}
+		}),
+		OnReduce(2, func(r *lr.Rule, rhs []interface{}) interface{} { return rhs[0] }),
+		OnMerge(func(sym *lr.Symbol, v1, v2 interface{}) interface{} {
+			if v1.(expr).left {
+				return v1
+			}
+			return v2
+		}))
+	ok, err := p.Parse(lrgen.CFSM().S0, NewStdScanner(strings.NewReader("7-2-1")))
+	if err != nil || !ok {
+		t.Fatalf("parser did not accept input")
+	}
+	if p.Result().(expr).value != 4 {
+		t.Errorf("expected (7-2)-1 = 4, have %v", p.Result())
+	}
+}
+
+// Reductions may add links to stack nodes which have already been processed for
+// a token. Reductions via the new links have to be performed as well.
+//
+//   1: S  ::= [S S]
+//   2: S  ::= [+ S]
+//   3: S  ::= [-]
+func TestGLRNewLink(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("G3")
+	b.LHS("S").N("S").N("S").End()
+	b.LHS("S").T("+", '+').N("S").End()
+	b.LHS("S").T("-", '-').End()
+	g, err := b.Grammar()
+	if err != nil {
+		t.Error(err)
+	}
+	lrgen := lr.NewTableGenerator(lr.Analysis(g))
+	lrgen.CreateTables()
+	tracer().SetTraceLevel(tracing.LevelInfo)
+	for _, inp := range []string{"-", "--", "+--", "++--", "+++--", "-+--+-", "+-+--"} {
+		p := NewParser(g, lrgen.GotoTable(), lrgen.ActionTable())
+		ok, err := p.Parse(lrgen.CFSM().S0, NewStdScanner(strings.NewReader(inp)))
+		if err != nil || !ok {
+			t.Errorf("parser did not accept input='%s': %v", inp, err)
+		}
+	}
+	p := NewParser(g, lrgen.GotoTable(), lrgen.ActionTable())
+	if ok, _ := p.Parse(lrgen.CFSM().S0, NewStdScanner(strings.NewReader("+-+"))); ok {
+		t.Errorf("parser accepted invalid input")
+	}
+}
+
+func TestGLRTrace(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("G2")
+	b.LHS("E").N("E").T("+", '+').N("E").End()
+	b.LHS("E").T("a", scanner.Ident).End()
+	g, err := b.Grammar()
+	if err != nil {
+		t.Error(err)
+	}
+	lrgen := lr.NewTableGenerator(lr.Analysis(g))
+	lrgen.CreateTables()
+	tracer().SetTraceLevel(tracing.LevelInfo)
+	trace := dss.NewTrace("a+a+a")
+	p := NewParser(g, lrgen.GotoTable(), lrgen.ActionTable(), TraceDSS(trace))
+	ok, err := p.Parse(lrgen.CFSM().S0, NewStdScanner(strings.NewReader("a+a+a")))
+	if err != nil || !ok {
+		t.Fatalf("parser did not accept input")
+	}
+	shifts, handles := 0, 0
+	for _, snap := range trace.Snapshots {
+		if strings.HasPrefix(snap.Label, "shift") {
+			shifts++
+		} else if strings.HasSuffix(snap.Label, "handle") && len(snap.Path) > 0 {
+			handles++
+		}
+	}
+	if shifts != 5 {
+		t.Errorf("expected 5 shift snapshots, have %d", shifts)
+	}
+	if handles == 0 {
+		t.Errorf("expected snapshots with highlighted handles")
+	}
+	var html bytes.Buffer
+	if err = trace.WriteHTML(&html); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(html.String(), "<svg "); n != len(trace.Snapshots) {
+		t.Errorf("expected %d SVG images in HTML, have %d", len(trace.Snapshots), n)
+	}
+}
+
 // ----------------------------------------------------------------------
 
 func parse(t *testing.T, g *lr.Grammar, doDump bool, input ...string) bool {
@@ -2,6 +2,7 @@ package lr
 
 import (
 	"fmt"
+	"regexp"
 
 	"github.com/npillmayer/gorgo"
 	"github.com/npillmayer/gorgo/lr/iteratable"
@@ -21,8 +22,9 @@ var lrSymbolIDSerial = NonTermType - 1
 
 // Symbol is a symbol type used for grammars and grammar builders.
 type Symbol struct {
-	Name  string // visual representation, if any
-	Value int    // ID or token value
+	Name    string         // visual representation, if any
+	Value   int            // ID or token value
+	Pattern *regexp.Regexp // for scannerless parsing: terminal matches a regular expression
 }
 
 func (lrsym *Symbol) String() string {
@@ -142,6 +144,27 @@ func (g *Grammar) Rule(no int) *Rule {
 	return g.rules[no]
 }
 
+// StartRule returns a rule S′ ➞ A #eof for a non-terminal A of the grammar. This
+// enables parsers to start from any non-terminal, e.g. for parsing just an
+// expression with a grammar for a complete programming language. The rule is
+// not part of the grammar, but shares the LHS and the serial number of rule 0.
+// For the start symbol of the grammar, StartRule returns rule 0.
+//
+// If A is not a non-terminal of the grammar, StartRule returns nil.
+func (g *Grammar) StartRule(A *Symbol) *Rule {
+	if len(g.rules) == 0 || len(g.rules[0].rhs) == 0 || A == nil || g.nonterminals[A.Value] != A {
+		return nil // grammar not yet complete or A not a non-terminal
+	}
+	r0 := g.rules[0]
+	if r0.LHS == A || r0.rhs[0] == A {
+		return r0
+	}
+	r := newRule()
+	r.LHS = r0.LHS
+	r.rhs = append(r.rhs, A, r0.rhs[len(r0.rhs)-1]) // A #eof
+	return r
+}
+
 // Terminal returns the terminal symbol for a given token value, if it
 // is defined in the grammar.
 func (g *Grammar) Terminal(tokval int) *Symbol {
@@ -34,6 +34,21 @@ func StartItem(r *Rule) (Item, *Symbol) {
 	return Item{r, 0, 0}, r.rhs[0]
 }
 
+// MakeItem returns an Earley item for rule r with the dot at position dot and
+// a given origin. It returns NullItem if dot is not a valid position within the
+// RHS of r.
+func MakeItem(r *Rule, dot int, origin uint64) Item {
+	if r == nil || dot < 0 || dot > len(r.rhs) {
+		return NullItem
+	}
+	return Item{r, dot, origin}
+}
+
+// Dot returns the position of the dot within the RHS of the rule of an item.
+func (i Item) Dot() int {
+	return i.dot
+}
+
 // PeekSymbol returns the symbol after the dot, if any.
 func (i Item) PeekSymbol() *Symbol {
 	if i.dot >= len(i.rule.rhs) {
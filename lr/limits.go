@@ -0,0 +1,42 @@
+package lr
+
+import "fmt"
+
+// Limit denotes a resource limit which clients may configure for a parser.
+// Parsers for ambiguous grammars may consume a lot of memory and time for
+// pathological input. Limits help to protect applications parsing untrusted input.
+type Limit int
+
+// Resource limits of the parsers in the sub-packages of lr.
+const (
+	ItemsPerSet  Limit = iota // maximum number of items per Earley set
+	TotalItems                // maximum number of Earley items of a parse
+	ActiveStacks              // maximum number of active stacks of a DSS
+	ForestNodes               // maximum number of nodes of a parse forest
+)
+
+func (l Limit) String() string {
+	switch l {
+	case ItemsPerSet:
+		return "items per set"
+	case TotalItems:
+		return "total items"
+	case ActiveStacks:
+		return "active stacks"
+	case ForestNodes:
+		return "forest nodes"
+	}
+	return fmt.Sprintf("limit #%d", int(l))
+}
+
+// LimitError is returned by a parser which has aborted a parse because a
+// resource limit has been exceeded.
+type LimitError struct {
+	Limit    Limit  // the limit which has been exceeded
+	Max      int    // configured maximum
+	Position uint64 // input position at which the parser has been aborted
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("parse aborted at %d: exceeded limit of %d %s", e.Position, e.Max, e.Limit)
+}
@@ -0,0 +1,79 @@
+package scanner
+
+import (
+	"sort"
+
+	"github.com/npillmayer/gorgo"
+)
+
+// TokenLattice is a simple LatticeTokenizer, which delivers alternative tokens
+// from a list. Clients may use it to wrap the result of their own tokenization
+// of an ambiguous input. Create one with NewTokenLattice.
+type TokenLattice struct {
+	tokens []gorgo.Token // sorted by start position
+	next   int           // index of the next token to deliver
+	Error  func(error)   // error handler
+}
+
+var _ LatticeTokenizer = (*TokenLattice)(nil)
+
+// NewTokenLattice creates a lattice from a list of tokens, which may overlap.
+// Tokens are ordered by their start positions. If the list does not contain an
+// EOF token, one will be appended at the end position of the last token.
+func NewTokenLattice(tokens []gorgo.Token) *TokenLattice {
+	tl := &TokenLattice{Error: logError}
+	tl.tokens = append(tl.tokens, tokens...)
+	sort.SliceStable(tl.tokens, func(i, j int) bool {
+		return tl.tokens[i].Span().Start() < tl.tokens[j].Span().Start()
+	})
+	end := uint64(0)
+	for _, t := range tl.tokens {
+		if t.TokType() == EOF {
+			return tl
+		}
+		if t.Span().End() > end {
+			end = t.Span().End()
+		}
+	}
+	tl.tokens = append(tl.tokens, MakeDefaultToken(EOF, "", gorgo.Span{end, end}))
+	return tl
+}
+
+// SetErrorHandler is part of the Tokenizer interface.
+func (tl *TokenLattice) SetErrorHandler(h func(error)) {
+	if h == nil {
+		tl.Error = logError
+		return
+	}
+	tl.Error = h
+}
+
+// NextTokens is part of the LatticeTokenizer interface. It returns all the
+// tokens starting at the next position.
+func (tl *TokenLattice) NextTokens() []gorgo.Token {
+	if tl.next >= len(tl.tokens) {
+		return tl.tokens[len(tl.tokens)-1:] // EOF
+	}
+	start := tl.tokens[tl.next].Span().Start()
+	k := tl.next
+	for k < len(tl.tokens) && tl.tokens[k].Span().Start() == start {
+		k++
+	}
+	alternatives := tl.tokens[tl.next:k]
+	tl.next = k
+	return alternatives
+}
+
+// NextToken is part of the Tokenizer interface. It follows a single path
+// through the lattice, always choosing the first alternative at a position.
+func (tl *TokenLattice) NextToken() gorgo.Token {
+	if tl.next >= len(tl.tokens) {
+		return tl.tokens[len(tl.tokens)-1] // EOF
+	}
+	t := tl.tokens[tl.next]
+	tl.next++
+	for tl.next < len(tl.tokens) && tl.tokens[tl.next].Span().Start() < t.Span().End() {
+		tl.next++ // skip alternatives overlapping t
+	}
+	return t
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/npillmayer/gorgo"
 	"github.com/npillmayer/schuko/tracing/gotestingadapter"
 )
 
@@ -42,3 +43,32 @@ func TestScan1(t *testing.T) {
 	}
 	t.Logf("------+-----------------+--------")
 }
+
+func TestTokenLattice(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.scanner")
+	defer teardown()
+	//
+	tokens := []gorgo.Token{ // x >> y
+		MakeDefaultToken(Ident, "y", gorgo.Span{5, 6}),
+		MakeDefaultToken('>', ">", gorgo.Span{2, 3}),
+		MakeDefaultToken(-50, ">>", gorgo.Span{2, 4}),
+		MakeDefaultToken('>', ">", gorgo.Span{3, 4}),
+		MakeDefaultToken(Ident, "x", gorgo.Span{0, 1}),
+	}
+	lattice := NewTokenLattice(tokens)
+	var counts []int
+	for alt := lattice.NextTokens(); alt[0].TokType() != EOF; alt = lattice.NextTokens() {
+		counts = append(counts, len(alt))
+	}
+	if fmt.Sprint(counts) != "[1 2 1 1]" {
+		t.Errorf("Expected alternatives per position to be [1 2 1 1], are %v", counts)
+	}
+	lattice = NewTokenLattice(tokens)
+	var lexemes []string
+	for token := lattice.NextToken(); token.TokType() != EOF; token = lattice.NextToken() {
+		lexemes = append(lexemes, token.Lexeme())
+	}
+	if strings.Join(lexemes, " ") != "x > > y" {
+		t.Errorf("Expected single path through lattice to be 'x > > y', is %v", lexemes)
+	}
+}
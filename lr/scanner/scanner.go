@@ -96,6 +96,25 @@ type Tokenizer interface {
 	SetErrorHandler(func(error)) // instruct the tokenizer on how to process errors
 }
 
+// LatticeTokenizer is an extension interface for tokenizers which are able to
+// deliver alternative tokenizations of the input. Some inputs may be split into
+// tokens in more than one way, e.g. "if" as a keyword or as an identifier, or ">>"
+// as a single token or as two. Parsers able to handle lexical ambiguity will
+// check if a Tokenizer is a LatticeTokenizer and let the grammar decide.
+//
+// The tokens form a lattice: a token leads from position Span().Start() of the
+// input to position Span().End(). NextTokens returns all the alternative tokens
+// starting at the next position of the lattice, with positions strictly increasing
+// between calls. Positions need not be consecutive: a token ending at position e
+// is followed by the tokens starting at the next position ≥ e (e.g., after
+// skipping whitespace). The final call returns a single EOF token.
+//
+// Parsers not aware of lattices will call NextToken only.
+type LatticeTokenizer interface {
+	Tokenizer
+	NextTokens() []gorgo.Token // read the alternative tokens at the next position
+}
+
 // DefaultTokenizer is a default implementation, backed by scanner.Scanner.
 // Create one with GoTokenizer.
 type DefaultTokenizer struct {
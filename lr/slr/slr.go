@@ -64,6 +64,7 @@ Copyright © 2017–2021 Norbert Pillmayer <norbert@pillmayer.com>
 package slr
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/npillmayer/gorgo"
@@ -123,6 +124,12 @@ func NewParser(g *lr.Grammar, gotoTable *lr.Table, actionTable *lr.Table) *Parse
 //
 // The parser returns true if the input string has been accepted.
 func (p *Parser) Parse(S *lr.CFSMState, scan scanner.Tokenizer) (bool, error) {
+	return p.ParseContext(context.Background(), S, scan)
+}
+
+// ParseContext is like Parse, but aborts the parse if ctx is done, returning
+// ctx.Err().
+func (p *Parser) ParseContext(ctx context.Context, S *lr.CFSMState, scan scanner.Tokenizer) (bool, error) {
 	tracer().Debugf("~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~")
 	if p.G == nil || p.gotoT == nil {
 		tracer().Errorf("SLR(1)-parser not initialized")
@@ -137,6 +144,10 @@ func (p *Parser) Parse(S *lr.CFSMState, scan scanner.Tokenizer) (bool, error) {
 	tokval := token.TokType()
 	done := false
 	for !done {
+		if err := ctx.Err(); err != nil {
+			tracer().Infof("parse aborted: %v", err)
+			return false, err
+		}
 		tracer().Debugf("got token %q/%d from scanner", token.Lexeme(), tokval)
 		state := p.stack[len(p.stack)-1] // TOS
 		action := p.actionT.Value(state.stateID, tokval)
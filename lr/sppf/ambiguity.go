@@ -0,0 +1,301 @@
+package sppf
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/npillmayer/gorgo"
+	"github.com/npillmayer/gorgo/lr"
+)
+
+/*
+Auditing ambiguity.
+
+A symbol node with more than one RHS-node is ambiguous: the parser has found
+more than one way to derive the span of input covered by the node. The number
+of parse trees represented by a forest is the product of the alternatives along
+every path, summed over the alternatives of each ambiguous node. It may grow
+exponentially with the length of the input, therefore we count with big.Int
+and enumerate trees one at a time.
+
+Trees are numbered 0…n-1. Tree number k is decoded top-down: at an ambiguous
+node, k selects an alternative RHS by subtracting the number of trees of the
+alternatives before it. The remainder is split among the children of the RHS
+as a mixed-radix number, with the number of trees of each child as its radix.
+A shared node may occur more than once in a tree, e.g. a nullable symbol deriving
+ε twice at the same position, with different choices for every occurrence.
+Tree numbers are therefore decoded per occurrence, not into choices per symbol
+node: a cursor on tree k (see TreeCursor) carries the number of the sub-tree
+below its current node and decodes it lazily while moving down. Clients may
+materialise tree k as well (see Tree).
+
+Forests containing cycles (from grammars with derivations A ⇒+ A) represent an
+infinite number of trees. They cannot be counted or enumerated.
+*/
+
+// Ambiguity describes an ambiguous symbol node of a forest.
+type Ambiguity struct {
+	Symbol *lr.Symbol // grammar symbol of the node
+	Span   gorgo.Span // span of input covered by the node
+	Rules  []int      // competing rules, one per RHS; rules repeat for different splits
+}
+
+// Ambiguities returns every ambiguous symbol node of a forest, sorted by span.
+func (f *Forest) Ambiguities() []Ambiguity {
+	if f == nil {
+		return nil
+	}
+	var ambiguities []Ambiguity
+	for sn, choices := range f.orEdges {
+		if choices.Size() < 2 {
+			continue
+		}
+		a := Ambiguity{Symbol: sn.Symbol, Span: sn.Extent}
+		for _, e := range choices.Values() {
+			a.Rules = append(a.Rules, e.(orEdge).toRHS.rule)
+		}
+		ambiguities = append(ambiguities, a)
+	}
+	sort.Slice(ambiguities, func(i, j int) bool {
+		a, b := ambiguities[i], ambiguities[j]
+		if a.Span.Start() != b.Span.Start() {
+			return a.Span.Start() < b.Span.Start()
+		}
+		if a.Span.End() != b.Span.End() {
+			return a.Span.End() > b.Span.End() // outer nodes first
+		}
+		return a.Symbol.Value < b.Symbol.Value
+	})
+	return ambiguities
+}
+
+// isAmbiguous returns true if a symbol node has more than one RHS.
+func (f *Forest) isAmbiguous(sn *SymbolNode) bool {
+	choices, ok := f.orEdges[sn]
+	return ok && choices.Size() > 1
+}
+
+// CountTrees returns the number of distinct parse trees of a forest. It returns
+// nil if the forest contains cycles, i.e. represents an infinite number of trees.
+func (f *Forest) CountTrees() *big.Int {
+	if f == nil || f.root == nil {
+		return big.NewInt(0)
+	}
+	e := newEnumeration(f)
+	n := e.count(f.root)
+	if e.cyclic {
+		return nil
+	}
+	return new(big.Int).Set(n)
+}
+
+// Tree returns parse tree number n of a forest, with 0 ≤ n < CountTrees(), as a
+// materialised tree. It returns nil if n is out of range or if the forest
+// contains cycles.
+func (f *Forest) Tree(n *big.Int) *Tree {
+	if f == nil || f.root == nil {
+		return nil
+	}
+	return newEnumeration(f).tree(n)
+}
+
+// TreeCursor returns a cursor at the root of parse tree number n of a forest,
+// with 0 ≤ n < CountTrees(). The cursor moves along the alternatives of tree n
+// only, thus traversals see the forest as this single tree. It returns nil if n
+// is out of range or if the forest contains cycles.
+func (f *Forest) TreeCursor(n *big.Int) *Cursor {
+	if f == nil || f.root == nil {
+		return nil
+	}
+	return newEnumeration(f).cursor(n)
+}
+
+// TreeIterator enumerates the parse trees of a forest lazily.
+//
+// Usage:
+//
+//     trees := forest.Trees()
+//     for trees.Next() {
+//         value := trees.Cursor().TopDown(listener, sppf.LtoR, sppf.Break)
+//         …
+//     }
+//
+type TreeIterator struct {
+	enum    *enumeration
+	total   *big.Int
+	next    *big.Int
+	current *big.Int // number of the current tree
+}
+
+// Trees returns an iterator over the parse trees of a forest. Forests with
+// cycles yield no trees.
+func (f *Forest) Trees() *TreeIterator {
+	it := &TreeIterator{next: big.NewInt(0), total: big.NewInt(0)}
+	if f != nil && f.root != nil {
+		it.enum = newEnumeration(f)
+		if n := it.enum.count(f.root); !it.enum.cyclic {
+			it.total = n
+		}
+	}
+	return it
+}
+
+// Next moves the iterator to the next parse tree. It returns false if all trees
+// have been enumerated.
+func (it *TreeIterator) Next() bool {
+	if it.next.Cmp(it.total) >= 0 {
+		it.current = nil
+		return false
+	}
+	it.current = new(big.Int).Set(it.next)
+	it.next.Add(it.next, one)
+	return true
+}
+
+// Cursor returns a cursor at the root of the current parse tree (see
+// TreeCursor). Every call returns a new cursor.
+func (it *TreeIterator) Cursor() *Cursor {
+	if it.current == nil {
+		return nil
+	}
+	return it.enum.cursor(it.current)
+}
+
+// Tree returns the current parse tree as a materialised tree.
+func (it *TreeIterator) Tree() *Tree {
+	if it.current == nil {
+		return nil
+	}
+	return it.enum.tree(it.current)
+}
+
+// --- Counting and unranking ------------------------------------------------
+
+type enumeration struct {
+	forest *Forest
+	counts map[*SymbolNode]*big.Int
+	onPath map[*SymbolNode]bool
+	cyclic bool
+}
+
+func newEnumeration(f *Forest) *enumeration {
+	return &enumeration{
+		forest: f,
+		counts: make(map[*SymbolNode]*big.Int),
+		onPath: make(map[*SymbolNode]bool),
+	}
+}
+
+var one = big.NewInt(1)
+
+// count returns the number of trees below a symbol node.
+func (e *enumeration) count(sn *SymbolNode) *big.Int {
+	if n, ok := e.counts[sn]; ok {
+		return n
+	}
+	choices, ok := e.forest.orEdges[sn]
+	if sn.Symbol.IsTerminal() || !ok {
+		return one
+	}
+	if e.onPath[sn] {
+		e.cyclic = true
+		return big.NewInt(0)
+	}
+	e.onPath[sn] = true
+	n := big.NewInt(0)
+	for _, c := range choices.Values() {
+		n.Add(n, e.countRHS(c.(orEdge).toRHS))
+	}
+	delete(e.onPath, sn)
+	e.counts[sn] = n
+	return n
+}
+
+// countRHS returns the number of trees below an RHS-node.
+func (e *enumeration) countRHS(rhs *rhsNode) *big.Int {
+	n := big.NewInt(1)
+	for _, child := range e.forest.childList(rhs) {
+		n.Mul(n, e.count(child))
+	}
+	return n
+}
+
+// inRange returns true if n is the number of a tree of an acyclic forest.
+func (e *enumeration) inRange(n *big.Int) bool {
+	total := e.count(e.forest.root)
+	return !e.cyclic && n.Sign() >= 0 && n.Cmp(total) < 0
+}
+
+// tree decodes tree number n into a materialised tree.
+func (e *enumeration) tree(n *big.Int) *Tree {
+	if !e.inRange(n) {
+		return nil
+	}
+	t := &Tree{}
+	t.Root = e.unrank(e.forest.root, n, nil, 0, t)
+	return t
+}
+
+// cursor creates a cursor on tree number n.
+func (e *enumeration) cursor(n *big.Int) *Cursor {
+	if !e.inRange(n) {
+		return nil
+	}
+	c := e.forest.SetCursor(nil, nil)
+	c.enum, c.rank = e, new(big.Int).Set(n)
+	return c
+}
+
+// choose decodes tree number n below a symbol node into the RHS selected and
+// the numbers of the trees below its children. It returns a nil RHS for
+// terminals and for n out of range.
+func (e *enumeration) choose(sn *SymbolNode, n *big.Int) (*rhsNode, []*big.Int) {
+	choices, ok := e.forest.orEdges[sn]
+	if sn.Symbol.IsTerminal() || !ok {
+		return nil, nil
+	}
+	n = new(big.Int).Set(n)
+	for _, c := range choices.Values() {
+		rhs := c.(orEdge).toRHS
+		if cnt := e.countRHS(rhs); n.Cmp(cnt) >= 0 {
+			n.Sub(n, cnt)
+			continue
+		}
+		children := e.forest.childList(rhs)
+		ranks := make([]*big.Int, len(children))
+		for i, child := range children {
+			ranks[i] = new(big.Int)
+			n.DivMod(n, e.count(child), ranks[i])
+		}
+		return rhs, ranks
+	}
+	return nil, nil
+}
+
+// unrank creates the node for tree number n below a symbol node, selecting the
+// alternatives for every occurrence of a shared node separately.
+func (e *enumeration) unrank(sn *SymbolNode, n *big.Int, parent *Node, index int, t *Tree) *Node {
+	node := newNode(sn, parent, index)
+	t.size++
+	rhs, ranks := e.choose(sn, n)
+	if rhs == nil {
+		return node
+	}
+	node.Rule = rhs.rule
+	children := e.forest.childList(rhs)
+	node.Children = make([]*Node, len(children))
+	for i, child := range children {
+		node.Children[i] = e.unrank(child, ranks[i], node, i, t)
+	}
+	return node
+}
+
+// childList returns the children of an RHS-node in order.
+func (f *Forest) childList(rhs *rhsNode) []*SymbolNode {
+	var children []*SymbolNode
+	iter, _ := f.children(rhs, LtoR)
+	for child, iter := iter(); child != nil; child, iter = iter() {
+		children = append(children, child)
+	}
+	return children
+}
@@ -0,0 +1,153 @@
+package sppf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/npillmayer/gorgo"
+)
+
+/*
+Concrete syntax trees.
+
+Parse trees do not contain the text the scanner has skipped between tokens,
+i.e. whitespace and comments. Formatters and refactoring tools, however, have
+to reproduce the input exactly. A CST is a parse tree with the source text of
+every token and the skipped text around it, called trivia. Tokens are required
+to report byte offsets into the source as their spans.
+
+Trivia is split between tokens, following the convention of many compilers: a
+token owns the trivia following it up to and including the end of its line as
+trailing trivia. The remaining trivia up to the next token is leading trivia of
+the next token. Trivia in front of the first token is leading trivia of the
+first token, trivia behind the last token is trailing trivia of the last token.
+Thus comments on lines of their own stick to the code below them, end-of-line
+comments stick to the code in front of them.
+
+Tokens fabricated by the parser during error recovery have no source text and
+are skipped.
+*/
+
+// CST is a concrete syntax tree, printing back to its source byte-for-byte.
+type CST struct {
+	Tree   *Tree
+	source []byte
+	leaves []*TokenLeaf
+	byNode map[*Node]*TokenLeaf
+}
+
+// TokenLeaf is a terminal of a concrete syntax tree, together with its source
+// text and surrounding trivia.
+type TokenLeaf struct {
+	Node     *Node       // terminal node in the tree
+	Token    gorgo.Token // token, as provided by the token retriever
+	Leading  []byte      // trivia in front of the token
+	Text     []byte      // source text of the token
+	Trailing []byte      // trivia behind the token
+}
+
+// NewCST creates a concrete syntax tree from a forest, using a pruner to select
+// a tree (see Materialize). Tokens for terminals are fetched from toks, with the
+// start position of a terminal's span as argument. source is the input of the
+// parse.
+func NewCST(forest *Forest, pruner Pruner, toks gorgo.TokenRetriever, source []byte) (*CST, error) {
+	tree, err := forest.Materialize(pruner)
+	if err != nil {
+		return nil, err
+	}
+	cst := &CST{
+		Tree:   tree,
+		source: source,
+		byNode: make(map[*Node]*TokenLeaf),
+	}
+	var pos uint64 // end of last token in source
+	for _, n := range tree.Leaves() {
+		if !n.IsTerminal() || n.Fabricated {
+			continue
+		}
+		t := toks(n.Span.Start())
+		if t == nil {
+			if n.Span.Len() == 0 { // e.g., end of input
+				continue
+			}
+			return nil, fmt.Errorf("cannot create CST: no token for %v", n)
+		}
+		span := t.Span()
+		if span.Start() < pos || span.End() < span.Start() || span.End() > uint64(len(source)) {
+			return nil, fmt.Errorf("cannot create CST: token %q at %v out of sequence", t.Lexeme(), span)
+		}
+		leaf := &TokenLeaf{Node: n, Token: t, Text: source[span.Start():span.End()]}
+		trivia := source[pos:span.Start()]
+		if len(cst.leaves) > 0 {
+			prev := cst.leaves[len(cst.leaves)-1]
+			eol := len(trivia)
+			if i := bytes.IndexByte(trivia, '\n'); i >= 0 {
+				eol = i + 1
+			}
+			prev.Trailing, trivia = trivia[:eol], trivia[eol:]
+		}
+		leaf.Leading = trivia
+		cst.leaves = append(cst.leaves, leaf)
+		cst.byNode[n] = leaf
+		pos = span.End()
+	}
+	if len(cst.leaves) > 0 {
+		cst.leaves[len(cst.leaves)-1].Trailing = source[pos:]
+	} else if len(source) > 0 { // input consists of trivia only
+		return nil, fmt.Errorf("cannot create CST: no tokens for non-empty source")
+	}
+	return cst, nil
+}
+
+// Leaves returns the token leaves of a CST in source order.
+func (cst *CST) Leaves() []*TokenLeaf {
+	return cst.leaves
+}
+
+// Leaf returns the token leaf for a terminal node of the tree, or nil.
+func (cst *CST) Leaf(n *Node) *TokenLeaf {
+	return cst.byNode[n]
+}
+
+// WriteTo prints the source of a CST, including all trivia.
+func (cst *CST) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for _, leaf := range cst.leaves {
+		for _, b := range [][]byte{leaf.Leading, leaf.Text, leaf.Trailing} {
+			n, err := w.Write(b)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// String returns the source of a CST, including all trivia.
+func (cst *CST) String() string {
+	var b bytes.Buffer
+	cst.WriteTo(&b)
+	return b.String()
+}
+
+// Text returns the source text of the sub-tree of a node, from its first to
+// its last token. It includes the trivia between the tokens, but neither the
+// leading trivia of the first nor the trailing trivia of the last token.
+func (cst *CST) Text(n *Node) []byte {
+	var first, last *TokenLeaf
+	n.Walk(func(ch *Node) bool {
+		if leaf, ok := cst.byNode[ch]; ok {
+			if first == nil {
+				first = leaf
+			}
+			last = leaf
+		}
+		return true
+	})
+	if first == nil {
+		return nil
+	}
+	return cst.source[first.Token.Span().Start():last.Token.Span().End()]
+}
@@ -25,10 +25,10 @@ the resulting parser will be of at least unbounded polynomial order in worst cas
 (Notation slightly modified by me to conform to notations elsewhere in my
 parser packages).
 
-Despite the shortcomings of the forest described by Grune & Jacobs, I won't
-implement Scott's improvements. For practical use, the worst case spatial complexity
-seems never to materialize. However, after gaining more insights in the future when
-using the SPPF for more complex real word scenarios I will be prepared to reconsider.
+The Earley parser (package earley) therefore builds Scott's binarised forest
+during recognition, which is bounded by O(n³). Clients, however, are served
+better by the forest described by Grune & Jacobs, with a node for every right
+hand side of a rule, and the parser converts the binarised forest to this form.
 
 
 License
@@ -42,7 +42,6 @@ Copyright © 2017–2021 Norbert Pillmayer <norbert@pillmayer.com>
 
 import (
 	"fmt"
-	"io"
 
 	"github.com/npillmayer/gorgo"
 	"github.com/npillmayer/gorgo/lr"
@@ -150,6 +149,20 @@ func (f *Forest) AddTerminal(t *lr.Symbol, pos uint64) *SymbolNode {
 	return f.addSymNode(t, pos, pos+1)
 }
 
+// AddTerminalSpan adds a node for a recognized terminal into the forest, which
+// covers a span of input positions. This is used for scannerless parsing, where
+// a terminal may match a sequence of characters (or no character at all).
+func (f *Forest) AddTerminalSpan(t *lr.Symbol, span gorgo.Span) *SymbolNode {
+	return f.addSymNode(t, span.Start(), span.End())
+}
+
+// AddSymbol returns the node for a symbol covering a span of input positions,
+// adding it to the forest if it is not present yet. Parsers may use it to refer
+// to a node before its derivations have been added, e.g. for cyclic derivations.
+func (f *Forest) AddSymbol(sym *lr.Symbol, span gorgo.Span) *SymbolNode {
+	return f.addSymNode(sym, span.Start(), span.End())
+}
+
 // SetRoot tells the parse forest which of the nodes will be the root node.
 // This is intended for cases where no top-level artificial symbol S' has
 // been wrapped around the grammar (usually done by the grammar analyzer).
@@ -162,8 +175,9 @@ func (f *Forest) SetRoot(symnode *SymbolNode) {
 // SymbolNode represents a node in the parse forest, referencing a
 // grammar symbol which has been reduced (Earley: completed).
 type SymbolNode struct { // this is [A (x…y)]
-	Symbol *lr.Symbol // A
-	Extent gorgo.Span // (x…y), i.e., positions in the input covered by this symbol
+	Symbol     *lr.Symbol // A
+	Extent     gorgo.Span // (x…y), i.e., positions in the input covered by this symbol
+	Fabricated bool       // terminal has been fabricated by the parser, not read from the input
 }
 
 func makeSym(symbol *lr.Symbol) *SymbolNode {
@@ -230,14 +244,17 @@ and for a reduced ε-production RHS=[ε (x)]
 	Σ(RHS) := Σ(x)
 
 Thus instead of storing [δ (x…y)] as RHS-nodes, we store [δ (x) Σ] as unique
-RHS-nodes.
+RHS-nodes. Σ serves as a quick test only: it does not cover the end yn of the
+RHS, and different RHSs may have the same signature. Nodes with equal
+signatures therefore have their children compared.
 */
 
 // Nodes [δ (x) Σ] in the parse forest.
 type rhsNode struct {
-	rule  int    // rule of which this RHS δ is from
-	start uint64 // start position in the input
-	sigma int32  // signature Σ of RHS children symbol nodes
+	rule     int           // rule of which this RHS δ is from
+	start    uint64        // start position in the input
+	sigma    int32         // signature Σ of RHS children symbol nodes
+	children []*SymbolNode // children symbol nodes, for resolving equal signatures
 }
 
 func makeRHS(rule int) *rhsNode {
@@ -281,7 +298,21 @@ func rhsSignature(rhs []*SymbolNode, start uint64) int32 {
 // FindRHSNode finds a (shared) node for a right hand side in the forest.
 func (f *Forest) findRHSNode(rule int, rhs []*SymbolNode, start uint64) *rhsNode {
 	signature := rhsSignature(rhs, start)
-	return f.rhsNodes.findRHS(start, rule, signature)
+	return f.rhsNodes.findRHS(start, rule, signature, rhs)
+}
+
+// sameChildren is true if an RHS-node has children with the symbols and spans
+// of rhs.
+func (rhs *rhsNode) sameChildren(children []*SymbolNode) bool {
+	if len(rhs.children) != len(children) {
+		return false
+	}
+	for i, sn := range children {
+		if rhs.children[i].Symbol != sn.Symbol || rhs.children[i].Extent != sn.Extent {
+			return false
+		}
+	}
+	return true
 }
 
 // addRHSNode adds a symbol node to the forest. Returns a reference to a rhsNode,
@@ -291,6 +322,7 @@ func (f *Forest) addRHSNode(rule int, rhs []*SymbolNode, start uint64) *rhsNode
 	if node == nil {
 		signature := rhsSignature(rhs, start)
 		node = makeRHS(rule).identified(start, signature)
+		node.children = append([]*SymbolNode(nil), rhs...)
 		f.rhsNodes.Add(start, uint64(rule), node)
 	}
 	return node
@@ -428,11 +460,11 @@ func (t searchTree) findSymbol(from, to uint64, sym *lr.Symbol) *SymbolNode {
 	return node.(*SymbolNode)
 }
 
-// find an RHS-node for (position, rule-no, signature).
-func (t searchTree) findRHS(start uint64, rule int, signature int32) *rhsNode {
+// find an RHS-node for (position, rule-no, signature) with the given children.
+func (t searchTree) findRHS(start uint64, rule int, signature int32, children []*SymbolNode) *rhsNode {
 	node := t.find(start, uint64(rule), func(el interface{}) bool {
 		rhs := el.(*rhsNode)
-		return rhs.sigma == signature
+		return rhs.sigma == signature && rhs.sameChildren(children)
 	})
 	if node == nil {
 		return nil
@@ -464,70 +496,6 @@ func (t searchTree) All() *iteratable.Set {
 	return values
 }
 
-// --- GraphViz --------------------------------------------------------------
-
-// ToGraphViz exports an SPPF to an io.Writer in GrahpViz DOT format.
-func ToGraphViz(forest *Forest, w io.Writer) {
-	io.WriteString(w, `digraph G {
-{ graph [fontname="Helvetica"];
-  node [fontname="Helvetica",shape=box,fontsize=10];
-  edge [fontname="Helvetica",fontsize=9];
-`)
-	nodes := forest.rhsNodes.All()
-	nodes.Sort(func(x, y interface{}) bool {
-		return x.(*rhsNode).rule < y.(*rhsNode).rule
-	})
-	nodes.IterateOnce()
-	for nodes.Next() {
-		node := nodes.Item().(*rhsNode)
-		io.WriteString(w, fmt.Sprintf("\"rule %d (%d)\" [style=rounded,color=\"#404040\"]\n",
-			node.rule, node.sigma))
-	}
-	nodes = forest.symbolNodes.All()
-	nodes.Sort(func(x, y interface{}) bool {
-		return x.(*SymbolNode).Extent.Start() < y.(*SymbolNode).Extent.Start()
-	})
-	nodes.IterateOnce()
-	for nodes.Next() {
-		node := nodes.Item().(*SymbolNode)
-		if node.Symbol.IsTerminal() {
-			io.WriteString(w, fmt.Sprintf("\"%s\" [fillcolor=grey90,style=filled]\n", node.String()))
-		} else {
-			io.WriteString(w, fmt.Sprintf("\"%s\" []\n", node.String()))
-		}
-	}
-	io.WriteString(w, "}\n")
-	for _, set := range forest.orEdges {
-		oredges := set.Values()
-		for _, e := range oredges {
-			edge := e.(orEdge)
-			io.WriteString(w, fmt.Sprintf("\"%s\" -> \"rule %d (%d)\" [style=dashed]\n",
-				edge.fromSym, edge.toRHS.rule, edge.toRHS.sigma))
-		}
-	}
-	for _, set := range forest.andEdges {
-		set.Sort(func(x, y interface{}) bool {
-			return x.(andEdge).sequence < y.(andEdge).sequence
-		})
-		set.IterateOnce()
-		for set.Next() {
-			edge := set.Item().(andEdge)
-			io.WriteString(w, fmt.Sprintf("\"rule %d (%d)\" -> \"%s\" [label=%d]\n", edge.fromRHS.rule,
-				edge.fromRHS.sigma, edge.toSym, edge.sequence))
-		}
-	}
-	io.WriteString(w, "{ rank=max;\n")
-	// { rank=max; T1; T2; T3 } => all terminals at bottom row
-	nodes.IterateOnce()
-	for nodes.Next() {
-		node := nodes.Item().(*SymbolNode)
-		if node.Symbol.IsTerminal() {
-			io.WriteString(w, fmt.Sprintf("\"%s\";", node.String()))
-		}
-	}
-	io.WriteString(w, "\n}\n}\n")
-}
-
 // ---------------------------------------------------------------------------
 
 func abs(n int) int64 {
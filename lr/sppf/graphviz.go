@@ -0,0 +1,214 @@
+package sppf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/npillmayer/gorgo"
+)
+
+/*
+GraphViz output.
+
+Forests are drawn top-down from the root, with symbol nodes as boxes and
+RHS-nodes as rounded boxes, labeled with their rule numbers. Or-edges are
+dashed, and-edges are labeled with the sequence number of the child. Terminals
+are drawn in grey at the bottom row.
+
+Large forests quickly become unreadable. Options help to focus on the parts of
+interest:
+
+    sppf.ToGraphViz(forest, w,
+        sppf.HighlightAmbiguities(),   // colour ambiguous nodes and their alternatives
+        sppf.CollapseChains(),         // omit RHS-nodes of unambiguous nodes, merge A ➞ B ➞ C
+        sppf.SpanWindow(120, 140),     // draw only nodes overlapping input positions 120…140
+    )
+*/
+
+// GraphOption is a type for options of GraphViz output.
+type GraphOption func(*graphConfig)
+
+type graphConfig struct {
+	highlight bool
+	collapse  bool
+	window    *gorgo.Span
+}
+
+// HighlightAmbiguities colours ambiguous symbol nodes and gives each of their
+// alternative RHS-nodes its own colour.
+func HighlightAmbiguities() GraphOption {
+	return func(c *graphConfig) {
+		c.highlight = true
+	}
+}
+
+// CollapseChains draws unambiguous symbol nodes without RHS-nodes, with edges
+// leading to their children directly. Chains of unit derivations A ➞ B ➞ C
+// covering the same span are merged into a single node.
+func CollapseChains() GraphOption {
+	return func(c *graphConfig) {
+		c.collapse = true
+	}
+}
+
+// SpanWindow restricts output to symbol nodes overlapping input positions
+// from…to. Edges to nodes outside the window are omitted.
+func SpanWindow(from, to uint64) GraphOption {
+	return func(c *graphConfig) {
+		c.window = &gorgo.Span{from, to}
+	}
+}
+
+// colours for alternatives of ambiguous nodes
+var alternativeColours = []string{"#d62728", "#1f77b4", "#2ca02c", "#9467bd", "#ff7f0e", "#8c564b"}
+
+// ToGraphViz exports an SPPF to an io.Writer in GrahpViz DOT format.
+func ToGraphViz(forest *Forest, w io.Writer, opts ...GraphOption) {
+	g := &graphWriter{
+		forest: forest,
+		ids:    make(map[*SymbolNode]string),
+	}
+	for _, opt := range opts {
+		opt(&g.config)
+	}
+	if forest != nil && forest.root != nil && g.inWindow(forest.root) {
+		g.symbol(forest.root)
+	}
+	io.WriteString(w, `digraph G {
+{ graph [fontname="Helvetica"];
+  node [fontname="Helvetica",shape=box,fontsize=10];
+  edge [fontname="Helvetica",fontsize=9];
+`)
+	w.Write(g.nodes.Bytes())
+	io.WriteString(w, "}\n")
+	w.Write(g.edges.Bytes())
+	io.WriteString(w, "{ rank=max;\n")
+	// { rank=max; T1; T2; T3 } => all terminals at bottom row
+	io.WriteString(w, strings.Join(g.terminals, ";"))
+	io.WriteString(w, "\n}\n}\n")
+}
+
+// ToSVG renders an SPPF as SVG to an io.Writer, using the same options as
+// ToGraphViz. It requires the GraphViz command `dot` to be installed.
+func ToSVG(forest *Forest, w io.Writer, opts ...GraphOption) error {
+	dot, err := exec.LookPath("dot")
+	if err != nil {
+		return fmt.Errorf("cannot render SVG: %w", err)
+	}
+	var in, errout bytes.Buffer
+	ToGraphViz(forest, &in, opts...)
+	cmd := exec.Command(dot, "-Tsvg")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = &in, w, &errout
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("cannot render SVG: %v: %s", err, errout.String())
+	}
+	return nil
+}
+
+type graphWriter struct {
+	forest    *Forest
+	config    graphConfig
+	ids       map[*SymbolNode]string
+	rhsCount  int
+	nodes     bytes.Buffer
+	edges     bytes.Buffer
+	terminals []string
+}
+
+func (g *graphWriter) inWindow(sn *SymbolNode) bool {
+	if g.config.window == nil {
+		return true
+	}
+	win := g.config.window
+	if sn.Extent.Len() == 0 {
+		return sn.Extent.Start() >= win.Start() && sn.Extent.Start() <= win.End()
+	}
+	return sn.Extent.Start() < win.End() && sn.Extent.End() > win.Start()
+}
+
+// symbol writes a symbol node, its RHS-nodes and its descendants, and returns
+// its ID.
+func (g *graphWriter) symbol(sn *SymbolNode) string {
+	if id, ok := g.ids[sn]; ok {
+		return id
+	}
+	id := fmt.Sprintf("s%d", len(g.ids))
+	g.ids[sn] = id
+	names := []string{sn.Symbol.Name}
+	end := sn
+	if g.config.collapse {
+		for {
+			alts := g.alternatives(end)
+			if len(alts) != 1 || len(alts[0].children) != 1 {
+				break
+			}
+			child := g.forest.childList(alts[0])[0]
+			if child.Symbol.IsTerminal() || child.Extent != end.Extent || g.ids[child] != "" {
+				break
+			}
+			g.ids[child] = id
+			names = append(names, child.Symbol.Name)
+			end = child
+		}
+	}
+	label := fmt.Sprintf("%s %s", strings.Join(names, " ➞ "), sn.Extent.String())
+	ambiguous := g.forest.isAmbiguous(end)
+	switch {
+	case sn.Symbol.IsTerminal():
+		fmt.Fprintf(&g.nodes, "%s [label=%q,fillcolor=grey90,style=filled]\n", id, label)
+		g.terminals = append(g.terminals, id)
+		return id
+	case ambiguous && g.config.highlight:
+		fmt.Fprintf(&g.nodes, "%s [label=%q,color=\"#d62728\",fillcolor=\"#fde0e0\",style=filled,penwidth=2]\n",
+			id, label)
+	default:
+		fmt.Fprintf(&g.nodes, "%s [label=%q]\n", id, label)
+	}
+	alts := g.alternatives(end)
+	if g.config.collapse && len(alts) == 1 { // edges lead to children directly
+		g.children(id, alts[0])
+		return id
+	}
+	rids := make([]string, len(alts))
+	for i, rhs := range alts { // write all alternatives before descending
+		rids[i] = fmt.Sprintf("r%d", g.rhsCount)
+		g.rhsCount++
+		colour := "#404040"
+		if ambiguous && g.config.highlight {
+			colour = alternativeColours[i%len(alternativeColours)]
+		}
+		fmt.Fprintf(&g.nodes, "%s [label=\"rule %d\",style=rounded,color=%q]\n", rids[i], rhs.rule, colour)
+		fmt.Fprintf(&g.edges, "%s -> %s [style=dashed,color=%q]\n", id, rids[i], colour)
+	}
+	for i, rhs := range alts {
+		g.children(rids[i], rhs)
+	}
+	return id
+}
+
+// children writes and-edges from a node to the children of an RHS-node.
+func (g *graphWriter) children(from string, rhs *rhsNode) {
+	if len(rhs.children) == 0 { // ε-production
+		return
+	}
+	for seq, child := range g.forest.childList(rhs) {
+		if g.inWindow(child) {
+			fmt.Fprintf(&g.edges, "%s -> %s [label=%d]\n", from, g.symbol(child), seq)
+		}
+	}
+}
+
+// alternatives returns the RHS-nodes of a symbol node, sorted by rule.
+func (g *graphWriter) alternatives(sn *SymbolNode) []*rhsNode {
+	var alts []*rhsNode
+	if choices, ok := g.forest.orEdges[sn]; ok {
+		for _, c := range choices.Values() {
+			alts = append(alts, c.(orEdge).toRHS)
+		}
+	}
+	sortAlternatives(alts)
+	return alts
+}
@@ -0,0 +1,308 @@
+package sppf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/npillmayer/gorgo"
+	"github.com/npillmayer/gorgo/lr"
+)
+
+/*
+JSON serialisation.
+
+A forest is serialised as lists of nodes and edges, referencing nodes by
+number:
+
+    {
+      "version": 1,
+      "root": 4,
+      "symbols": [
+        { "id": 0, "name": "S'", "span": [0, 1] },
+        …
+        { "id": 3, "name": "a", "terminal": true, "value": -2, "span": [0, 1], "token": 0 }
+      ],
+      "rhs": [ { "id": 0, "rule": 0, "start": 0 }, … ],
+      "or":  [ { "from": 0, "to": 0 }, … ],
+      "and": [ { "from": 0, "seq": 0, "to": 1 }, … ]
+    }
+
+Symbol nodes are numbered in document order: by start position, enclosing
+nodes first, and nodes closer to the root first for equal spans. RHS-nodes are
+numbered in the order of the symbol nodes deriving them, alternatives of a
+symbol node ordered by rule number and by the spans of their children. The
+output for a forest is therefore stable and suitable for golden tests.
+
+Terminals carry their token value and a token reference, which is the input
+position to hand to a gorgo.TokenRetriever. RHS-nodes for ε-productions have
+no and-edges. Non-terminals are identified by name only, as their numeric IDs
+depend on the order of grammar creation.
+*/
+
+// ForestData is the serialisable form of a forest, as produced by Export.
+type ForestData struct {
+	Version int           `json:"version"`
+	Root    int           `json:"root"` // ID of the root symbol node, -1 for an empty forest
+	Symbols []SymbolData  `json:"symbols"`
+	RHS     []RHSData     `json:"rhs"`
+	Or      []OrEdgeData  `json:"or"`
+	And     []AndEdgeData `json:"and"`
+}
+
+// SymbolData is the serialisable form of a symbol node.
+type SymbolData struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	Terminal   bool      `json:"terminal,omitempty"`
+	Value      int       `json:"value,omitempty"`      // token value of a terminal
+	Span       [2]uint64 `json:"span"`                 // start and end position
+	Token      *uint64   `json:"token,omitempty"`      // token reference of a terminal
+	Fabricated bool      `json:"fabricated,omitempty"` // terminal has been fabricated by the parser
+}
+
+// RHSData is the serialisable form of an RHS-node.
+type RHSData struct {
+	ID    int    `json:"id"`
+	Rule  int    `json:"rule"`
+	Start uint64 `json:"start"`
+}
+
+// OrEdgeData is the serialisable form of an or-edge from a symbol node to an
+// RHS-node.
+type OrEdgeData struct {
+	From int `json:"from"` // symbol node
+	To   int `json:"to"`   // RHS-node
+}
+
+// AndEdgeData is the serialisable form of an and-edge from an RHS-node to its
+// child number Seq.
+type AndEdgeData struct {
+	From int  `json:"from"` // RHS-node
+	Seq  uint `json:"seq"`
+	To   int  `json:"to"` // symbol node
+}
+
+// jsonVersion is the version of the serialisation format.
+const jsonVersion = 1
+
+// Export returns the serialisable form of a forest.
+func (f *Forest) Export() *ForestData {
+	data := &ForestData{Version: jsonVersion, Root: -1}
+	if f == nil {
+		return data
+	}
+	epsilons := make(map[*SymbolNode]bool) // ε-children of ε-productions are implicit
+	for rhs, edges := range f.andEdges {
+		if len(rhs.children) == 0 {
+			for _, e := range edges.Values() {
+				epsilons[e.(andEdge).toSym] = true
+			}
+		}
+	}
+	var symbols []*SymbolNode
+	f.symbolNodes.All().Each(func(el interface{}) {
+		if sn := el.(*SymbolNode); !epsilons[sn] {
+			symbols = append(symbols, sn)
+		}
+	})
+	depth := f.depths()
+	sort.SliceStable(symbols, func(i, j int) bool {
+		a, b := symbols[i], symbols[j]
+		if a.Extent != b.Extent || depth[a] == depth[b] {
+			return documentOrder(a, b)
+		}
+		return depth[a] < depth[b] // nodes for chain rules A ➞ B
+	})
+	symIDs := make(map[*SymbolNode]int, len(symbols))
+	for i, sn := range symbols {
+		symIDs[sn] = i
+		sd := SymbolData{
+			ID:   i,
+			Name: sn.Symbol.Name,
+			Span: [2]uint64{sn.Extent.Start(), sn.Extent.End()},
+		}
+		if sn.Symbol.IsTerminal() {
+			pos := sn.Extent.Start()
+			sd.Terminal, sd.Value, sd.Token = true, sn.Symbol.Value, &pos
+			sd.Fabricated = sn.Fabricated
+		}
+		data.Symbols = append(data.Symbols, sd)
+	}
+	if id, ok := symIDs[f.root]; ok {
+		data.Root = id
+	}
+	for _, sn := range symbols {
+		choices, ok := f.orEdges[sn]
+		if !ok {
+			continue
+		}
+		var alts []*rhsNode
+		for _, c := range choices.Values() {
+			alts = append(alts, c.(orEdge).toRHS)
+		}
+		sortAlternatives(alts)
+		for _, rhs := range alts {
+			id := len(data.RHS)
+			data.RHS = append(data.RHS, RHSData{ID: id, Rule: rhs.rule, Start: rhs.start})
+			data.Or = append(data.Or, OrEdgeData{From: symIDs[sn], To: id})
+			if len(rhs.children) == 0 {
+				continue
+			}
+			for seq, child := range f.childList(rhs) {
+				data.And = append(data.And, AndEdgeData{From: id, Seq: uint(seq), To: symIDs[child]})
+			}
+		}
+	}
+	return data
+}
+
+// documentOrder sorts symbol nodes by start position, enclosing nodes first.
+func documentOrder(a, b *SymbolNode) bool {
+	if a.Extent.Start() != b.Extent.Start() {
+		return a.Extent.Start() < b.Extent.Start()
+	}
+	if a.Extent.End() != b.Extent.End() {
+		return a.Extent.End() > b.Extent.End()
+	}
+	if a.Symbol.IsTerminal() != b.Symbol.IsTerminal() {
+		return !a.Symbol.IsTerminal()
+	}
+	return a.Symbol.Name < b.Symbol.Name
+}
+
+// depths returns the minimum distance from the root for every node reachable.
+func (f *Forest) depths() map[*SymbolNode]int {
+	depth := make(map[*SymbolNode]int)
+	if f.root == nil {
+		return depth
+	}
+	depth[f.root] = 0
+	queue := []*SymbolNode{f.root}
+	for len(queue) > 0 {
+		sn := queue[0]
+		queue = queue[1:]
+		if choices, ok := f.orEdges[sn]; ok {
+			for _, c := range choices.Values() {
+				for _, child := range f.childList(c.(orEdge).toRHS) {
+					if _, seen := depth[child]; !seen {
+						depth[child] = depth[sn] + 1
+						queue = append(queue, child)
+					}
+				}
+			}
+		}
+	}
+	return depth
+}
+
+// sortAlternatives sorts RHS-nodes by rule, then by the ends of their children.
+func sortAlternatives(alts []*rhsNode) {
+	sort.Slice(alts, func(i, j int) bool {
+		a, b := alts[i], alts[j]
+		if a.rule != b.rule {
+			return a.rule < b.rule
+		}
+		for k := 0; k < len(a.children) && k < len(b.children); k++ {
+			if c := compareEnds(a.children[k], b.children[k]); c != 0 {
+				return c < 0
+			}
+		}
+		return len(a.children) < len(b.children)
+	})
+}
+
+// ToJSON exports an SPPF to an io.Writer in JSON format.
+func ToJSON(forest *Forest, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(forest.Export())
+}
+
+// FromJSON imports an SPPF in JSON format, as written by ToJSON. The grammar
+// has to be the one the forest has been created for.
+func FromJSON(r io.Reader, g *lr.Grammar) (*Forest, error) {
+	data := &ForestData{}
+	if err := json.NewDecoder(r).Decode(data); err != nil {
+		return nil, fmt.Errorf("cannot read forest: %w", err)
+	}
+	return Import(data, g)
+}
+
+// Import re-creates a forest from its serialisable form.
+func Import(data *ForestData, g *lr.Grammar) (*Forest, error) {
+	if data.Version != jsonVersion {
+		return nil, fmt.Errorf("cannot import forest of version %d", data.Version)
+	}
+	nonterms := make(map[string]*lr.Symbol)
+	g.EachNonTerminal(func(A *lr.Symbol) interface{} {
+		nonterms[A.Name] = A
+		return nil
+	})
+	f := NewForest()
+	symbols := make([]*SymbolNode, len(data.Symbols))
+	for i, sd := range data.Symbols {
+		if sd.ID != i {
+			return nil, fmt.Errorf("symbol node #%d has ID %d", i, sd.ID)
+		}
+		var A *lr.Symbol
+		if sd.Terminal {
+			A = g.Terminal(sd.Value)
+		} else {
+			A = nonterms[sd.Name]
+		}
+		if A == nil || A.Name != sd.Name {
+			return nil, fmt.Errorf("symbol %q not found in grammar %s", sd.Name, g.Name)
+		}
+		symbols[i] = f.AddTerminalSpan(A, gorgo.Span{sd.Span[0], sd.Span[1]})
+		symbols[i].Fabricated = sd.Fabricated
+	}
+	children := make([][]*SymbolNode, len(data.RHS))
+	rhsLen := make([]uint, len(data.RHS)) // children may omit trailing symbols, e.g. #eof
+	for i, rd := range data.RHS {
+		rule := g.Rule(rd.Rule)
+		if rule == nil {
+			return nil, fmt.Errorf("rule %d of RHS-node %d not found in grammar %s", rd.Rule, i, g.Name)
+		}
+		rhsLen[i] = uint(len(rule.RHS()))
+	}
+	for _, e := range data.And {
+		if e.From < 0 || e.From >= len(data.RHS) || e.To < 0 || e.To >= len(symbols) {
+			return nil, fmt.Errorf("and-edge %d → %d references unknown node", e.From, e.To)
+		}
+		if e.Seq >= rhsLen[e.From] {
+			return nil, fmt.Errorf("and-edge %d → %d has sequence %d beyond RHS of rule %d",
+				e.From, e.To, e.Seq, data.RHS[e.From].Rule)
+		}
+		for uint(len(children[e.From])) <= e.Seq {
+			children[e.From] = append(children[e.From], nil)
+		}
+		children[e.From][e.Seq] = symbols[e.To]
+	}
+	for _, e := range data.Or {
+		if e.From < 0 || e.From >= len(symbols) || e.To < 0 || e.To >= len(data.RHS) {
+			return nil, fmt.Errorf("or-edge %d → %d references unknown node", e.From, e.To)
+		}
+		sn, rhs := symbols[e.From], data.RHS[e.To]
+		if len(children[e.To]) == 0 {
+			f.AddEpsilonReduction(sn.Symbol, rhs.Rule, rhs.Start)
+			continue
+		}
+		for seq, child := range children[e.To] {
+			if child == nil {
+				return nil, fmt.Errorf("RHS-node %d is missing child %d", e.To, seq)
+			}
+		}
+		if f.AddReduction(sn.Symbol, rhs.Rule, children[e.To]) != sn {
+			return nil, fmt.Errorf("RHS-node %d does not span %v", e.To, sn)
+		}
+	}
+	if data.Root >= len(symbols) || data.Root < -1 {
+		return nil, fmt.Errorf("root %d references unknown node", data.Root)
+	}
+	if data.Root >= 0 {
+		f.SetRoot(symbols[data.Root])
+	}
+	return f, nil
+}
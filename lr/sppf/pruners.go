@@ -0,0 +1,398 @@
+package sppf
+
+import (
+	"sort"
+
+	"github.com/npillmayer/gorgo/lr"
+)
+
+/*
+Standard pruners.
+
+Whenever a cursor reaches an ambiguous symbol node, its pruner is handed the
+competing RHS-nodes (alternatives) and returns the ones to keep. The cursor then
+selects the first alternative left. Pruners fall into two groups:
+
+- Preferences select the "best" alternatives by some criterion: rule numbers,
+  rule priorities, associativity of operators or longest match. They never
+  prune every alternative.
+
+- Restrictions, following SDF (https://www.metaborg.org/en/latest/source/langdev/meta/lang/sdf3/),
+  remove alternatives which are not allowed: reject productions and follow
+  restrictions. A node with a reject production or violating a follow
+  restriction is dead, and so is every alternative having a dead child.
+  Restrictions may prune every alternative.
+
+Pruners are composed with Chain, applying them in order. Usually restrictions
+come first, followed by preferences from the most to the least specific:
+
+    pruner := sppf.Chain(
+        sppf.Reject(keywordRule),
+        sppf.RulePriorities(map[int]int{mulRule: 2, addRule: 1}),
+        sppf.LeftAssociative(addRule, subRule),
+        sppf.PreferLowerRule,
+    )
+
+Restrictions cache results per forest and must not be shared between cursors
+traversing forests concurrently.
+
+Clients may write pruners of their own with PrunerFunc.
+*/
+
+// Chain returns a pruner applying pruners in order, each one operating on the
+// alternatives left by its predecessor.
+func Chain(pruners ...Pruner) Pruner {
+	return chain(pruners)
+}
+
+type chain []Pruner
+
+func (c chain) prune(f *Forest, sym *SymbolNode, alts []*rhsNode) []*rhsNode {
+	for _, p := range c {
+		if alts = p.prune(f, sym, alts); len(alts) == 0 {
+			break
+		}
+	}
+	return alts
+}
+
+// --- Preferences -----------------------------------------------------------
+
+// preference is a pruner keeping the alternatives which compare best. cmp
+// returns a negative number if its first argument is better than the second.
+type preference func(f *Forest, a, b *rhsNode) int
+
+func (p preference) prune(f *Forest, sym *SymbolNode, alts []*rhsNode) []*rhsNode {
+	return best(alts, func(a, b *rhsNode) int { return p(f, a, b) })
+}
+
+// best returns the alternatives which compare best, preserving their order.
+func best(alts []*rhsNode, cmp func(a, b *rhsNode) int) []*rhsNode {
+	if len(alts) < 2 {
+		return alts
+	}
+	top := alts[0]
+	for _, alt := range alts[1:] {
+		if cmp(alt, top) < 0 {
+			top = alt
+		}
+	}
+	var kept []*rhsNode
+	for _, alt := range alts {
+		if cmp(alt, top) == 0 {
+			kept = append(kept, alt)
+		}
+	}
+	return kept
+}
+
+// PreferLowerRule keeps the alternatives with the lowest rule number. For
+// grammars listing the preferred productions first, this is a sensible default.
+var PreferLowerRule Pruner = preference(func(f *Forest, a, b *rhsNode) int {
+	return a.rule - b.rule
+})
+
+// PreferHigherRule keeps the alternatives with the highest rule number.
+var PreferHigherRule Pruner = preference(func(f *Forest, a, b *rhsNode) int {
+	return b.rule - a.rule
+})
+
+// RulePriorities returns a pruner keeping the alternatives whose rules have
+// the highest priority. Rules missing from prio have priority 0.
+func RulePriorities(prio map[int]int) Pruner {
+	return preference(func(f *Forest, a, b *rhsNode) int {
+		return prio[b.rule] - prio[a.rule]
+	})
+}
+
+// LeftAssociative returns a pruner for operator rules of the same precedence,
+// e.g. E ➞ E + E and E ➞ E - E. Between alternatives derived by these rules,
+// it keeps the ones with the longest leftmost child, i.e. it groups (1+2)+3.
+// Alternatives derived by other rules are kept.
+func LeftAssociative(rules ...int) Pruner {
+	return associativity(rules, func(a, b *rhsNode) int {
+		return compareEnds(b.children[0], a.children[0])
+	})
+}
+
+// RightAssociative returns a pruner for operator rules of the same precedence,
+// e.g. E ➞ E ^ E. Between alternatives derived by these rules, it keeps the
+// ones with the shortest leftmost child, i.e. it groups 1^(2^3).
+// Alternatives derived by other rules are kept.
+func RightAssociative(rules ...int) Pruner {
+	return associativity(rules, func(a, b *rhsNode) int {
+		return compareEnds(a.children[0], b.children[0])
+	})
+}
+
+func associativity(rules []int, cmp func(a, b *rhsNode) int) Pruner {
+	set := ruleSet(rules)
+	return prunerFunc(func(f *Forest, sym *SymbolNode, alts []*rhsNode) []*rhsNode {
+		var ops, kept []*rhsNode
+		for _, alt := range alts {
+			if set[alt.rule] && len(alt.children) > 0 {
+				ops = append(ops, alt)
+			}
+		}
+		if len(ops) < 2 {
+			return alts
+		}
+		ops = best(ops, cmp)
+		for _, alt := range alts {
+			if !set[alt.rule] || len(alt.children) == 0 || contains(ops, alt) {
+				kept = append(kept, alt)
+			}
+		}
+		return kept
+	})
+}
+
+// LongestMatch keeps the alternatives whose children, from left to right,
+// cover the longest spans of input. This resolves, e.g., the "dangling else"
+// by attaching an else to the innermost if.
+var LongestMatch Pruner = preference(func(f *Forest, a, b *rhsNode) int {
+	for i := 0; i < len(a.children) && i < len(b.children); i++ {
+		if c := compareEnds(b.children[i], a.children[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+})
+
+func compareEnds(a, b *SymbolNode) int {
+	switch {
+	case a.Extent.End() < b.Extent.End():
+		return -1
+	case a.Extent.End() > b.Extent.End():
+		return 1
+	}
+	return 0
+}
+
+// --- Restrictions ----------------------------------------------------------
+
+// Reject returns a pruner for SDF-style reject productions. A symbol node
+// derivable by one of the rules is rejected, even if there are other
+// derivations for it, and alternatives containing rejected nodes are pruned.
+// A typical use is to reject keywords as identifiers:
+//
+//     Identifier ➞ letters
+//     Identifier ➞ "if"        // reject
+//
+func Reject(rules ...int) Pruner {
+	return &restriction{rejects: ruleSet(rules)}
+}
+
+// FollowRestriction returns a pruner for SDF-style follow restrictions: a node
+// for symbol A must not be followed by one of the terminals given by their token
+// values. Alternatives containing such nodes are pruned. A typical use is to
+// require identifiers to be followed by a non-letter, enforcing longest match
+// for lexical syntax.
+func FollowRestriction(A *lr.Symbol, followers ...int) Pruner {
+	r := &restriction{follows: make(map[int]bool)}
+	r.sym = A.Value
+	for _, t := range followers {
+		r.follows[t] = true
+	}
+	return r
+}
+
+// restriction prunes alternatives with dead nodes.
+type restriction struct {
+	rejects   map[int]bool // reject productions
+	sym       int          // symbol with follow restriction
+	follows   map[int]bool // terminals not allowed to follow sym
+	forest    *Forest      // forest the caches are valid for
+	dead      map[*SymbolNode]bool
+	terminals []*SymbolNode // input tokens, one per start position, sorted by position
+}
+
+func (r *restriction) prune(f *Forest, sym *SymbolNode, alts []*rhsNode) []*rhsNode {
+	r.useForest(f)
+	if r.rejected(sym) {
+		return nil
+	}
+	var kept []*rhsNode
+	for _, alt := range alts {
+		if !r.deadAlternative(alt) {
+			kept = append(kept, alt)
+		}
+	}
+	return kept
+}
+
+func (r *restriction) useForest(f *Forest) {
+	if r.forest == f {
+		return
+	}
+	r.forest = f
+	r.dead = make(map[*SymbolNode]bool)
+	r.terminals = nil
+	if r.follows != nil {
+		r.terminals = inputTokens(f)
+	}
+}
+
+// inputTokens collects the terminals of a forest which have been read from the
+// input, i.e. which are part of a derivation of the root, are not empty and have
+// not been fabricated by the parser. If more than one terminal starts at a
+// position, e.g. for scannerless parsing, the longest one is taken (ties are
+// broken by token value). The result is sorted by start position.
+func inputTokens(f *Forest) []*SymbolNode {
+	tokens := make(map[uint64]*SymbolNode)
+	seen := make(map[*SymbolNode]bool)
+	var collect func(sn *SymbolNode)
+	collect = func(sn *SymbolNode) {
+		if sn == nil || seen[sn] {
+			return
+		}
+		seen[sn] = true
+		if sn.Symbol.IsTerminal() {
+			if sn.Extent.Len() == 0 || sn.Fabricated {
+				return
+			}
+			pos := sn.Extent.Start()
+			if t, ok := tokens[pos]; !ok || sn.Extent.End() > t.Extent.End() ||
+				sn.Extent.End() == t.Extent.End() && sn.Symbol.Value < t.Symbol.Value {
+				tokens[pos] = sn
+			}
+			return
+		}
+		if choices, ok := f.orEdges[sn]; ok {
+			for _, c := range choices.Values() {
+				for _, child := range f.childList(c.(orEdge).toRHS) {
+					collect(child)
+				}
+			}
+		}
+	}
+	collect(f.root)
+	terminals := make([]*SymbolNode, 0, len(tokens))
+	for _, t := range tokens {
+		terminals = append(terminals, t)
+	}
+	sort.Slice(terminals, func(i, j int) bool {
+		return terminals[i].Extent.Start() < terminals[j].Extent.Start()
+	})
+	return terminals
+}
+
+// isDead returns true if a node is rejected, violates the follow restriction,
+// or has no derivation without dead nodes.
+func (r *restriction) isDead(sn *SymbolNode) bool {
+	if dead, ok := r.dead[sn]; ok {
+		return dead
+	}
+	r.dead[sn] = false // guard against cycles
+	dead := r.rejected(sn)
+	if !dead && !sn.Symbol.IsTerminal() {
+		if choices, ok := r.forest.orEdges[sn]; ok {
+			dead = true
+			for _, c := range choices.Values() {
+				if !r.deadAlternative(c.(orEdge).toRHS) {
+					dead = false
+					break
+				}
+			}
+		}
+	}
+	r.dead[sn] = dead
+	return dead
+}
+
+func (r *restriction) deadAlternative(rhs *rhsNode) bool {
+	for _, child := range r.forest.childList(rhs) {
+		if r.isDead(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejected returns true if a node is derivable by a reject production or is
+// followed by a restricted terminal.
+func (r *restriction) rejected(sn *SymbolNode) bool {
+	if r.follows != nil && sn.Symbol.Value == r.sym {
+		if next := r.follower(sn); next != nil && r.follows[next.Symbol.Value] {
+			return true
+		}
+	}
+	if r.rejects != nil {
+		if choices, ok := r.forest.orEdges[sn]; ok {
+			for _, c := range choices.Values() {
+				if r.rejects[c.(orEdge).toRHS.rule] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// follower returns the first terminal following a node in the input, if any.
+func (r *restriction) follower(sn *SymbolNode) *SymbolNode {
+	end := sn.Extent.End()
+	i := sort.Search(len(r.terminals), func(i int) bool {
+		return r.terminals[i].Extent.Start() >= end
+	})
+	if i < len(r.terminals) {
+		return r.terminals[i]
+	}
+	return nil
+}
+
+// --- Client pruners ---------------------------------------------------------
+
+// Alternative is a derivation of an ambiguous symbol node, as handed to a
+// PrunerFunc.
+type Alternative struct {
+	Rule     int           // number of the grammar rule deriving the node
+	Children []*SymbolNode // children of the derivation, in order; empty for ε
+	rhs      *rhsNode
+}
+
+// PrunerFunc adapts a function to the Pruner interface, letting clients write
+// pruners of their own. The function is called with an ambiguous symbol node
+// and its alternatives, and returns the alternatives to keep, in order.
+// Alternatives not taken from alts are ignored.
+type PrunerFunc func(sym *SymbolNode, alts []Alternative) []Alternative
+
+func (pf PrunerFunc) prune(f *Forest, sym *SymbolNode, alts []*rhsNode) []*rhsNode {
+	in := make([]Alternative, len(alts))
+	for i, rhs := range alts {
+		in[i] = Alternative{Rule: rhs.rule, Children: append([]*SymbolNode(nil), rhs.children...), rhs: rhs}
+	}
+	var kept []*rhsNode
+	for _, alt := range pf(sym, in) {
+		if contains(alts, alt.rhs) {
+			kept = append(kept, alt.rhs)
+		}
+	}
+	return kept
+}
+
+// --- Helpers ---------------------------------------------------------------
+
+// prunerFunc adapts a function operating on RHS-nodes to the Pruner interface.
+type prunerFunc func(f *Forest, sym *SymbolNode, alts []*rhsNode) []*rhsNode
+
+func (p prunerFunc) prune(f *Forest, sym *SymbolNode, alts []*rhsNode) []*rhsNode {
+	return p(f, sym, alts)
+}
+
+func ruleSet(rules []int) map[int]bool {
+	set := make(map[int]bool, len(rules))
+	for _, r := range rules {
+		set[r] = true
+	}
+	return set
+}
+
+func contains(alts []*rhsNode, rhs *rhsNode) bool {
+	for _, alt := range alts {
+		if alt == rhs {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,394 @@
+package sppf
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+Querying forests.
+
+Queries select symbol nodes of a forest by paths of grammar symbols, similar to
+XPath location paths. A query is a sequence of steps, every step consisting of
+an axis, a symbol name and optional predicates:
+
+    /S'/Program          Program as a child of the root node S'
+    FuncDecl//Call       Call anywhere below a FuncDecl, FuncDecl anywhere
+    //Call[@span contains 120][@rule = 7]
+    //*[@ambiguous]      every ambiguous node
+    //"+"                terminal +
+
+The axis "/" selects children of the nodes selected by the previous step, "//"
+selects descendants. A query not starting with an axis starts with "//". A name
+is either a symbol name, "*" for any symbol, or a name in double quotes, which
+is required for names containing whitespace or one of "/[]*@.
+
+Predicates filter the nodes selected by a step:
+
+    [@span contains n]   node covers input position n
+    [@start op n]        node starts at position n (op is one of = != < <= > >=)
+    [@end op n]          node ends at position n
+    [@len op n]          node covers n input positions
+    [@rule op n]         node is derived by rule n
+    [@ambiguous]         node has more than one alternative
+
+Spaces between attribute, operator and number are optional, e.g.
+[@start>=3] is the same as [@start >= 3].
+
+Queries look at a forest either as the single tree selected by a pruner, or, if
+no pruner is given, at every alternative of ambiguous nodes. In the latter case
+a node is selected if it matches for at least one of the trees of the forest.
+Results are in document order, i.e. sorted by start position, enclosing nodes
+first.
+*/
+
+// Query is a compiled query over parse forests.
+type Query struct {
+	source string
+	steps  []step
+}
+
+type axis int
+
+const (
+	childAxis axis = iota
+	descendantAxis
+)
+
+type step struct {
+	axis  axis
+	name  string // symbol name, empty for any symbol
+	preds []predicate
+}
+
+type predicate func(s *selection, sn *SymbolNode) bool
+
+// ParseQuery compiles a query. See the package documentation for the syntax.
+func ParseQuery(query string) (*Query, error) {
+	p := &queryParser{input: []rune(query)}
+	q := &Query{source: query}
+	for !p.atEnd() {
+		s, err := p.step(len(q.steps) == 0)
+		if err != nil {
+			return nil, fmt.Errorf("query %q: %v", query, err)
+		}
+		q.steps = append(q.steps, s)
+	}
+	if len(q.steps) == 0 {
+		return nil, fmt.Errorf("query is empty")
+	}
+	return q, nil
+}
+
+// String returns the source of a query.
+func (q *Query) String() string {
+	return q.source
+}
+
+// Query compiles and evaluates a query. See Select.
+func (f *Forest) Query(query string, pruner Pruner) ([]*RuleNode, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return f.Select(q, pruner), nil
+}
+
+// Select evaluates a query on a forest and returns the matching nodes in
+// document order. If pruner is nil, every alternative of ambiguous nodes is
+// searched, otherwise the tree selected by the pruner.
+//
+// Clients may navigate from a resulting node by setting up a cursor:
+//
+//     for _, rnode := range forest.Select(q, pruner) {
+//         cursor := forest.SetCursor(rnode, pruner)
+//         …
+//     }
+//
+func (f *Forest) Select(q *Query, pruner Pruner) []*RuleNode {
+	if f == nil || f.root == nil || q == nil {
+		return nil
+	}
+	s := &selection{forest: f, pruner: pruner}
+	context := []*SymbolNode{nil} // nil is the virtual parent of the root
+	for _, st := range q.steps {
+		context = s.step(context, st)
+		if len(context) == 0 {
+			return nil
+		}
+	}
+	sort.Slice(context, func(i, j int) bool {
+		return documentOrder(context[i], context[j])
+	})
+	result := make([]*RuleNode, len(context))
+	for i, sn := range context {
+		result[i] = &RuleNode{symbol: sn, forest: f}
+	}
+	return result
+}
+
+// --- Evaluation ------------------------------------------------------------
+
+type selection struct {
+	forest *Forest
+	pruner Pruner
+}
+
+// step selects the nodes matching st, starting from context nodes.
+func (s *selection) step(context []*SymbolNode, st step) []*SymbolNode {
+	var selected []*SymbolNode
+	seen := make(map[*SymbolNode]bool)
+	visited := make(map[*SymbolNode]bool) // for descendant axis
+	var descend func(sn *SymbolNode)
+	descend = func(sn *SymbolNode) {
+		for _, ch := range s.children(sn) {
+			if st.axis == descendantAxis {
+				if visited[ch] {
+					continue
+				}
+				visited[ch] = true
+				descend(ch)
+			}
+			if !seen[ch] && s.matches(ch, st) {
+				seen[ch] = true
+				selected = append(selected, ch)
+			}
+		}
+	}
+	for _, sn := range context {
+		descend(sn)
+	}
+	return selected
+}
+
+// children returns the children of a node, either for the alternative selected
+// by the pruner or for all alternatives.
+func (s *selection) children(sn *SymbolNode) []*SymbolNode {
+	if sn == nil {
+		return []*SymbolNode{s.forest.root}
+	}
+	if sn.Symbol.IsTerminal() {
+		return nil
+	}
+	var children []*SymbolNode
+	for _, rhs := range s.alternatives(sn) {
+		children = append(children, s.forest.childList(rhs)...)
+	}
+	return children
+}
+
+// alternatives returns the alternative selected by the pruner, or all
+// alternatives if there is no pruner.
+func (s *selection) alternatives(sn *SymbolNode) []*rhsNode {
+	if s.pruner != nil {
+		if rhs := s.forest.disambiguate(sn, s.pruner); rhs != nil {
+			return []*rhsNode{rhs}
+		}
+		return nil
+	}
+	var alts []*rhsNode
+	if choices, ok := s.forest.orEdges[sn]; ok {
+		for _, c := range choices.Values() {
+			alts = append(alts, c.(orEdge).toRHS)
+		}
+	}
+	return alts
+}
+
+func (s *selection) matches(sn *SymbolNode, st step) bool {
+	if st.name != "" && sn.Symbol.Name != st.name {
+		return false
+	}
+	for _, pred := range st.preds {
+		if !pred(s, sn) {
+			return false
+		}
+	}
+	return true
+}
+
+// --- Parsing queries -------------------------------------------------------
+
+type queryParser struct {
+	input []rune
+	pos   int
+}
+
+func (p *queryParser) atEnd() bool {
+	p.skipSpace()
+	return p.pos >= len(p.input)
+}
+
+func (p *queryParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *queryParser) peek() rune {
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+// step parses
+//
+//     step ::= [ "/" | "//" ] name { "[" predicate "]" }
+//
+func (p *queryParser) step(first bool) (step, error) {
+	st := step{axis: descendantAxis}
+	if p.peek() == '/' {
+		p.pos++
+		st.axis = childAxis
+		if p.peek() == '/' {
+			p.pos++
+			st.axis = descendantAxis
+		}
+	} else if !first {
+		return st, fmt.Errorf("expected / at position %d", p.pos)
+	}
+	p.skipSpace()
+	if p.peek() == '*' {
+		p.pos++
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return st, err
+		}
+		st.name = name
+	}
+	for p.skipSpace(); p.peek() == '['; p.skipSpace() {
+		p.pos++
+		start := p.pos
+		for p.pos < len(p.input) && p.input[p.pos] != ']' {
+			p.pos++
+		}
+		if p.pos >= len(p.input) {
+			return st, fmt.Errorf("missing ] for predicate at position %d", start)
+		}
+		pred, err := parsePredicate(string(p.input[start:p.pos]))
+		if err != nil {
+			return st, err
+		}
+		st.preds = append(st.preds, pred)
+		p.pos++
+	}
+	return st, nil
+}
+
+func (p *queryParser) name() (string, error) {
+	start := p.pos
+	if p.peek() == '"' {
+		p.pos++
+		for p.pos < len(p.input) && p.input[p.pos] != '"' {
+			p.pos++
+		}
+		if p.pos >= len(p.input) {
+			return "", fmt.Errorf("missing closing quote for name at position %d", start)
+		}
+		p.pos++
+		return string(p.input[start+1 : p.pos-1]), nil
+	}
+	for p.pos < len(p.input) && !strings.ContainsRune(`/[]*@"`, p.input[p.pos]) &&
+		!unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", fmt.Errorf("expected symbol name at position %d", start)
+	}
+	return string(p.input[start:p.pos]), nil
+}
+
+// parsePredicate parses the text between brackets.
+func parsePredicate(text string) (predicate, error) {
+	fields := predicateFields(text)
+	if len(fields) == 1 && fields[0] == "@ambiguous" {
+		return func(s *selection, sn *SymbolNode) bool {
+			return s.forest.isAmbiguous(sn)
+		}, nil
+	}
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("malformed predicate [%s]", text)
+	}
+	n, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("predicate [%s] needs a non-negative number", text)
+	}
+	if fields[0] == "@span" {
+		if fields[1] != "contains" {
+			return nil, fmt.Errorf("unknown operator %q for @span", fields[1])
+		}
+		return func(s *selection, sn *SymbolNode) bool {
+			return sn.Extent.Start() <= n && n < sn.Extent.End()
+		}, nil
+	}
+	cmp, ok := comparisons[fields[1]]
+	if !ok {
+		return nil, fmt.Errorf("unknown operator %q in predicate [%s]", fields[1], text)
+	}
+	switch fields[0] {
+	case "@start":
+		return func(s *selection, sn *SymbolNode) bool { return cmp(sn.Extent.Start(), n) }, nil
+	case "@end":
+		return func(s *selection, sn *SymbolNode) bool { return cmp(sn.Extent.End(), n) }, nil
+	case "@len":
+		return func(s *selection, sn *SymbolNode) bool { return cmp(sn.Extent.Len(), n) }, nil
+	case "@rule":
+		return func(s *selection, sn *SymbolNode) bool {
+			for _, rhs := range s.alternatives(sn) {
+				if cmp(uint64(rhs.rule), n) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown attribute %q in predicate [%s]", fields[0], text)
+}
+
+// predicateFields splits the text of a predicate into attribute, operator and
+// number. Operators need no spaces around them, thus "@start>=3" and
+// "@span contains120" are split like "@start >= 3" and "@span contains 120".
+func predicateFields(text string) []string {
+	class := func(r rune) int {
+		switch {
+		case unicode.IsSpace(r):
+			return 0
+		case r == '@' || unicode.IsLetter(r):
+			return 1
+		case unicode.IsDigit(r):
+			return 2
+		case strings.ContainsRune("=!<>", r):
+			return 3
+		}
+		return 4 // every other character is a field of its own
+	}
+	var fields []string
+	var field []rune
+	for _, r := range text {
+		if len(field) > 0 && (class(r) != class(field[0]) || class(r) == 4 || r == '@') {
+			fields = append(fields, string(field))
+			field = field[:0]
+		}
+		if class(r) != 0 {
+			field = append(field, r)
+		}
+	}
+	if len(field) > 0 {
+		fields = append(fields, string(field))
+	}
+	return fields
+}
+
+var comparisons = map[string]func(a, b uint64) bool{
+	"=":  func(a, b uint64) bool { return a == b },
+	"!=": func(a, b uint64) bool { return a != b },
+	"<":  func(a, b uint64) bool { return a < b },
+	"<=": func(a, b uint64) bool { return a <= b },
+	">":  func(a, b uint64) bool { return a > b },
+	">=": func(a, b uint64) bool { return a >= b },
+}
@@ -1,9 +1,14 @@
 package sppf
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"math/big"
+	"strings"
 	"testing"
 	"text/scanner"
+	"unicode"
 
 	"github.com/npillmayer/gorgo"
 	"github.com/npillmayer/schuko/tracing"
@@ -103,7 +108,469 @@ func TestSPPFAmbiguous(t *testing.T) {
 	}
 	tracer().SetTraceLevel(tracing.LevelDebug)
 	g.Dump()
-	//f := NewForest()
+	f := NewForest()
+	a := f.AddTerminal(g.Rule(3).RHS()[0], 0)
+	A := f.AddReduction(g.Rule(3).LHS, 3, []*SymbolNode{a})
+	B := f.AddReduction(g.Rule(4).LHS, 4, []*SymbolNode{a})
+	S := f.AddReduction(g.Rule(1).LHS, 1, []*SymbolNode{A})
+	f.AddReduction(g.Rule(2).LHS, 2, []*SymbolNode{B})
+	f.AddReduction(g.SymbolByName("S'"), 0, []*SymbolNode{S})
+	if n := f.CountTrees(); n == nil || n.Int64() != 2 {
+		t.Errorf("Expected forest to contain 2 trees, has %v", n)
+	}
+	amb := f.Ambiguities()
+	if len(amb) != 1 || amb[0].Symbol != S.Symbol || amb[0].Span != (gorgo.Span{0, 1}) ||
+		len(amb[0].Rules) != 2 || amb[0].Rules[0] == amb[0].Rules[1] {
+		t.Errorf("Expected S (0…1) to be ambiguous for rules 1 and 2, have %v", amb)
+	}
+	c := f.SetCursor(nil, nil)
+	if c.current.HasConflict() {
+		t.Errorf("Expected root not to be ambiguous")
+	}
+	if rnode, ok := c.Down(LtoR); !ok || !rnode.HasConflict() {
+		t.Errorf("Expected S to be ambiguous")
+	}
+	var seen []string
+	trees := f.Trees()
+	for trees.Next() {
+		S := trees.Tree().Root.Children[0]
+		seen = append(seen, S.Children[0].Symbol.Name)
+	}
+	if len(seen) != 2 || seen[0] == seen[1] {
+		t.Errorf("Expected trees to derive S from A and from B, have %v", seen)
+	}
+}
+
+// S' ⟶ S
+// S  ⟶ A D
+// D  ⟶ A
+// A  ⟶ B | C
+// B  ⟶ ε
+// C  ⟶ ε
+func TestTreesSharedNode(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("G")
+	b.LHS("S").N("A").N("D").End()
+	b.LHS("D").N("A").End()
+	b.LHS("A").N("B").End()
+	b.LHS("A").N("C").End()
+	b.LHS("B").Epsilon()
+	b.LHS("C").Epsilon()
+	g, err := b.Grammar()
+	if err != nil {
+		t.Error(err)
+	}
+	f := NewForest()
+	B := f.AddEpsilonReduction(g.Rule(5).LHS, 5, 0)
+	C := f.AddEpsilonReduction(g.Rule(6).LHS, 6, 0)
+	A := f.AddReduction(g.Rule(3).LHS, 3, []*SymbolNode{B})
+	f.AddReduction(g.Rule(4).LHS, 4, []*SymbolNode{C})
+	D := f.AddReduction(g.Rule(2).LHS, 2, []*SymbolNode{A})
+	S := f.AddReduction(g.Rule(1).LHS, 1, []*SymbolNode{A, D}) // A (0…0) occurs twice
+	f.AddReduction(g.SymbolByName("S'"), 0, []*SymbolNode{S})
+	if n := f.CountTrees(); n == nil || n.Int64() != 4 {
+		t.Fatalf("Expected forest to contain 4 trees, has %v", n)
+	}
+	seen := make(map[[2]int]bool)
+	for trees := f.Trees(); trees.Next(); {
+		tree := trees.Tree()
+		S := tree.Root.Children[0]
+		seen[[2]int{S.Children[0].Rule, S.Children[1].Children[0].Rule}] = true
+		for _, dir := range []Direction{LtoR, RtoL} {
+			expected := postOrder(tree.Root, dir)
+			l := &concat{}
+			trees.Cursor().TopDown(l, dir, Continue)
+			if trace := nonTerminals(l.trace); trace != expected {
+				t.Errorf("Expected top-down traversal of tree to be '%s', is '%s'", expected, trace)
+			}
+			l = &concat{}
+			trees.Cursor().BottomUp(l, dir, Continue)
+			if trace := nonTerminals(l.trace); trace != expected {
+				t.Errorf("Expected bottom-up traversal of tree to be '%s', is '%s'", expected, trace)
+			}
+		}
+	}
+	if len(seen) != 4 {
+		t.Errorf("Expected 4 distinct choices for the occurrences of A, have %v", seen)
+	}
+	if f.TreeCursor(big.NewInt(4)) != nil {
+		t.Errorf("Expected no cursor for tree #4 of 4")
+	}
+}
+
+// postOrder returns the names of the non-terminals of a tree in post-order.
+func postOrder(n *Node, dir Direction) string {
+	var names []string
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		for i := range n.Children {
+			if dir == RtoL {
+				i = len(n.Children) - 1 - i
+			}
+			walk(n.Children[i])
+		}
+		if !n.IsTerminal() {
+			names = append(names, n.Symbol.Name)
+		}
+	}
+	walk(n)
+	return strings.Join(names, " ")
+}
+
+// nonTerminals filters the names of terminals from a traversal trace.
+func nonTerminals(trace []string) string {
+	var names []string
+	for _, name := range trace {
+		if name != "" && unicode.IsUpper([]rune(name)[0]) {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// E ⟶ E + E | E * E | a
+func TestPreferences(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	g := exprGrammar(t)
+	for i, test := range []struct {
+		op1, op2 int
+		pruner   Pruner
+		rule     int
+		left     gorgo.Span // span of leftmost child of top E
+	}{
+		{1, 1, LeftAssociative(1), 1, gorgo.Span{0, 3}},
+		{1, 1, RightAssociative(1), 1, gorgo.Span{0, 1}},
+		{1, 1, LongestMatch, 1, gorgo.Span{0, 3}},
+		{1, 2, PreferLowerRule, 1, gorgo.Span{0, 1}},
+		{1, 2, PreferHigherRule, 2, gorgo.Span{0, 3}},
+		{1, 2, RulePriorities(map[int]int{1: 1}), 1, gorgo.Span{0, 1}},
+		{1, 2, Chain(LeftAssociative(1, 2), PreferLowerRule), 2, gorgo.Span{0, 3}},
+	} {
+		f, top := exprForest(g, test.op1, test.op2)
+		rule, rhs := f.SetCursor(nil, test.pruner).RHS(top)
+		if rule != test.rule || len(rhs) != 3 || rhs[0].Span() != test.left {
+			t.Errorf("test #%d: expected rule %d with left child %v, have rule %d with %v",
+				i, test.rule, test.left, rule, rhs)
+		}
+	}
+}
+
+// exprForest builds a forest for a op1 a op2 a, returning the top E node.
+func exprForest(g *lr.Grammar, op1, op2 int) (*Forest, *SymbolNode) {
+	f := NewForest()
+	var E [5]*SymbolNode
+	for i := 0; i < 5; i += 2 {
+		a := f.AddTerminal(g.Rule(3).RHS()[0], uint64(i))
+		E[i] = f.AddReduction(g.Rule(3).LHS, 3, []*SymbolNode{a})
+	}
+	o1 := f.AddTerminal(g.Rule(op1).RHS()[1], 1)
+	o2 := f.AddTerminal(g.Rule(op2).RHS()[1], 3)
+	left := f.AddReduction(g.Rule(op1).LHS, op1, []*SymbolNode{E[0], o1, E[2]})
+	right := f.AddReduction(g.Rule(op2).LHS, op2, []*SymbolNode{E[2], o2, E[4]})
+	top := f.AddReduction(g.Rule(op2).LHS, op2, []*SymbolNode{left, o2, E[4]})
+	f.AddReduction(g.Rule(op1).LHS, op1, []*SymbolNode{E[0], o1, right})
+	f.AddReduction(g.SymbolByName("S'"), 0, []*SymbolNode{top})
+	return f, top
+}
+
+func exprGrammar(t *testing.T) *lr.Grammar {
+	b := lr.NewGrammarBuilder("G")
+	b.LHS("E").N("E").T("+", '+').N("E").End()
+	b.LHS("E").N("E").T("*", '*').N("E").End()
+	b.LHS("E").T("a", scanner.Ident).End()
+	g, err := b.Grammar()
+	if err != nil {
+		t.Error(err)
+	}
+	return g
+}
+
+// E ⟶ E + E | E * E | a
+func TestMaterialize(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	g := exprGrammar(t)
+	f, _ := exprForest(g, 1, 1)
+	tree, err := f.Materialize(LeftAssociative(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Size() != 11 {
+		t.Errorf("Expected tree to have 11 nodes, has %d", tree.Size())
+	}
+	E := tree.Root.Children[0]
+	if E.Rule != 1 || E.Parent != tree.Root || E.Children[0].Span != (gorgo.Span{0, 3}) {
+		t.Errorf("Expected E (0…5) to group (a+a)+a, is %v", E.Children)
+	}
+	if op := E.Children[0].Sibling(); op == nil || op.Symbol.Name != "+" || op.Parent != E {
+		t.Errorf("Expected sibling of E (0…3) to be +, is %v", op)
+	}
+	leaves := tree.Leaves()
+	for i, leaf := range leaves {
+		if !leaf.IsTerminal() || leaf.Span.Start() != uint64(i) {
+			t.Errorf("Expected leaf #%d to be a terminal at %d, is %v", i, i, leaf)
+		}
+	}
+	if len(leaves) != 5 {
+		t.Errorf("Expected tree to have 5 leaves, has %d", len(leaves))
+	}
+	if _, err = f.Materialize(Reject(3)); err == nil {
+		t.Errorf("Expected rejection of every a to fail materialization")
+	}
+}
+
+// E ⟶ E + E | E * E | a
+func TestQuery(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	g := exprGrammar(t)
+	f, _ := exprForest(g, 1, 1)
+	for i, test := range []struct {
+		query  string
+		pruner Pruner
+		spans  []gorgo.Span
+	}{
+		{"/S'/E", nil, []gorgo.Span{{0, 5}}},
+		{"//E[@start = 2]", nil, []gorgo.Span{{2, 5}, {2, 3}}},
+		{"//E[@start = 2]", LeftAssociative(1), []gorgo.Span{{2, 3}}},
+		{"E/E[@span contains 2][@len > 1]", nil, []gorgo.Span{{0, 3}, {2, 5}}},
+		{"E/E[@span contains2][@len>1]", nil, []gorgo.Span{{0, 3}, {2, 5}}},
+		{"//E[@start>=2][ @len<3 ]", nil, []gorgo.Span{{2, 3}, {4, 5}}},
+		{"E/E/E/a", RightAssociative(1), []gorgo.Span{{2, 3}, {4, 5}}},
+		{`//"+"[@end <= 2]`, nil, []gorgo.Span{{1, 2}}},
+		{"//*[@ambiguous]", nil, []gorgo.Span{{0, 5}}},
+		{"//E[@rule = 3]/a", nil, []gorgo.Span{{0, 1}, {2, 3}, {4, 5}}},
+		{"//E[@rule = 1][@len < 5]", nil, []gorgo.Span{{0, 3}, {2, 5}}},
+		{"//F", nil, nil},
+	} {
+		result, err := f.Query(test.query, test.pruner)
+		if err != nil {
+			t.Errorf("test #%d: %v", i, err)
+			continue
+		}
+		var spans []gorgo.Span
+		for _, rnode := range result {
+			spans = append(spans, rnode.Span())
+		}
+		if fmt.Sprint(spans) != fmt.Sprint(test.spans) {
+			t.Errorf("test #%d: expected %s to select %v, selected %v", i, test.query, test.spans, spans)
+		}
+	}
+	for _, q := range []string{"", "E[", "E/", "E[@span > 1]", "E[@foo = 1]", "E[@start = x]", `"E`, "E F"} {
+		if _, err := ParseQuery(q); err == nil {
+			t.Errorf("Expected query %q to be rejected", q)
+		}
+	}
+	_, err := ParseQuery("E[@start >= -1]")
+	if err == nil || !strings.Contains(err.Error(), "malformed predicate [@start >= -1]") {
+		t.Errorf("Expected query with negative number to be rejected as malformed, have %v", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	g := exprGrammar(t)
+	f, _ := exprForest(g, 1, 2)
+	var out strings.Builder
+	if err := ToJSON(f, &out); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := FromJSON(strings.NewReader(out.String()), g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again strings.Builder
+	ToJSON(imported, &again)
+	if again.String() != out.String() {
+		t.Errorf("Expected imported forest to export as\n%s\nis\n%s", out.String(), again.String())
+	}
+	if n := imported.CountTrees(); n == nil || n.Int64() != 2 {
+		t.Errorf("Expected imported forest to contain 2 trees, has %v", n)
+	}
+	data := f.Export()
+	if root := data.Symbols[data.Root]; root.Name != "S'" || root.Span != [2]uint64{0, 5} {
+		t.Errorf("Expected root to be S' (0…5), is %v", root)
+	}
+	if len(data.Symbols) != 12 || len(data.RHS) != 8 || len(data.Or) != 8 || len(data.And) != 16 {
+		t.Errorf("Expected 12 symbols, 8 RHS, 8 or- and 16 and-edges, have %d, %d, %d, %d",
+			len(data.Symbols), len(data.RHS), len(data.Or), len(data.And))
+	}
+	data.Symbols[data.Root].Name = "X"
+	if _, err := Import(data, g); err == nil {
+		t.Errorf("Expected import of unknown symbol X to fail")
+	}
+	data = f.Export()
+	data.And[0].Seq = 1 << 40
+	if _, err := Import(data, g); err == nil {
+		t.Errorf("Expected import of and-edge beyond RHS to fail")
+	}
+	data = f.Export()
+	data.Root = len(data.Symbols)
+	if _, err := Import(data, g); err == nil {
+		t.Errorf("Expected import of unknown root to fail")
+	}
+}
+
+// S ⟶ A b
+// A ⟶ ε
+func TestJSONEpsilon(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("G")
+	r1 := b.LHS("S").N("A").T("b", scanner.Ident).End()
+	b.LHS("A").Epsilon()
+	G, err := b.Grammar()
+	if err != nil {
+		t.Error(err)
+	}
+	f := NewForest()
+	A := f.AddEpsilonReduction(G.SymbolByName("A"), 2, 0)
+	bb := f.AddTerminal(r1.RHS()[1], 0)
+	S := f.AddReduction(r1.LHS, 1, []*SymbolNode{A, bb})
+	f.AddReduction(G.SymbolByName("S'"), 0, []*SymbolNode{S})
+	out := compactJSON(t, f)
+	expected := `{"version":1,"root":0,"symbols":[{"id":0,"name":"S'","span":[0,1]},{"id":1,"name":"S","span":[0,1]},{"id":2,"name":"b","terminal":true,"value":-2,"span":[0,1],"token":0},{"id":3,"name":"A","span":[0,0]}],"rhs":[{"id":0,"rule":0,"start":0},{"id":1,"rule":1,"start":0},{"id":2,"rule":2,"start":0}],"or":[{"from":0,"to":0},{"from":1,"to":1},{"from":3,"to":2}],"and":[{"from":0,"seq":0,"to":1},{"from":1,"seq":0,"to":3},{"from":1,"seq":1,"to":2}]}`
+	if out != expected {
+		t.Errorf("Expected JSON\n%s\nis\n%s", expected, out)
+	}
+	imported, err := FromJSON(strings.NewReader(out), G)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again := compactJSON(t, imported); again != expected {
+		t.Errorf("Expected imported forest to export as\n%s\nis\n%s", expected, again)
+	}
+}
+
+func compactJSON(t *testing.T, f *Forest) string {
+	var out, compact bytes.Buffer
+	if err := ToJSON(f, &out); err != nil {
+		t.Fatal(err)
+	}
+	json.Compact(&compact, out.Bytes())
+	return compact.String()
+}
+
+// E ⟶ E + E | E * E | a
+func TestGraphViz(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	g := exprGrammar(t)
+	f, _ := exprForest(g, 1, 1)
+	dot := func(opts ...GraphOption) string {
+		var b strings.Builder
+		ToGraphViz(f, &b, opts...)
+		return b.String()
+	}
+	plain := dot()
+	if strings.Count(plain, "rule ") != 8 || strings.Contains(plain, "fillcolor=\"#fde0e0\"") {
+		t.Errorf("Expected plain output to have 8 uncoloured RHS-nodes:\n%s", plain)
+	}
+	if out := dot(HighlightAmbiguities()); !strings.Contains(out, `s1 [label="E (0…5)",color="#d62728",fillcolor="#fde0e0"`) ||
+		!strings.Contains(out, `s1 -> r1 [style=dashed,color="#d62728"]`) ||
+		!strings.Contains(out, `s1 -> r2 [style=dashed,color="#1f77b4"]`) {
+		t.Errorf("Expected E (0…5) and its alternatives to be highlighted:\n%s", out)
+	}
+	if out := dot(CollapseChains()); !strings.Contains(out, `s0 [label="S' ➞ E (0…5)"]`) ||
+		strings.Count(out, "rule ") != 2 {
+		t.Errorf("Expected S' ➞ E to be collapsed, leaving 2 RHS-nodes:\n%s", out)
+	}
+	if out := dot(SpanWindow(0, 2)); strings.Contains(out, "(4…5)") || !strings.Contains(out, `"a (0…1)"`) {
+		t.Errorf("Expected output to be restricted to span (0…2):\n%s", out)
+	}
+	var svg bytes.Buffer
+	if err := ToSVG(f, &svg); err != nil {
+		t.Logf("skipping SVG output: %v", err)
+	} else if !strings.Contains(svg.String(), "<svg") {
+		t.Errorf("Expected SVG output, got %s", svg.String())
+	}
+}
+
+// S ⟶ I | K
+// I ⟶ x
+// K ⟶ x        (reject)
+func TestReject(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("G")
+	b.LHS("S").N("I").End()
+	b.LHS("S").N("K").End()
+	b.LHS("I").T("x", scanner.Ident).End()
+	b.LHS("K").T("x", scanner.Ident).End()
+	g, err := b.Grammar()
+	if err != nil {
+		t.Error(err)
+	}
+	f := NewForest()
+	x := f.AddTerminal(g.Rule(3).RHS()[0], 0)
+	I := f.AddReduction(g.Rule(3).LHS, 3, []*SymbolNode{x})
+	K := f.AddReduction(g.Rule(4).LHS, 4, []*SymbolNode{x})
+	S := f.AddReduction(g.Rule(1).LHS, 1, []*SymbolNode{I})
+	f.AddReduction(g.Rule(2).LHS, 2, []*SymbolNode{K})
+	f.AddReduction(g.SymbolByName("S'"), 0, []*SymbolNode{S})
+	c := f.SetCursor(nil, Chain(Reject(4), PreferHigherRule))
+	if rule, _ := c.RHS(S); rule != 1 {
+		t.Errorf("Expected S to be derived by rule 1, is %d", rule)
+	}
+}
+
+// S  ⟶ Id | Id T
+// Id ⟶ x | x y
+// T  ⟶ y
+func TestFollowRestriction(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("G")
+	b.LHS("S").N("Id").End()
+	b.LHS("S").N("Id").N("T").End()
+	b.LHS("Id").T("x", 'x').End()
+	b.LHS("Id").T("x", 'x').T("y", 'y').End()
+	b.LHS("T").T("y", 'y').End()
+	g, err := b.Grammar()
+	if err != nil {
+		t.Error(err)
+	}
+	f := NewForest()
+	x := f.AddTerminal(g.Rule(3).RHS()[0], 0)
+	y := f.AddTerminal(g.Rule(5).RHS()[0], 1)
+	short := f.AddReduction(g.Rule(3).LHS, 3, []*SymbolNode{x})
+	long := f.AddReduction(g.Rule(4).LHS, 4, []*SymbolNode{x, y})
+	T := f.AddReduction(g.Rule(5).LHS, 5, []*SymbolNode{y})
+	S := f.AddReduction(g.Rule(2).LHS, 2, []*SymbolNode{short, T})
+	f.AddReduction(g.Rule(1).LHS, 1, []*SymbolNode{long})
+	f.AddReduction(g.SymbolByName("S'"), 0, []*SymbolNode{S})
+	f.AddTerminalSpan(&lr.Symbol{Name: "z", Value: 'z'}, gorgo.Span{1, 3}) // not part of a derivation
+	c := f.SetCursor(nil, Chain(FollowRestriction(g.SymbolByName("Id"), 'y'), PreferHigherRule))
+	if rule, rhs := c.RHS(S); rule != 1 || len(rhs) != 1 || rhs[0].Span() != (gorgo.Span{0, 2}) {
+		t.Errorf("Expected S to be derived from Id (0…2), is rule %d with %v", rule, rhs)
+	}
+	// the same with a pruner of our own
+	var alternatives int
+	longest := PrunerFunc(func(sym *SymbolNode, alts []Alternative) []Alternative {
+		alternatives += len(alts)
+		for _, alt := range alts {
+			if len(alt.Children) == 1 && alt.Children[0].Extent.Len() == 2 {
+				return []Alternative{alt}
+			}
+		}
+		return nil
+	})
+	c = f.SetCursor(nil, longest)
+	if rule, _ := c.RHS(S); rule != 1 || alternatives != 2 {
+		t.Errorf("Expected PrunerFunc to choose rule 1 of 2 alternatives, is rule %d of %d", rule, alternatives)
+	}
 }
 
 // S' ⟶ S
@@ -141,6 +608,94 @@ func TestTraverse(t *testing.T) {
 	}
 }
 
+// S' ⟶ S
+// S  ⟶ A b
+// A  ⟶ a
+func TestBottomUp(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.lr")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("G")
+	r1 := b.LHS("S").N("A").T("b", scanner.Ident).End()
+	r2 := b.LHS("A").T("a", scanner.Ident).End()
+	G, err := b.Grammar()
+	if err != nil {
+		t.Error(err)
+	}
+	f := NewForest()
+	a := f.AddTerminal(r2.RHS()[0], 0)
+	A := f.AddReduction(r2.LHS, 2, []*SymbolNode{a})
+	bb := f.AddTerminal(r1.RHS()[1], 1)
+	S := f.AddReduction(r1.LHS, 1, []*SymbolNode{A, bb})
+	f.AddReduction(G.SymbolByName("S'"), 0, []*SymbolNode{S})
+	for _, test := range []struct {
+		dir   Direction
+		trace string
+	}{
+		{LtoR, "a A b S S'"},
+		{RtoL, "b a A S S'"},
+	} {
+		l := &concat{}
+		v := f.SetCursor(nil, nil).BottomUp(l, test.dir, Continue)
+		if v != "ab" {
+			t.Errorf("Expected value 'ab' for direction %d, have %v", test.dir, v)
+		}
+		if trace := strings.Join(l.trace, " "); trace != test.trace {
+			t.Errorf("Expected traversal '%s', have '%s'", test.trace, trace)
+		}
+	}
+	for _, test := range []struct {
+		mode  Breakmode
+		value string
+		trace string
+	}{
+		{Continue, "ab", "a A b S S'"},
+		{Break, "b", "A b S S'"}, // children of A skipped
+	} {
+		l := &concat{stop: "A"}
+		if v := f.SetCursor(nil, nil).BottomUp(l, LtoR, test.mode); v != test.value {
+			t.Errorf("Expected value '%s' for breakmode %d, have %v", test.value, test.mode, v)
+		}
+		if trace := strings.Join(l.trace, " "); trace != test.trace {
+			t.Errorf("Expected traversal '%s' for breakmode %d, have '%s'", test.trace, test.mode, trace)
+		}
+	}
+}
+
+// concat is a listener which concatenates the names of terminals.
+type concat struct {
+	trace []string
+	stop  string // stop traversal at this symbol
+}
+
+func (l *concat) EnterRule(sym *lr.Symbol, rhs []*RuleNode, ctxt RuleCtxt) bool {
+	return sym.Name != l.stop
+}
+
+func (l *concat) ExitRule(sym *lr.Symbol, rhs []*RuleNode, ctxt RuleCtxt) interface{} {
+	l.trace = append(l.trace, sym.Name)
+	var s string
+	for _, r := range rhs {
+		if v, ok := r.Value.(string); ok { // nil for skipped children
+			s += v
+		}
+	}
+	return s
+}
+
+func (l *concat) Terminal(tokval gorgo.TokType, terminal *lr.Symbol, ctxt RuleCtxt) interface{} {
+	l.trace = append(l.trace, terminal.Name)
+	return terminal.Name
+}
+
+func (l *concat) Conflict(sym *lr.Symbol, ctxt RuleCtxt) (int, error) {
+	return 0, nil
+}
+
+func (l *concat) MakeAttrs(*lr.Symbol) interface{} {
+	return nil
+}
+
 // ---------------------------------------------------------------------------
 
 func makeListener(G *lr.Grammar, t *testing.T) Listener {
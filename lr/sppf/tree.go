@@ -0,0 +1,153 @@
+package sppf
+
+import (
+	"fmt"
+
+	"github.com/npillmayer/gorgo"
+	"github.com/npillmayer/gorgo/lr"
+)
+
+/*
+Materialised parse trees.
+
+A cursor disambiguates a forest lazily, consulting its pruner at every
+ambiguous node it visits, over and over again for repeated traversals. Clients
+which have settled on a single derivation may materialise it as a Tree instead.
+A tree is a plain structure of nodes with parent pointers and child slices,
+independent of the forest it has been created from. Trees are not modified
+after construction, therefore they may be cached and shared between goroutines.
+*/
+
+// Tree is an unambiguous parse tree, materialised from a parse forest.
+type Tree struct {
+	Root *Node // root node, i.e. the root of the forest
+	size int   // number of nodes
+}
+
+// Node is a node of a parse tree. Nodes for terminals have no children and
+// rule number -1. Epsilon-reductions are nodes with an empty span and no
+// children.
+type Node struct {
+	Symbol     *lr.Symbol // grammar symbol, either a terminal or the LHS of Rule
+	Rule       int        // number of the rule reduced, -1 for terminals
+	Span       gorgo.Span // span of input covered by this node
+	Fabricated bool       // terminal has been fabricated by the parser, not read from the input
+	Parent     *Node      // parent node, nil for the root
+	Children   []*Node    // children in left-to-right order
+	Index      int        // position among the children of Parent
+}
+
+// IsTerminal returns true if the node represents a terminal.
+func (n *Node) IsTerminal() bool {
+	return n.Symbol.IsTerminal()
+}
+
+// String returns a node's symbol and span.
+func (n *Node) String() string {
+	return fmt.Sprintf("%s %s", n.Symbol.Name, n.Span)
+}
+
+// Sibling returns the next sibling of a node, or nil.
+func (n *Node) Sibling() *Node {
+	if n.Parent != nil && n.Index+1 < len(n.Parent.Children) {
+		return n.Parent.Children[n.Index+1]
+	}
+	return nil
+}
+
+// Walk traverses the sub-tree of a node in pre-order, calling visit for every
+// node. If visit returns false, the children of the node are skipped.
+func (n *Node) Walk(visit func(*Node) bool) {
+	if !visit(n) {
+		return
+	}
+	for _, ch := range n.Children {
+		ch.Walk(visit)
+	}
+}
+
+// Size returns the number of nodes of a tree.
+func (t *Tree) Size() int {
+	return t.size
+}
+
+// Leaves returns the leaves of a tree in left-to-right order. Leaves are
+// terminals and epsilon-reductions.
+func (t *Tree) Leaves() []*Node {
+	var leaves []*Node
+	t.Root.Walk(func(n *Node) bool {
+		if len(n.Children) == 0 {
+			leaves = append(leaves, n)
+		}
+		return true
+	})
+	return leaves
+}
+
+// Materialize creates the parse tree selected by a pruner, with pruner = nil
+// selecting the same tree as a cursor with the DontCarePruner. Shared nodes of
+// the forest are copied, so that every node of the tree has a single parent.
+//
+// It is an error if the forest contains a cycle on the selected derivation, or
+// if the pruner prunes every alternative of a node.
+func (f *Forest) Materialize(pruner Pruner) (*Tree, error) {
+	if f == nil || f.root == nil {
+		return nil, fmt.Errorf("cannot materialize tree: forest is empty")
+	}
+	if pruner == nil {
+		pruner = DontCarePruner
+	}
+	m := &materializer{forest: f, pruner: pruner, onPath: make(map[*SymbolNode]bool)}
+	root, err := m.node(f.root, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Tree{Root: root, size: m.size}, nil
+}
+
+type materializer struct {
+	forest *Forest
+	pruner Pruner
+	onPath map[*SymbolNode]bool // guard against cycles
+	size   int
+}
+
+// newNode creates a tree node for a symbol node, without children.
+func newNode(sn *SymbolNode, parent *Node, index int) *Node {
+	return &Node{
+		Symbol:     sn.Symbol,
+		Rule:       -1,
+		Span:       sn.Extent,
+		Fabricated: sn.Fabricated,
+		Parent:     parent,
+		Index:      index,
+	}
+}
+
+func (m *materializer) node(sn *SymbolNode, parent *Node, index int) (*Node, error) {
+	m.size++
+	n := newNode(sn, parent, index)
+	if sn.Symbol.IsTerminal() {
+		return n, nil
+	}
+	if m.onPath[sn] {
+		return nil, fmt.Errorf("cannot materialize tree: cycle at %v", sn)
+	}
+	rhs := m.forest.disambiguate(sn, m.pruner)
+	if rhs == nil {
+		return nil, fmt.Errorf("cannot materialize tree: no derivation left for %v", sn)
+	}
+	n.Rule = rhs.rule
+	m.onPath[sn] = true
+	children := m.forest.childList(rhs)
+	n.Children = make([]*Node, len(children))
+	for i, child := range children {
+		ch, err := m.node(child, n, i)
+		if err != nil {
+			return nil, err
+		}
+		n.Children[i] = ch
+	}
+	delete(m.onPath, sn)
+	return n, nil
+}
@@ -10,6 +10,8 @@ Copyright © 2017–2022 Norbert Pillmayer <norbert@pillmayer.com>
 */
 
 import (
+	"math/big"
+
 	"github.com/npillmayer/gorgo"
 	"github.com/npillmayer/gorgo/lr"
 )
@@ -45,6 +47,7 @@ tree pattern matching and term-rewriting for making these tasks easier.
 // RuleNode represents a node occuring during a parse tree/forest walk.
 type RuleNode struct {
 	symbol *SymbolNode
+	forest *Forest
 	Value  interface{} // user-defined value of a node
 }
 
@@ -55,14 +58,15 @@ func (rnode *RuleNode) Symbol() *lr.Symbol {
 }
 
 // RHS collects the children symbols of a node as a slice.
-// It uses a pruner to decide between ambiguous RHS variants.
+// It uses a pruner to decide between ambiguous RHS variants. For a cursor on an
+// enumerated tree (see TreeCursor), the RHS of the tree is used for the current
+// node.
 func (c *Cursor) RHS(sym *SymbolNode) (int, []*RuleNode) {
-	//rhs := c.forest.disambiguate(c.current.symbol, c.pruner)
-	rhs := c.forest.disambiguate(sym, c.pruner)
+	rhs, _ := c.choose(sym)
 	if rhs == nil {
 		return -1, nil
 	}
-	if iter, ok := c.forest.children(rhs); ok {
+	if iter, ok := c.forest.children(rhs, LtoR); ok {
 		edges := c.forest.andEdges[rhs]
 		tracer().Debugf("RHS(%s) has length %d", sym, edges.Size())
 		rhsnodes := make([]*RuleNode, edges.Size())
@@ -71,7 +75,7 @@ func (c *Cursor) RHS(sym *SymbolNode) (int, []*RuleNode) {
 		i := 0
 		for ; rhschild != nil; rhschild, iter = iter() {
 			tracer().Debugf("   RHS #%d = %s", i, rhschild)
-			rhsnodes[i] = &RuleNode{symbol: rhschild}
+			rhsnodes[i] = &RuleNode{symbol: rhschild, forest: c.forest}
 			i++
 		}
 		return rhs.rule, rhsnodes
@@ -84,9 +88,16 @@ func (rnode *RuleNode) Span() gorgo.Span {
 	return rnode.symbol.Extent
 }
 
-// HasConflict returns true if this node is ambiguous.
+// IsFabricated returns true if this node is a terminal which has not been read
+// from the input, but has been fabricated by the parser.
+func (rnode *RuleNode) IsFabricated() bool {
+	return rnode.symbol.Fabricated
+}
+
+// HasConflict returns true if this node is ambiguous, i.e. if the parser found
+// more than one derivation for its span of input. See also Forest.Ambiguities.
 func (rnode *RuleNode) HasConflict() bool {
-	return false // TODO
+	return rnode.forest != nil && rnode.forest.isAmbiguous(rnode.symbol)
 }
 
 // Root returns the root node of a parse forest.
@@ -96,6 +107,7 @@ func (f *Forest) Root() *RuleNode {
 	}
 	return &RuleNode{
 		symbol: f.root,
+		forest: f,
 	}
 }
 
@@ -108,13 +120,18 @@ type Cursor struct {
 	pruner    Pruner
 	startNode *RuleNode
 	stack     []childIterator
+	parents   []*SymbolNode // symbol nodes the cursor has moved down from, parallel to stack
+	enum      *enumeration  // enumeration of trees, for a cursor on a single tree
+	rank      *big.Int      // number of the tree below the current node
+	ranks     []*big.Int    // ranks of the parents of the current node
+	siblings  [][]*big.Int  // ranks of the siblings still to visit, parallel to stack
 }
 
 // SetCursor sets up a cursor at a given rule node in a given forest.
 // If rnode is nil, the cursor will be set up at the root node of the forest.
 //
-// A pruner may be given for solving disambiguities. If it is nil, parse tree variants
-// will be selected at random.
+// A pruner may be given for solving disambiguities. If it is nil, DontCarePruner is
+// used, selecting the first variant found in the forest.
 func (f *Forest) SetCursor(rnode *RuleNode, pruner Pruner) *Cursor {
 	if rnode == nil {
 		if rnode = f.Root(); rnode == nil {
@@ -139,35 +156,47 @@ func nullChildIterator() (*SymbolNode, childIterator) {
 	return nil, nullChildIterator
 }
 
-func (f *Forest) children(rhs *rhsNode) (childIterator, bool) {
-	if children, ok := f.andEdges[rhs]; ok {
-		children.IterateOnce()
-		var iterator childIterator
-		iterator = func() (*SymbolNode, childIterator) {
-			if children.Next() { // TODO iterate by child.selector
-				// maybe sort the rhs before iterating
-				// TODO implement backwards iteration, too => Direction
-				childEdge := children.Item().(andEdge)
-				return childEdge.toSym, iterator
-			}
+// children returns an iterator over the children of an RHS-node, in order of
+// their sequence numbers, or in reverse order for dir = RtoL.
+func (f *Forest) children(rhs *rhsNode, dir Direction) (childIterator, bool) {
+	edges, ok := f.andEdges[rhs]
+	if !ok {
+		return nullChildIterator, false
+	}
+	children := make([]*SymbolNode, edges.Size())
+	edges.Each(func(el interface{}) {
+		childEdge := el.(andEdge)
+		children[childEdge.sequence] = childEdge.toSym
+	})
+	i := 0
+	if dir == RtoL {
+		i = len(children) - 1
+	}
+	var iterator childIterator
+	iterator = func() (*SymbolNode, childIterator) {
+		if i < 0 || i >= len(children) {
 			return nil, nullChildIterator
 		}
-		return iterator, true
+		child := children[i]
+		i += int(dir)
+		return child, iterator
 	}
-	return nullChildIterator, false
+	return iterator, true
 }
 
 // Pruner is an interface type for an entity to help prune ambiguous children
-// edges.
+// edges. Given the alternative RHS-nodes of an ambiguous symbol node, a pruner
+// returns the alternatives to keep, in order. See pruners.go for a library of
+// standard pruners; clients may write pruners of their own with PrunerFunc.
 type Pruner interface {
-	prune(sym *SymbolNode, rhs *rhsNode) bool
+	prune(f *Forest, sym *SymbolNode, alts []*rhsNode) []*rhsNode
 }
 
 type dcp struct{}
 
-func (p dcp) prune(sym *SymbolNode, rhs *rhsNode) bool {
+func (p dcp) prune(f *Forest, sym *SymbolNode, alts []*rhsNode) []*rhsNode {
 	tracer().Infof("Ambiguous symbol node %v detected", sym)
-	return false // do not prune anything
+	return alts // do not prune anything
 }
 
 // DontCarePruner never prunes an ambiguity alternative, thus resulting
@@ -175,28 +204,46 @@ func (p dcp) prune(sym *SymbolNode, rhs *rhsNode) bool {
 // It is the default Pruner if none is given by the caller of a Cursor.
 var DontCarePruner dcp = dcp{}
 
+// choose returns the RHS of a symbol node the cursor descends into. For a cursor
+// on an enumerated tree, it returns the RHS of the tree for the current node and
+// the ranks of its children, in left-to-right order.
+func (c *Cursor) choose(sym *SymbolNode) (*rhsNode, []*big.Int) {
+	if c.enum != nil && sym == c.current.symbol {
+		return c.enum.choose(sym, c.rank)
+	}
+	return c.forest.disambiguate(sym, c.pruner), nil
+}
+
 func (f *Forest) disambiguate(sym *SymbolNode, pruner Pruner) *rhsNode {
 	if choices, ok := f.orEdges[sym]; ok {
 		if choices.Size() == 1 {
 			return choices.First().(orEdge).toRHS
 		}
-		match := choices.FirstMatch(func(el interface{}) bool {
-			rhs := el.(orEdge).toRHS
-			return !pruner.prune(sym, rhs)
-		})
-		if match != nil {
-			return match.(orEdge).toRHS
+		alts := make([]*rhsNode, 0, choices.Size())
+		for _, c := range choices.Values() {
+			alts = append(alts, c.(orEdge).toRHS)
+		}
+		if alts = pruner.prune(f, sym, alts); len(alts) > 0 {
+			return alts[0]
 		}
 	}
 	return nil
 }
 
-// Up moves the cursor up to the parent node of the current node, if any.
+// Up moves the cursor up to the parent node of the current node, if any. As
+// symbol nodes may be shared, the parent is the node the cursor has moved down
+// from.
 func (c *Cursor) Up() (*RuleNode, bool) {
-	if parent, ok := c.forest.parent[c.current.symbol]; ok {
-		c.current.symbol = parent
+	if len(c.parents) > 0 {
+		c.current.symbol = c.parents[len(c.parents)-1]
+		c.parents = c.parents[:len(c.parents)-1]
 		tracer().Debugf("UP Cursor @ %v", c.current.Symbol())
 		c.stack = c.stack[:len(c.stack)-1]
+		if c.enum != nil {
+			c.rank = c.ranks[len(c.ranks)-1]
+			c.ranks = c.ranks[:len(c.ranks)-1]
+			c.siblings = c.siblings[:len(c.siblings)-1]
+		}
 		return c.current, true
 	}
 	return c.current, false
@@ -206,14 +253,26 @@ func (c *Cursor) Up() (*RuleNode, bool) {
 // dir lets clients start at either the leftmost child (default) or the rightmost
 // child.
 func (c *Cursor) Down(dir Direction) (*RuleNode, bool) {
-	rhs := c.forest.disambiguate(c.current.symbol, c.pruner)
+	rhs, ranks := c.choose(c.current.symbol)
 	if rhs == nil {
 		return c.current, false
 	}
-	if iter, ok := c.forest.children(rhs); ok {
+	if iter, ok := c.forest.children(rhs, dir); ok {
 		c.stack = append(c.stack, iter)
+		c.parents = append(c.parents, c.current.symbol)
+		if c.enum != nil {
+			if dir == RtoL {
+				ranks = reversed(ranks)
+			}
+			c.ranks = append(c.ranks, c.rank)
+			c.siblings = append(c.siblings, ranks)
+		}
 		var child *SymbolNode
 		if child, iter = iter(); child != nil {
+			c.stack[len(c.stack)-1] = iter
+			if c.enum != nil {
+				c.rank, c.siblings[len(c.siblings)-1] = ranks[0], ranks[1:]
+			}
 			c.current.symbol = child
 			tracer().Debugf("DOWN Cursor @ %v", c.current.Symbol())
 			return c.current, true
@@ -227,6 +286,11 @@ func (c *Cursor) Sibling() (*RuleNode, bool) {
 	iter := c.stack[len(c.stack)-1]
 	var sym *SymbolNode
 	if sym, iter = iter(); sym != nil {
+		c.stack[len(c.stack)-1] = iter
+		if c.enum != nil {
+			ranks := c.siblings[len(c.siblings)-1]
+			c.rank, c.siblings[len(c.siblings)-1] = ranks[0], ranks[1:]
+		}
 		c.current.symbol = sym
 		tracer().Debugf("SIBLING Cursor @ %v", c.current.Symbol())
 		return c.current, true
@@ -277,9 +341,67 @@ func (c *Cursor) traverseTopDown(listener Listener, dir Direction, breakmode Bre
 	return value
 }
 
-// BottomUp TODO
-func (c *Cursor) BottomUp(Listener, Direction, Breakmode) interface{} {
-	panic("sppf.Cursor.BottomUp() not yet implemented")
+// BottomUp traverses a sub-tree bottom-up (post-order), applying Listener-methods
+// for all nodes encountered. Children nodes are traversed in the order given by
+// dir, and their values are set before ExitRule is called for their parent.
+// It returns a user-defined value, calculated by the listener.
+//
+// EnterRule is called for a node before its children are traversed. As with
+// TopDown, if EnterRule signals a break and breakmode is Break, the sub-tree of
+// the node is skipped: the values of its children remain unset, but ExitRule is
+// called for the node nevertheless and the traversal continues with the next
+// node.
+func (c *Cursor) BottomUp(listener Listener, dir Direction, breakmode Breakmode) interface{} {
+	c.startNode = c.current
+	tracer().Debugf("BottomUp starting at node %v", c.current.Symbol())
+	start := c.current.symbol
+	value := c.traverseBottomUp(listener, dir, breakmode, 0)
+	c.current.symbol = start
+	return value
+}
+
+func (c *Cursor) traverseBottomUp(listener Listener, dir Direction, breakmode Breakmode, level int) interface{} {
+	sn := c.current.symbol
+	if sym := sn.Symbol; sym.IsTerminal() {
+		ctxt := makeCtxt(sn.Extent, level+1, -1, nil)
+		return listener.Terminal(sym.TokenType(), sym, ctxt)
+	}
+	tracer().Debugf(">>> %s", sn)
+	ruleno, rhsNodes := c.RHS(sn)
+	_, ranks := c.choose(sn)
+	rank := c.rank
+	localAttributes := listener.MakeAttrs(sn.Symbol)
+	ctxt := makeCtxt(sn.Extent, level, ruleno, localAttributes)
+	if doContinue := listener.EnterRule(sn.Symbol, rhsNodes, ctxt); doContinue || breakmode == Continue {
+		i := 0
+		if dir == RtoL {
+			i = len(rhsNodes) - 1
+		}
+		for ; i >= 0 && i < len(rhsNodes); i += int(dir) {
+			c.current.symbol = rhsNodes[i].symbol
+			if ranks != nil {
+				c.rank = ranks[i]
+			}
+			chvalue := c.traverseBottomUp(listener, dir, breakmode, level+1)
+			c.current.symbol, c.rank = sn, rank
+			tracer().Debugf("child value[%d] = %v", i, chvalue)
+			rhsNodes[i].Value = chvalue
+		}
+	} else {
+		tracer().Debugf("BottomUp skips children of node %v", sn)
+	}
+	value := listener.ExitRule(sn.Symbol, rhsNodes, ctxt)
+	tracer().Debugf("<<< %s", sn)
+	return value
+}
+
+// reversed returns a reversed copy of a slice of ranks.
+func reversed(ranks []*big.Int) []*big.Int {
+	r := make([]*big.Int, len(ranks))
+	for i, k := range ranks {
+		r[len(ranks)-1-i] = k
+	}
+	return r
 }
 
 // Direction lets clients decide wether children nodes should be traversed left-to-right
@@ -90,6 +90,7 @@ type CFSMState struct {
 	ID     uint            // serial ID of this state
 	items  *iteratable.Set // configuration items within this state
 	Accept bool            // is this an accepting state?
+	cfsm   *CFSM           // the state machine this state is part of
 }
 
 // CFSM edge between 2 states, directed and with a terminal
@@ -106,6 +107,21 @@ func (s *CFSMState) Dump() {
 	tracer().Debugf("-------------------------")
 }
 
+// Items returns the configuration items of a state. Clients should treat
+// the items as read-only.
+func (s *CFSMState) Items() []Item {
+	items := make([]Item, 0, s.items.Size())
+	for _, x := range s.items.Values() {
+		items = append(items, asItem(x))
+	}
+	return items
+}
+
+// CFSM returns the characteristic finite state machine a state is part of.
+func (s *CFSMState) CFSM() *CFSM {
+	return s.cfsm
+}
+
 func (s *CFSMState) isErrorState() bool {
 	return s.items.Size() == 0
 }
@@ -163,12 +179,24 @@ func (c *CFSM) addState(iset *iteratable.Set) *CFSMState {
 	s := c.findStateByItems(iset)
 	if s == nil {
 		s = state(c.cfsmIds, iset)
+		s.cfsm = c
 		c.cfsmIds++
 	}
 	c.states.Add(s)
 	return s
 }
 
+// State returns the state with a given ID, or nil if no such state exists.
+func (c *CFSM) State(id uint) *CFSMState {
+	it := c.states.Iterator()
+	for it.Next() {
+		if s := it.Value().(*CFSMState); s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
 // Find a CFSM state by the contained item set.
 func (c *CFSM) findStateByItems(iset *iteratable.Set) *CFSMState {
 	it := c.states.Iterator()
@@ -350,6 +350,7 @@ func setTerminalTokenValue(el terex.Element, env *terex.Environment) terex.Eleme
 		if f, err := strconv.ParseFloat(string(token.Lexeme()), 64); err == nil {
 			tracer().Debugf("   t.Value=%g", f)
 			deftok.Val = f
+			return terex.Elem(terex.Atomize(deftok)) // tokens are values: replace the atom
 		} else {
 			tracer().Errorf("   %s", err.Error())
 			return terex.Elem(terex.Atomize(err))
@@ -360,6 +361,7 @@ func setTerminalTokenValue(el terex.Element, env *terex.Environment) terex.Eleme
 		} else { // trim off "…"
 			deftok.Val = string(token.Lexeme()[1 : len(token.Lexeme())-1])
 		}
+		return terex.Elem(terex.Atomize(deftok))
 	case tokenIds["VAR"]:
 		panic("VAR type tokens not yet implemented")
 		//fallthrough
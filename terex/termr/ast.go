@@ -34,6 +34,10 @@ type ASTBuilder struct {
 	rewriters        map[string]TermRewriter // term rewriters to apply
 	Error            func(error)             // user supplied handler for errors
 	stack            []*terex.GCons          // used for recursive operator walking
+	generic          bool                    // create generic AST for symbols without rewriter
+	punctuation      map[string]bool         // terminals to drop in generic mode
+	helpers          map[string]bool         // non-terminals to splice in generic mode
+	found            map[string]bool         // helpers found in the grammar, see findHelpers
 }
 
 // ErrorHandler is an interface to process errors occuring during parsing.
@@ -57,6 +61,7 @@ func NewASTBuilder(g *lr.Grammar) *ASTBuilder {
 		stack:     make([]*terex.GCons, 0, 256),
 		rewriters: make(map[string]TermRewriter),
 	}
+	ab.SetPunctuation(defaultPunctuation...)
 	return ab
 }
 
@@ -75,6 +80,13 @@ func (ab *ASTBuilder) AddRewriter(grammarSymbol string, trew TermRewriter) {
 	}
 }
 
+// SetPruner sets a pruner for resolving ambiguities of the parse forest, e.g.
+// a chain of sppf.RulePriorities and sppf.LeftAssociative. Without a pruner,
+// the builder will use sppf.DontCarePruner.
+func (ab *ASTBuilder) SetPruner(pruner sppf.Pruner) {
+	ab.conflictStrategy = pruner
+}
+
 // AST creates an abstract syntax tree from a parse tree/forest.
 // The type of ASTs we create is a homogenous abstract syntax tree.
 func (ab *ASTBuilder) AST(parseTree *sppf.Forest, tokRetr gorgo.TokenRetriever) *terex.Environment {
@@ -83,7 +95,10 @@ func (ab *ASTBuilder) AST(parseTree *sppf.Forest, tokRetr gorgo.TokenRetriever)
 	}
 	ab.forest = parseTree
 	ab.toks = tokRetr
-	cursor := ab.forest.SetCursor(nil, nil) // TODO set Pruner
+	if ab.generic { // depends on punctuation
+		ab.found = findHelpers(ab.G, ab.punctuation)
+	}
+	cursor := ab.forest.SetCursor(nil, ab.conflictStrategy)
 	value := cursor.TopDown(ab, sppf.LtoR, sppf.Break)
 	tracer().Infof("AST creation return value = %v", value)
 	if value != nil {
@@ -134,6 +149,11 @@ func (ab *ASTBuilder) ExitRule(sym *lr.Symbol, rhs []*sppf.RuleNode, ctxt sppf.R
 		tracer().Infof("%s returns %s", sym.Name, rewritten.String())
 		return rewritten
 	}
+	if ab.generic {
+		rew := ab.genericRewrite(sym, rhs, ctxt)
+		tracer().Infof("%s returns generic %s", sym.Name, rew.String())
+		return rew
+	}
 	var list *terex.GCons
 	tracer().Infof("%s will rewrite |rhs| = %d symbols", sym.Name, len(rhs))
 	for _, r := range rhs {
@@ -0,0 +1,196 @@
+package termr
+
+import (
+	"strings"
+
+	"github.com/npillmayer/gorgo"
+	"github.com/npillmayer/gorgo/lr"
+	"github.com/npillmayer/gorgo/lr/sppf"
+	"github.com/npillmayer/gorgo/terex"
+)
+
+/*
+Generic ASTs.
+
+Writing a TermRewriter for every grammar symbol is tedious, in particular in
+the early stages of a language project. In generic mode, an ASTBuilder creates
+a reasonable AST on its own, for every non-terminal without a rewriter:
+
+- A unit rule A ➞ B is collapsed: A is replaced by the AST of B. Thus chains of
+  unit rules, common in expression grammars, vanish.
+
+- Punctuation terminals are dropped. By default these are ( ) [ ] { } , and ;
+  see SetPunctuation.
+
+- Helper non-terminals become plain lists, which are spliced into the list of
+  the parent node. Helpers are non-terminals implementing a repetition, i.e.
+  having rules recursive at the start or at the end only, like
+  Seq ➞ Item Seq | ε or Args ➞ Args , Arg | Arg, and the artificial start
+  symbol S'. More helpers may be given with SetHelpers.
+
+- Every other non-terminal becomes a list headed by a *GenericOp, which carries
+  the rule and the span of the node. ε-rules yield nil.
+
+Terminals are token atoms, as usual, and carry their spans with them.
+
+For the grammar
+
+    List ➞ ( Seq )
+    Seq  ➞ Atom Seq | ε
+    Atom ➞ id | List
+
+input "(a (b c))" results in the generic AST (with the #eof token from S')
+
+    ((#List :t(id) (#List :t(id) :t(id))) :t(#eof))
+*/
+
+// GenericOp is the operator heading nodes of generic ASTs. It carries the rule
+// and the span of the node.
+type GenericOp struct {
+	Symbol *lr.Symbol // LHS of the rule
+	Rule   int        // rule number
+	Span   gorgo.Span // span of input covered
+}
+
+// String returns the name of the grammar symbol.
+func (op *GenericOp) String() string {
+	return op.Symbol.Name
+}
+
+// Call is part of interface terex.Operator. It returns its argument unchanged.
+func (op *GenericOp) Call(el terex.Element, env *terex.Environment) terex.Element {
+	return el
+}
+
+var _ terex.Operator = (*GenericOp)(nil)
+
+// SetGeneric switches generic AST creation on or off. See the package
+// documentation on generic ASTs.
+func (ab *ASTBuilder) SetGeneric(on bool) {
+	ab.generic = on
+}
+
+// SetPunctuation sets the terminals (by name) to drop in generic mode.
+func (ab *ASTBuilder) SetPunctuation(terminals ...string) {
+	ab.punctuation = make(map[string]bool, len(terminals))
+	for _, t := range terminals {
+		ab.punctuation[t] = true
+	}
+}
+
+// SetHelpers declares non-terminals (by name) as helpers, in addition to the
+// ones found in the grammar. The ASTs of helpers are spliced into their parents
+// in generic mode.
+func (ab *ASTBuilder) SetHelpers(nonterminals ...string) {
+	if ab.helpers == nil {
+		ab.helpers = make(map[string]bool, len(nonterminals))
+	}
+	for _, A := range nonterminals {
+		ab.helpers[A] = true
+	}
+}
+
+var defaultPunctuation = []string{"(", ")", "[", "]", "{", "}", ",", ";"}
+
+// genericRewrite creates a generic AST for a non-terminal without a rewriter.
+func (ab *ASTBuilder) genericRewrite(sym *lr.Symbol, rhs []*sppf.RuleNode, ctxt sppf.RuleCtxt) terex.Element {
+	rule := ab.G.Rule(ctxt.RuleIndex)
+	if rule != nil && len(rule.RHS()) == 1 && len(rhs) == 1 { // unit rule
+		if e, ok := rhs[0].Value.(terex.Element); ok {
+			return e
+		}
+		return terex.Elem(nil)
+	}
+	var list *terex.GCons
+	for _, r := range rhs {
+		if r.Symbol().IsTerminal() && ab.punctuation[r.Symbol().Name] {
+			continue
+		}
+		list = appendRHSResult(list, r)
+	}
+	if ab.helpers[sym.Name] || ab.found[sym.Name] {
+		return terex.Elem(list)
+	}
+	if rule != nil && rule.IsEps() {
+		return terex.Elem(nil)
+	}
+	op := &GenericOp{Symbol: sym, Rule: ctxt.RuleIndex, Span: ctxt.Span}
+	return terex.Elem(terex.Cons(terex.Atomize(op), list))
+}
+
+// findHelpers returns the non-terminals of a grammar implementing repetitions.
+// Every rule of a helper A is either an ε-rule, a rule recursive at either the
+// start or the end of its RHS, but not at both, or a base rule not containing A.
+// There has to be a recursive rule, and either A has an ε-rule and no base
+// rule, like Seq ➞ Item Seq | ε, or the rest of every recursive rule is the
+// RHS of a base rule, like Args ➞ Args , Arg | Arg. Punctuation is ignored for
+// this comparison.
+//
+// Thus for an expression grammar, Sum ➞ Sum + Product | Product is no helper,
+// unless + is punctuation. The artificial start symbol S' is a helper as well.
+func findHelpers(g *lr.Grammar, punctuation map[string]bool) map[string]bool {
+	type shape struct {
+		eps, other bool
+		recursive  []string        // rests of recursive rules
+		base       map[string]bool // RHSs of base rules
+	}
+	key := func(syms []*lr.Symbol) string { // names of symbols except punctuation
+		var b strings.Builder
+		for _, A := range syms {
+			if A.IsTerminal() && punctuation[A.Name] {
+				continue
+			}
+			b.WriteString(A.Name)
+			b.WriteByte(' ')
+		}
+		return b.String()
+	}
+	shapes := make(map[*lr.Symbol]*shape)
+	for i := 0; i < g.Size(); i++ {
+		r := g.Rule(i)
+		s, ok := shapes[r.LHS]
+		if !ok {
+			s = &shape{base: make(map[string]bool)}
+			shapes[r.LHS] = s
+		}
+		rhs := r.RHS()
+		switch {
+		case r.IsEps():
+			s.eps = true
+		case len(rhs) > 1 && rhs[0] == r.LHS && rhs[len(rhs)-1] != r.LHS:
+			s.recursive = append(s.recursive, key(rhs[1:]))
+		case len(rhs) > 1 && rhs[0] != r.LHS && rhs[len(rhs)-1] == r.LHS:
+			s.recursive = append(s.recursive, key(rhs[:len(rhs)-1]))
+		case !containsSymbol(rhs, r.LHS):
+			s.base[key(rhs)] = true
+		default:
+			s.other = true
+		}
+	}
+	helpers := make(map[string]bool)
+	if g.Size() > 0 { // S' ➞ S #eof
+		helpers[g.Rule(0).LHS.Name] = true
+	}
+	for A, s := range shapes {
+		if s.other || len(s.recursive) == 0 {
+			continue
+		}
+		list := len(s.base) > 0
+		for _, rest := range s.recursive {
+			list = list && s.base[rest]
+		}
+		if list || (s.eps && len(s.base) == 0) {
+			helpers[A.Name] = true
+		}
+	}
+	return helpers
+}
+
+func containsSymbol(syms []*lr.Symbol, A *lr.Symbol) bool {
+	for _, B := range syms {
+		if B == A {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package termr
+
+import (
+	"github.com/npillmayer/gorgo"
+	"github.com/npillmayer/gorgo/lr/sppf"
+	"github.com/npillmayer/gorgo/terex"
+)
+
+// ForestSExpr renders a parse forest as a TeREx s-expression, e.g. for
+// debugging or for golden tests. Every symbol node becomes a list, headed by an
+// operator named after the grammar symbol, with the children of its RHS.
+// Terminals are rendered as token atoms, with tokens taken from toks. If toks
+// is nil or does not provide a token, a token is made up from the terminal.
+//
+// Ambiguous nodes are rendered as a list headed by operator #amb, holding one
+// list per alternative:
+//
+//     (#S' (#amb (#E (#E …) :t(43) (#E …)) (#E (#E …) :t(42) (#E …))) :t(-1))
+//
+// A cyclic derivation is cut off by an operator atom for the symbol node
+// repeated.
+func ForestSExpr(forest *sppf.Forest, toks gorgo.TokenRetriever) *terex.GCons {
+	data := forest.Export()
+	if data.Root < 0 {
+		return nil
+	}
+	r := &sexprRenderer{
+		data:     data,
+		toks:     toks,
+		alts:     make([][]int, len(data.Symbols)),
+		children: make([][]int, len(data.RHS)),
+		lists:    make(map[int]*terex.GCons),
+		onPath:   make(map[int]bool),
+	}
+	for _, e := range data.Or {
+		r.alts[e.From] = append(r.alts[e.From], e.To)
+	}
+	for _, e := range data.And { // and-edges are exported in sequence
+		r.children[e.From] = append(r.children[e.From], e.To)
+	}
+	root := r.render(data.Root)
+	return root.Data.(*terex.GCons)
+}
+
+type sexprRenderer struct {
+	data     *sppf.ForestData
+	toks     gorgo.TokenRetriever
+	alts     [][]int // RHS-nodes per symbol node
+	children [][]int // symbol nodes per RHS-node
+	lists    map[int]*terex.GCons
+	onPath   map[int]bool
+}
+
+func (r *sexprRenderer) render(id int) terex.Atom {
+	sd := r.data.Symbols[id]
+	if sd.Terminal {
+		var t gorgo.Token
+		if r.toks != nil && sd.Token != nil {
+			t = r.toks(*sd.Token)
+		}
+		if t == nil {
+			t = ersatzToken{
+				kind:   gorgo.TokType(sd.Value),
+				lexeme: sd.Name,
+				span:   gorgo.Span{sd.Span[0], sd.Span[1]},
+			}
+		}
+		return terex.Atomize(t)
+	}
+	if l, ok := r.lists[id]; ok {
+		return terex.Atomize(l)
+	}
+	if r.onPath[id] {
+		return terex.Atomize(symbolOp(sd.Name))
+	}
+	r.onPath[id] = true
+	var alts []interface{}
+	for _, rhs := range r.alts[id] {
+		l := terex.List(symbolOp(sd.Name))
+		for _, child := range r.children[rhs] {
+			l = l.Append(terex.Cons(r.render(child), nil))
+		}
+		alts = append(alts, l)
+	}
+	delete(r.onPath, id)
+	var l *terex.GCons
+	if len(alts) == 1 {
+		l = alts[0].(*terex.GCons)
+	} else {
+		l = terex.List(append([]interface{}{symbolOp("amb")}, alts...)...)
+	}
+	r.lists[id] = l
+	return terex.Atomize(l)
+}
+
+// symbolOp is an operator standing for a grammar symbol in a rendered forest.
+type symbolOp string
+
+func (op symbolOp) String() string {
+	return string(op)
+}
+
+func (op symbolOp) Call(el terex.Element, env *terex.Environment) terex.Element {
+	return el
+}
@@ -77,6 +77,144 @@ func TestAST2(t *testing.T) {
 	}
 }
 
+func TestASTPruner(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.terex")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("TermR")
+	b.LHS("E").N("E").T("+", '+').N("E").End()
+	b.LHS("E").T("a", scanner.Ident).End()
+	G, _ := b.Grammar()
+	ga := lr.Analysis(G)
+	for _, test := range []struct {
+		pruner   sppf.Pruner
+		expected string
+	}{
+		{sppf.LeftAssociative(1), `((#E (#E (#E :t(-2)) :t(43) (#E :t(-2))) :t(43) (#E :t(-2))) :t(-1))`},
+		{sppf.RightAssociative(1), `((#E (#E :t(-2)) :t(43) (#E (#E :t(-2)) :t(43) (#E :t(-2)))) :t(-1))`},
+	} {
+		parser := earley.NewParser(ga, earley.GenerateTree(true))
+		input := strings.NewReader("a+a+a")
+		scanner := scanner.GoTokenizer("TestAST", input)
+		acc, err := parser.Parse(scanner, nil)
+		if !acc || err != nil {
+			t.Fatalf("parser could not parse input")
+		}
+		builder := NewASTBuilder(G)
+		builder.AddRewriter("E", makeOp("E"))
+		builder.SetPruner(test.pruner)
+		env := builder.AST(parser.ParseForest(), earleyTokenReceiver(parser))
+		if env == nil || env.AST == nil {
+			t.Errorf("AST is empty")
+		} else if env.AST.ListString() != test.expected {
+			t.Errorf("AST should be %s, is %s", test.expected, env.AST.ListString())
+		}
+	}
+}
+
+func TestForestSExpr(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.terex")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("TermR")
+	b.LHS("E").N("E").T("+", '+').N("E").End()
+	b.LHS("E").T("a", scanner.Ident).End()
+	G, _ := b.Grammar()
+	ga := lr.Analysis(G)
+	parser := earley.NewParser(ga, earley.GenerateTree(true))
+	input := strings.NewReader("a+a+a")
+	scanner := scanner.GoTokenizer("TestSExpr", input)
+	acc, err := parser.Parse(scanner, nil)
+	if !acc || err != nil {
+		t.Fatalf("parser could not parse input")
+	}
+	sexpr := ForestSExpr(parser.ParseForest(), earleyTokenReceiver(parser))
+	expected := `(#S' (#amb (#E (#E :t(-2)) :t(43) (#E (#E :t(-2)) :t(43) (#E :t(-2)))) (#E (#E (#E :t(-2)) :t(43) (#E :t(-2))) :t(43) (#E :t(-2)))) :t(-1))`
+	if sexpr.ListString() != expected {
+		t.Errorf("forest should render as %s, is %s", expected, sexpr.ListString())
+	}
+}
+
+func TestGenericAST(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.terex")
+	defer teardown()
+	//
+	b := lr.NewGrammarBuilder("TermR")
+	b.LHS("List").T("(", '(').N("Seq").T(")", ')').End()
+	b.LHS("Seq").N("Atom").N("Seq").End()
+	b.LHS("Seq").Epsilon()
+	b.LHS("Atom").T("id", scanner.Ident).End()
+	b.LHS("Atom").N("List").End()
+	G, _ := b.Grammar()
+	ga := lr.Analysis(G)
+	parser := earley.NewParser(ga, earley.GenerateTree(true))
+	input := strings.NewReader("(a (b c))")
+	scanner := scanner.GoTokenizer("TestGeneric", input)
+	acc, err := parser.Parse(scanner, nil)
+	if !acc || err != nil {
+		t.Fatalf("parser could not parse input")
+	}
+	builder := NewASTBuilder(G)
+	builder.SetGeneric(true)
+	env := builder.AST(parser.ParseForest(), earleyTokenReceiver(parser))
+	expected := `((#List :t(-2) (#List :t(-2) :t(-2))) :t(-1))`
+	if env == nil || env.AST == nil {
+		t.Fatalf("AST is empty")
+	}
+	if env.AST.ListString() != expected {
+		t.Errorf("AST should be %s, is %s", expected, env.AST.ListString())
+	}
+	list := env.AST.Car.Data.(*terex.GCons)
+	op, ok := list.Car.Data.(*GenericOp)
+	if !ok {
+		t.Fatalf("expected generic operator, have %v", list.Car)
+	}
+	if op.Symbol.Name != "List" || op.Span != (gorgo.Span{0, 7}) {
+		t.Errorf("expected operator for List at (0…7), have %s at %v", op, op.Span)
+	}
+}
+
+func TestGenericListHelpers(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "gorgo.terex")
+	defer teardown()
+	//
+	for _, leftRecursive := range []bool{true, false} {
+		b := lr.NewGrammarBuilder("TermR")
+		b.LHS("Call").T("id", scanner.Ident).T("(", '(').N("Args").T(")", ')').End()
+		if leftRecursive {
+			b.LHS("Args").N("Args").T(",", ',').N("Arg").End()
+		} else {
+			b.LHS("Args").N("Arg").T(",", ',').N("Args").End()
+		}
+		b.LHS("Args").N("Arg").End()
+		b.LHS("Arg").N("Sum").End()
+		b.LHS("Sum").N("Sum").T("+", '+').N("Term").End()
+		b.LHS("Sum").N("Term").End()
+		b.LHS("Term").T("id", scanner.Ident).End()
+		b.LHS("Term").N("Call").End()
+		G, _ := b.Grammar()
+		if helpers := findHelpers(G, map[string]bool{",": true}); !helpers["Args"] || helpers["Sum"] {
+			t.Errorf("expected Args to be a helper and Sum not to be, have %v", helpers)
+		}
+		parser := earley.NewParser(lr.Analysis(G), earley.GenerateTree(true))
+		input := strings.NewReader("f(a, g(b), c+d)")
+		acc, err := parser.Parse(scanner.GoTokenizer("TestGenericList", input), nil)
+		if !acc || err != nil {
+			t.Fatalf("parser could not parse input")
+		}
+		builder := NewASTBuilder(G)
+		builder.SetGeneric(true)
+		env := builder.AST(parser.ParseForest(), earleyTokenReceiver(parser))
+		expected := `((#Call :t(-2) :t(-2) (#Call :t(-2) :t(-2)) (#Sum :t(-2) :t(43) :t(-2))) :t(-1))`
+		if env == nil || env.AST == nil {
+			t.Fatalf("AST is empty")
+		}
+		if env.AST.ListString() != expected {
+			t.Errorf("AST should be %s, is %s", expected, env.AST.ListString())
+		}
+	}
+}
+
 func earleyTokenReceiver(parser *earley.Parser) gorgo.TokenRetriever {
 	return func(pos uint64) gorgo.Token {
 		return parser.TokenAt(pos)